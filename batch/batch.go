@@ -0,0 +1,465 @@
+package batch
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"../simulation"
+	log "github.com/sirupsen/logrus"
+)
+
+// Experiment runs a scenario a number of times with different seeds,
+// without the API or a visualisation, and writes the results to disk.
+type Experiment struct {
+	// scenario is the saved simulation each replication starts from.
+	scenario []byte
+	// duration is the number of simulated seconds each replication
+	// is run for.
+	duration float64
+	// replications is the number of times the scenario is run.
+	replications int
+	// seed is the seed of the first replication, each replication
+	// after uses the next seed.
+	seed int64
+	// workers is the number of replications run at the same time.
+	workers int
+	// interval is the number of seconds the metrics are aggregated
+	// over.
+	interval float64
+	// output is the directory the results are written to.
+	output string
+
+	// Logger is used to output the progress of the experiment
+	Logger *log.Entry
+}
+
+// Result is the summary of a single replication.
+type Result struct {
+	// Replication is the number of the replication, starting at 1.
+	Replication int `json:"replication"`
+	// Seed is the seed the replication was run with.
+	Seed int64 `json:"seed"`
+	// Ticks is the number of ticks that were run.
+	Ticks int `json:"ticks"`
+	// Time is the number of seconds that were simulated.
+	Time float64 `json:"time"`
+	// Spawned is the number of agents added to the network.
+	Spawned int `json:"spawned"`
+	// Arrived is the number of agents that reached their destination.
+	Arrived int `json:"arrived"`
+	// MeanVehicles is the average number of vehicles in the network.
+	MeanVehicles float64 `json:"meanVehicles"`
+	// MaxVehicles is the most vehicles in the network at once.
+	MaxVehicles int `json:"maxVehicles"`
+	// MeanSpeed is the average speed of the vehicles in metres per
+	// second.
+	MeanSpeed float64 `json:"meanSpeed"`
+	// Speed85 is the 85th percentile speed of the vehicles averaged
+	// over the ticks.
+	Speed85 float64 `json:"speed85"`
+	// MeanStopped is the average number of stopped vehicles.
+	MeanStopped float64 `json:"meanStopped"`
+	// Collisions is the number of collisions between vehicles.
+	Collisions int `json:"collisions"`
+	// RunTime is the number of seconds of real time the replication
+	// took to run.
+	RunTime float64 `json:"runTime"`
+	// Error describes why the replication failed, empty if it did not.
+	Error string `json:"error,omitempty"`
+}
+
+// measure is a summary value that is aggregated over the replications.
+type measure struct {
+	name  string
+	value func(r Result) float64
+}
+
+// measures are the summary values aggregated over the replications.
+var measures = []measure{
+	{"spawned", func(r Result) float64 { return float64(r.Spawned) }},
+	{"arrived", func(r Result) float64 { return float64(r.Arrived) }},
+	{"meanVehicles", func(r Result) float64 { return r.MeanVehicles }},
+	{"maxVehicles", func(r Result) float64 { return float64(r.MaxVehicles) }},
+	{"meanSpeed", func(r Result) float64 { return r.MeanSpeed }},
+	{"speed85", func(r Result) float64 { return r.Speed85 }},
+	{"meanStopped", func(r Result) float64 { return r.MeanStopped }},
+	{"collisions", func(r Result) float64 { return float64(r.Collisions) }},
+	{"runTime", func(r Result) float64 { return r.RunTime }}}
+
+// NewExperiment creates an experiment that runs the scenario file for the
+// given number of seconds, the given number of times. The file is either
+// a simulation saved with SaveFile or a JSON or YAML scenario. A duration
+// of 0 uses the scenario's duration, or defaultDuration if it has none.
+// The replications use the seeds counting up from seed, and as many as
+// workers are run at once. The metrics are aggregated over interval
+// seconds and the results are written to the output directory.
+func NewExperiment(scenario string, duration float64, replications int, seed int64, workers int, interval float64, output string) (*Experiment, error) {
+	if duration < 0 {
+		return nil, errors.New("the duration can not be negative")
+	}
+	if replications < 1 {
+		return nil, errors.New("there must be at least 1 replication")
+	}
+	if workers < 1 {
+		return nil, errors.New("there must be at least 1 worker")
+	}
+	if interval <= 0 {
+		return nil, errors.New("the metrics interval must be more than 0 seconds")
+	}
+
+	data, err := ioutil.ReadFile(scenario)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read scenario: %v", err)
+	}
+	// Saved simulations have a version, scenarios do not
+	var saved struct {
+		Version *int `json:"version"`
+	}
+	if json.Unmarshal(data, &saved) == nil && saved.Version != nil {
+		// Check the simulation loads before starting any replications
+		if _, err := simulation.LoadSimulation(bytes.NewReader(data)); err != nil {
+			return nil, fmt.Errorf("unable to load scenario: %v", err)
+		}
+	} else {
+		// The scenario is saved so each replication loads the same state
+		sc, err := simulation.ReadScenario(bytes.NewReader(data))
+		if err == nil {
+			data, err = saveScenario(sc)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to load scenario: %v", err)
+		}
+		if duration == 0 {
+			duration = sc.Duration
+		}
+	}
+	if duration < 0 {
+		return nil, errors.New("the duration can not be negative")
+	}
+	if duration == 0 {
+		duration = defaultDuration
+	}
+
+	e := &Experiment{
+		scenario:     data,
+		duration:     duration,
+		replications: replications,
+		seed:         seed,
+		workers:      workers,
+		interval:     interval,
+		output:       output}
+
+	e.Logger = log.WithFields(log.Fields{
+		"package":  "batch",
+		"scenario": scenario})
+
+	return e, nil
+}
+
+// saveScenario creates the simulation described by the scenario and
+// returns its saved form.
+func saveScenario(sc simulation.Scenario) ([]byte, error) {
+	sim, err := simulation.NewSimulationFromScenario(sc)
+	if err != nil {
+		return nil, err
+	}
+
+	var saved bytes.Buffer
+	if err := sim.Save(&saved); err != nil {
+		return nil, err
+	}
+	return saved.Bytes(), nil
+}
+
+// Run runs the replications and writes the results of each, and the
+// results aggregated over them, to the output directory. The results are
+// returned in order of replication. An error is returned if any of the
+// replications failed or the results could not be written.
+func (e *Experiment) Run() ([]Result, error) {
+	if err := os.MkdirAll(e.output, outputPermissions); err != nil {
+		return nil, fmt.Errorf("unable to create output directory: %v", err)
+	}
+
+	results := make([]Result, e.replications)
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < e.workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = e.runReplication(i + 1)
+			}
+		}()
+	}
+	for i := 0; i < e.replications; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	failed := 0
+	for _, r := range results {
+		if r.Error != "" {
+			failed++
+		}
+	}
+
+	if err := e.writeResults(results); err != nil {
+		return results, err
+	}
+	if err := e.writeSummary(results); err != nil {
+		return results, err
+	}
+
+	if failed > 0 {
+		return results, fmt.Errorf("%v of %v replications failed", failed, e.replications)
+	}
+	return results, nil
+}
+
+// runReplication runs the scenario once and writes its results. Any error
+// is stored in the result returned.
+func (e *Experiment) runReplication(replication int) Result {
+	result := Result{
+		Replication: replication,
+		Seed:        e.seed + int64(replication-1)}
+	e.Logger.Infof("Starting replication %v, seed: %v", replication, result.Seed)
+	started := time.Now()
+
+	sim, err := simulation.LoadSimulation(bytes.NewReader(e.scenario))
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	sim.SetSeed(result.Seed)
+
+	// Only the ticks run in this replication are measured
+	first := sim.GetTick() + 1
+	if err := sim.RunSteps(sim.StepsFor(e.duration)); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Ticks = sim.GetTick() - first + 1
+	result.Time = float64(result.Ticks) * sim.GetTimeStep()
+	result.RunTime = time.Since(started).Seconds()
+
+	// The whole run as a single interval
+	if overall, err := sim.GetMetricsIntervals(first, 0, result.Ticks); err == nil && len(overall) > 0 {
+		result.Spawned = overall[0].Spawned
+		result.Arrived = overall[0].Arrived
+		result.MeanVehicles = overall[0].MeanVehicles
+		result.MaxVehicles = overall[0].MaxVehicles
+		result.MeanSpeed = overall[0].MeanSpeed
+		result.Speed85 = overall[0].Speed85
+		result.MeanStopped = overall[0].MeanStopped
+	}
+	for _, c := range sim.GetCollisions() {
+		if c.Tick >= first {
+			result.Collisions++
+		}
+	}
+
+	if err := e.writeReplication(sim, first, result); err != nil {
+		result.Error = err.Error()
+	}
+	if result.Error != "" {
+		e.Logger.Errorf("Replication %v failed: %v", replication, result.Error)
+	} else {
+		e.Logger.Infof("Finished replication %v in %.1fs", replication, result.RunTime)
+	}
+	return result
+}
+
+// writeReplication writes the summary, metrics, detector records and
+// collisions of a replication to its own directory.
+func (e *Experiment) writeReplication(sim *simulation.Simulation, first int, result Result) error {
+	dir := filepath.Join(e.output, fmt.Sprintf(replicationDir, result.Replication))
+	if err := os.MkdirAll(dir, outputPermissions); err != nil {
+		return fmt.Errorf("unable to create replication directory: %v", err)
+	}
+
+	summary, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "result.json"), summary, 0644); err != nil {
+		return fmt.Errorf("unable to write result: %v", err)
+	}
+
+	intervals, err := sim.GetMetricsIntervals(first, 0, sim.StepsFor(e.interval))
+	if err != nil {
+		return err
+	}
+	if err := writeCSV(filepath.Join(dir, "metrics.csv"), metricsRows(intervals)); err != nil {
+		return err
+	}
+
+	if err := writeCSV(filepath.Join(dir, "collisions.csv"), collisionRows(sim.GetCollisions(), first)); err != nil {
+		return err
+	}
+
+	for _, d := range sim.GetDetectors() {
+		f, err := os.Create(filepath.Join(dir, fmt.Sprintf("detector-%v.csv", d.GetID())))
+		if err != nil {
+			return fmt.Errorf("unable to write detector %v: %v", d.GetID(), err)
+		}
+		err = d.ExportCSV(f, true)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeResults writes a row for the summary of each replication.
+func (e *Experiment) writeResults(results []Result) error {
+	rows := [][]string{{"replication", "seed", "ticks", "time", "spawned", "arrived", "meanVehicles", "maxVehicles", "meanSpeed", "speed85", "meanStopped", "collisions", "runTime", "error"}}
+	for _, r := range results {
+		rows = append(rows, []string{
+			strconv.Itoa(r.Replication),
+			strconv.FormatInt(r.Seed, 10),
+			strconv.Itoa(r.Ticks),
+			format(r.Time),
+			strconv.Itoa(r.Spawned),
+			strconv.Itoa(r.Arrived),
+			format(r.MeanVehicles),
+			strconv.Itoa(r.MaxVehicles),
+			format(r.MeanSpeed),
+			format(r.Speed85),
+			format(r.MeanStopped),
+			strconv.Itoa(r.Collisions),
+			format(r.RunTime),
+			r.Error})
+	}
+	return writeCSV(filepath.Join(e.output, resultsFile), rows)
+}
+
+// writeSummary writes the mean, standard deviation, minimum and maximum
+// of each measure over the replications that did not fail.
+func (e *Experiment) writeSummary(results []Result) error {
+	var succeeded []Result
+	for _, r := range results {
+		if r.Error == "" {
+			succeeded = append(succeeded, r)
+		}
+	}
+
+	rows := [][]string{{"measure", "replications", "mean", "stdDev", "min", "max"}}
+	for _, m := range measures {
+		var values []float64
+		for _, r := range succeeded {
+			values = append(values, m.value(r))
+		}
+		mean, stdDev, min, max := describe(values)
+		rows = append(rows, []string{
+			m.name,
+			strconv.Itoa(len(values)),
+			format(mean),
+			format(stdDev),
+			format(min),
+			format(max)})
+	}
+	return writeCSV(filepath.Join(e.output, summaryFile), rows)
+}
+
+// describe returns the mean, sample standard deviation, minimum and
+// maximum of the values. Every value is 0 if there are no values.
+func describe(values []float64) (mean, stdDev, min, max float64) {
+	if len(values) == 0 {
+		return
+	}
+
+	min, max = values[0], values[0]
+	for _, v := range values {
+		mean += v
+		min = math.Min(min, v)
+		max = math.Max(max, v)
+	}
+	mean /= float64(len(values))
+
+	if len(values) > 1 {
+		for _, v := range values {
+			stdDev += (v - mean) * (v - mean)
+		}
+		stdDev = math.Sqrt(stdDev / float64(len(values)-1))
+	}
+	return
+}
+
+// metricsRows converts the metrics intervals into CSV rows.
+func metricsRows(intervals []simulation.MetricsInterval) [][]string {
+	rows := [][]string{{"start", "end", "startTime", "endTime", "meanVehicles", "maxVehicles", "meanPedestrians", "spawned", "arrived", "meanSpeed", "speed50", "speed85", "speed95", "meanStopped"}}
+	for _, i := range intervals {
+		rows = append(rows, []string{
+			strconv.Itoa(i.Start),
+			strconv.Itoa(i.End),
+			format(i.StartTime),
+			format(i.EndTime),
+			format(i.MeanVehicles),
+			strconv.Itoa(i.MaxVehicles),
+			format(i.MeanPedestrians),
+			strconv.Itoa(i.Spawned),
+			strconv.Itoa(i.Arrived),
+			format(i.MeanSpeed),
+			format(i.Speed50),
+			format(i.Speed85),
+			format(i.Speed95),
+			format(i.MeanStopped)})
+	}
+	return rows
+}
+
+// collisionRows converts the collisions from the first tick given
+// onwards into CSV rows.
+func collisionRows(collisions []simulation.Collision, first int) [][]string {
+	rows := [][]string{{"tick", "time", "x", "y", "first", "second", "firstType", "secondType"}}
+	for _, c := range collisions {
+		if c.Tick < first {
+			continue
+		}
+		position := c.Position.ConvertToSlice()
+		rows = append(rows, []string{
+			strconv.Itoa(c.Tick),
+			format(c.Time),
+			format(position[0]),
+			format(position[1]),
+			strconv.Itoa(c.Agents[0]),
+			strconv.Itoa(c.Agents[1]),
+			c.Types[0],
+			c.Types[1]})
+	}
+	return rows
+}
+
+// writeCSV writes the rows to a new file with the given name.
+func writeCSV(fileName string, rows [][]string) error {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return fmt.Errorf("unable to create %v: %v", fileName, err)
+	}
+	defer f.Close()
+
+	out := csv.NewWriter(f)
+	out.WriteAll(rows)
+	if err := out.Error(); err != nil {
+		return fmt.Errorf("unable to write %v: %v", fileName, err)
+	}
+	return nil
+}
+
+// format converts a number to a string without losing precision.
+func format(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
@@ -0,0 +1,21 @@
+package batch
+
+// defaultDuration is the number of simulated seconds each replication
+// runs for when neither the experiment nor its scenario give one.
+const defaultDuration = 3600.0
+
+// resultsFile is the name of the file storing the summary of each
+// replication.
+const resultsFile = "results.csv"
+
+// summaryFile is the name of the file storing the summary measures
+// aggregated over the replications.
+const summaryFile = "summary.csv"
+
+// replicationDir is the format of the name of the directory each
+// replication's results are written to.
+const replicationDir = "replication-%03d"
+
+// outputPermissions are the permissions the results directories are
+// created with.
+const outputPermissions = 0755
@@ -1,5 +1,7 @@
 package main
 
+import log "github.com/sirupsen/logrus"
+
 // serverAddr is the port at which the server can be accessed
 // e.g. "127.0.0.1:8080" (aka "localhost:8080")
 const serverAddr = ":8080"
@@ -10,3 +12,23 @@ const unityAddr = ":6666"
 
 // testEnv is the path to a shape file to be used whilst debuging
 const testEnv = "resources/test.shp"
+
+// defaultReplications is the number of times a batch experiment runs its
+// scenario when none is given.
+const defaultReplications = 10
+
+// defaultSeed is the seed of the first replication of a batch experiment
+// when none is given.
+const defaultSeed = 1
+
+// defaultInterval is the number of seconds a batch experiment's metrics are
+// aggregated over when none is given.
+const defaultInterval = 300
+
+// defaultOutput is the directory a batch experiment's results are written
+// to when none is given.
+const defaultOutput = "results"
+
+// batchLogLevel is the lowest level of log displayed while a batch
+// experiment runs.
+const batchLogLevel = log.WarnLevel
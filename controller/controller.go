@@ -27,8 +27,8 @@ type Controller struct {
 }
 
 // NewController creates a new controller struct
-func NewController(apiPort, unityPort string) (Controller, error) {
-	var c Controller
+func NewController(apiPort, unityPort string) (*Controller, error) {
+	c := &Controller{}
 
 	c.setup(apiPort)
 	c.unityViewer = view.NewUnityServer(unityPort)
@@ -60,12 +60,37 @@ func (c *Controller) setup(port string) {
 
 	// simulation endpoints
 	router.HandleFunc("/simulation/new", c.newSimulation).Methods("POST")
+	router.HandleFunc("/simulation/new/scenario", c.newScenarioSimulation).Methods("POST")
+	router.HandleFunc("/simulation/agent-types", c.getAgentTypes).Methods("GET")
+	router.HandleFunc("/simulation/load", c.loadSimulation).Methods("POST")
+	router.HandleFunc("/simulation/save/{id}", c.saveSimulation).Methods("POST")
+	router.HandleFunc("/simulation/scenario/export/{id}", c.exportScenario).Methods("POST")
 	router.HandleFunc("/simulation/remove/{id}", c.removeSimulation).Methods("GET")
 	router.HandleFunc("/simulation/run/{id}", c.runSimulation).Methods("POST")
 	router.HandleFunc("/simulation/stop/{id}", c.stopSimulation).Methods("GET")
+	router.HandleFunc("/simulation/pause/{id}", c.pauseSimulation).Methods("GET")
+	router.HandleFunc("/simulation/resume/{id}", c.resumeSimulation).Methods("GET")
+	router.HandleFunc("/simulation/status/{id}", c.getStatus).Methods("GET")
 	router.HandleFunc("/simulation/add/{id}", c.addAgent).Methods("POST")
+	router.HandleFunc("/simulation/parameters/{id}", c.setParameters).Methods("POST")
 	router.HandleFunc("/simulation/light/add/{id}", c.addLight).Methods("POST")
 	router.HandleFunc("/simulation/light/update/{id}", c.updateLight).Methods("POST")
+	router.HandleFunc("/simulation/signal/add/{id}", c.addSignal).Methods("POST")
+	router.HandleFunc("/simulation/signal/mode/{id}", c.updateSignalMode).Methods("POST")
+	router.HandleFunc("/simulation/detector/add/{id}", c.addDetector).Methods("POST")
+	router.HandleFunc("/simulation/detector/export/{id}/{detectorId}", c.exportDetector).Methods("GET")
+	router.HandleFunc("/simulation/detector/{id}/{detectorId}", c.getDetectorInfo).Methods("GET")
+	router.HandleFunc("/simulation/crosswalk/add/{id}", c.addCrosswalk).Methods("POST")
+	router.HandleFunc("/simulation/junction/add/{id}", c.addJunction).Methods("POST")
+	router.HandleFunc("/simulation/junction/default/{id}", c.setDefaultJunction).Methods("POST")
+	router.HandleFunc("/simulation/busstop/add/{id}", c.addBusStop).Methods("POST")
+	router.HandleFunc("/simulation/busline/add/{id}", c.addBusLine).Methods("POST")
+	router.HandleFunc("/simulation/busline/{id}/{lineId}", c.getBusReport).Methods("GET")
+	router.HandleFunc("/simulation/demand/add/{id}", c.addDemand).Methods("POST")
+	router.HandleFunc("/simulation/demand/{id}/{demandId}", c.getDemandReport).Methods("GET")
+	router.HandleFunc("/simulation/metrics/{id}", c.getMetrics).Methods("GET")
+	router.HandleFunc("/simulation/collisions/{id}", c.getCollisions).Methods("GET")
+	router.HandleFunc("/simulation/trajectories/{id}", c.exportTrajectories).Methods("GET")
 	router.HandleFunc("/simulation/info/agent/{id}/{agentId}", c.getAgentInfo).Methods("GET")
 	router.HandleFunc("/simulation/info/{id}", c.getInfo).Methods("GET")
 	router.HandleFunc("/simulation/view/{id}", c.getImage).Methods("POST")
@@ -2,9 +2,11 @@ package controller
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html"
 	"io/ioutil"
@@ -46,6 +48,12 @@ func (c *Controller) Shutdown(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Stop any simulations running in the background
+	c.simulations.Range(func(key, i interface{}) bool {
+		i.(*simulation.Simulation).Stop()
+		return true
+	})
+
 	// Close the unity server connection
 	c.unityViewer.StopServer()
 
@@ -64,6 +72,24 @@ func (c *Controller) newSimulation(w http.ResponseWriter, r *http.Request) {
 		Environment string `json:"environment"`
 		// Lights stores a list of x,y coordinates of the traffic lights
 		Lights [][]float64 `json:"lights"`
+		// StartTime is the time of day, in seconds after midnight,
+		// the simulation starts at
+		StartTime int `json:"startTime"`
+		// Seed is used to seed the simulation's random number
+		// generator, if nil a seed is chosen
+		Seed *int64 `json:"seed"`
+		// RecordTrajectories is true if the position of every agent
+		// should be recorded at each tick
+		RecordTrajectories bool `json:"recordTrajectories"`
+		// HaltOnCollision is true if the simulation should stop
+		// running when vehicles collide
+		HaltOnCollision bool `json:"haltOnCollision"`
+		// TimeStep is the number of seconds each tick simulates,
+		// 0 uses one second
+		TimeStep float64 `json:"timeStep"`
+		// Parameters control how the agents behave, any not
+		// given use their defaults
+		Parameters simulation.Parameters `json:"parameters"`
 	}
 
 	// response is the information sent back to the client
@@ -81,35 +107,35 @@ func (c *Controller) newSimulation(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// parse the setup data
-	var simInfo info
+	simInfo := info{Parameters: simulation.DefaultParameters()}
 	_ = json.NewDecoder(r.Body).Decode(&simInfo)
 
 	// create response type to fill
 	var resp response
 
 	// Generate a unique key for the simulation
-	var key string
-	for {
-		// Generate a random string key
-		key = ""
-		for i := 0; i < keyLength; i++ {
-			// 97{a} - 122{z}
-			key += string(rand.Intn(26) + 97)
-		}
-
-		// Check of key is already in use
-		if _, ok := c.simulations.Load(key); !ok {
-			break
-		}
-	}
-	resp.Key = key
+	key := c.generateKey()
 
 	// Generate the simulation environment
 	env := simulation.NewEnvironment()
 
 	// env.WriteShapeFile("resources/test.shp")
 	c.Logger.Debugf("Env Filepath: %v", simInfo.Environment)
-	env.ReadShapefile(simInfo.Environment)
+	err := env.ReadShapefile(simInfo.Environment)
+	if err != nil {
+		// The environment could not be read send error
+		resp.Success = false
+		resp.Error = "Unable to read environment - " + err.Error()
+
+		// Encode response into json
+		jsonStr, _ := json.Marshal(resp)
+
+		// Send response
+		fmt.Fprint(w, string(jsonStr))
+
+		c.Logger.Warnf("Unable to read environment: %v", err)
+		return
+	}
 
 	// Add traffic lights to the enironment
 	for i := 0; i < len(simInfo.Lights); i++ {
@@ -123,6 +149,43 @@ func (c *Controller) newSimulation(w http.ResponseWriter, r *http.Request) {
 
 	// Create the simulation
 	sim := simulation.NewSimulation(env)
+	sim.SetStartTime(simInfo.StartTime)
+	if simInfo.Seed != nil {
+		sim.SetSeed(*simInfo.Seed)
+	}
+	sim.SetRecordTrajectories(simInfo.RecordTrajectories)
+	sim.SetHaltOnCollision(simInfo.HaltOnCollision)
+	if simInfo.TimeStep != 0 {
+		if err := sim.SetTimeStep(simInfo.TimeStep); err != nil {
+			// The time step is not valid send error
+			resp.Success = false
+			resp.Error = "Unable to set time step - " + err.Error()
+
+			// Encode response into json
+			jsonStr, _ := json.Marshal(resp)
+
+			// Send response
+			fmt.Fprint(w, string(jsonStr))
+
+			c.Logger.Warnf("Unable to set time step: %v", err)
+			return
+		}
+	}
+	if err := sim.SetParameters(simInfo.Parameters); err != nil {
+		// The parameters are not valid send error
+		resp.Success = false
+		resp.Error = "Unable to set parameters - " + err.Error()
+
+		// Encode response into json
+		jsonStr, _ := json.Marshal(resp)
+
+		// Send response
+		fmt.Fprint(w, string(jsonStr))
+
+		c.Logger.Warnf("Unable to set parameters: %v", err)
+		return
+	}
+	resp.Key = key
 
 	// Add the simulation to the map
 	c.simulations.Store(key, sim)
@@ -137,16 +200,42 @@ func (c *Controller) newSimulation(w http.ResponseWriter, r *http.Request) {
 	c.Logger.Infof("New Simulation Created: %v", key)
 }
 
-// removeSimulation removes a specific simulation from the server.
-func (c *Controller) removeSimulation(w http.ResponseWriter, r *http.Request) {
+// generateKey creates a random key that is not used by any of the
+// simulations.
+func (c *Controller) generateKey() string {
+	var key string
+	for {
+		// Generate a random string key
+		key = ""
+		for i := 0; i < keyLength; i++ {
+			// 97{a} - 122{z}
+			key += string(rune(rand.Intn(26) + 97))
+		}
+
+		// Check of key is already in use
+		if _, ok := c.simulations.Load(key); !ok {
+			return key
+		}
+	}
+}
+
+// saveSimulation writes the complete state of a specified simulation
+// to a file on the server.
+func (c *Controller) saveSimulation(w http.ResponseWriter, r *http.Request) {
+	type info struct {
+		// Filepath is where the simulation should be saved
+		Filepath string `json:"filepath"`
+	}
+
+	var resp response
+
 	// Get the id from the url
 	params := mux.Vars(r)
 	id := params["id"]
 
-	var resp response
-
 	// Check if the id exists
-	if _, ok := c.simulations.Load(id); !ok {
+	i, ok := c.simulations.Load(id)
+	if !ok {
 		// No Simulation found send error
 		resp.Success = false
 		resp.Error = "No Simulation found with the id - " + id
@@ -161,10 +250,28 @@ func (c *Controller) removeSimulation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// remove the simulation from the server.
-	c.simulations.Delete(id)
+	// parse the save data
+	var saveInfo info
+	_ = json.NewDecoder(r.Body).Decode(&saveInfo)
+
+	sim := i.(*simulation.Simulation)
+
+	err := sim.SaveFile(saveInfo.Filepath)
+	if err != nil {
+		// The simulation could not be saved send error
+		resp.Success = false
+		resp.Error = "Unable to save simulation - " + err.Error()
+
+		// Encode response into json
+		jsonStr, _ := json.Marshal(resp)
+
+		// Send response
+		fmt.Fprint(w, string(jsonStr))
+
+		c.Logger.Warnf("Unable to save simulation: %v", err)
+		return
+	}
 
-	// No Simulation found send error
 	resp.Success = true
 
 	// Encode response into json
@@ -172,35 +279,47 @@ func (c *Controller) removeSimulation(w http.ResponseWriter, r *http.Request) {
 
 	// Send response
 	fmt.Fprint(w, string(jsonStr))
-	c.Logger.Infof("Simulation Removed: %v", id)
-	return
+
+	c.Logger.Infof("Simulation %v saved: %v", id, saveInfo.Filepath)
 }
 
-// runSimulation runs a specified simulation.
-func (c *Controller) runSimulation(w http.ResponseWriter, r *http.Request) {
+// loadSimulation reads a saved simulation from a file on the server and
+// adds it to the controller. If a key is given the simulation is stored
+// under that key, replacing any simulation already using it.
+func (c *Controller) loadSimulation(w http.ResponseWriter, r *http.Request) {
 	type info struct {
-		// Steps is the number of steps that the simulation needs to run.
-		// If the number is negative the simulation should run until
-		// it is told to stop.
-		Steps int `json:"steps"`
+		// Filepath is where the simulation was saved
+		Filepath string `json:"filepath"`
+		// Key is the key the simulation should be stored under,
+		// if empty a new key is generated
+		Key string `json:"key"`
 	}
 
-	// parse the setup data
-	var cmdInfo info
-	_ = json.NewDecoder(r.Body).Decode(&cmdInfo)
+	// response is the information sent back to the client
+	// after the request has been executed.
+	type response struct {
+		// Key stores the unique key given to the simulation
+		// loaded
+		Key string `json:"key"`
+		// Success is bool that is true if the simulation
+		// was loaded
+		Success bool `json:"success"`
+		// Error is a string that is filled if an error occurs
+		// while loading the simulation.
+		Error string `json:"error"`
+	}
 
-	// Create response for client
 	var resp response
 
-	// Get the id from the url
-	params := mux.Vars(r)
-	id := params["id"]
+	// parse the load data
+	var loadInfo info
+	_ = json.NewDecoder(r.Body).Decode(&loadInfo)
 
-	// Check if the id exists
-	if _, ok := c.simulations.Load(id); !ok {
-		// No Simulation found send error
+	sim, err := simulation.LoadSimulationFile(loadInfo.Filepath)
+	if err != nil {
+		// The simulation could not be loaded send error
 		resp.Success = false
-		resp.Error = "No Simulation found with the id - " + id
+		resp.Error = "Unable to load simulation - " + err.Error()
 
 		// Encode response into json
 		jsonStr, _ := json.Marshal(resp)
@@ -208,34 +327,19 @@ func (c *Controller) runSimulation(w http.ResponseWriter, r *http.Request) {
 		// Send response
 		fmt.Fprint(w, string(jsonStr))
 
-		c.Logger.Warnf("No Simulation found with id: %v", id)
+		c.Logger.Warnf("Unable to load simulation: %v", err)
 		return
 	}
 
-	// Get the simulation and run for specifed number of steps
-	i, _ := c.simulations.Load(id)
-	sim := i.(simulation.Simulation)
-
-	// Check if the number of steps to run is positive
-	if cmdInfo.Steps > -1 {
-		sim.RunSteps(cmdInfo.Steps)
-
-		c.simulations.Store(id, sim)
-	} else {
-		// No Simulation found send error
-		resp.Success = false
-		resp.Error = "Can not have negative Step value (yet)"
-
-		// Encode response into json
-		jsonStr, _ := json.Marshal(resp)
-
-		// Send response
-		fmt.Fprint(w, string(jsonStr))
-
-		c.Logger.Warnf("Negative Step value: %v", cmdInfo.Steps)
-		return
+	key := loadInfo.Key
+	if key == "" {
+		key = c.generateKey()
 	}
 
+	// Add the simulation to the map
+	c.simulations.Store(key, sim)
+
+	resp.Key = key
 	resp.Success = true
 
 	// Encode response into json
@@ -244,22 +348,63 @@ func (c *Controller) runSimulation(w http.ResponseWriter, r *http.Request) {
 	// Send response
 	fmt.Fprint(w, string(jsonStr))
 
-	c.Logger.Debugf("Sim: %v Run for %v steps", id, cmdInfo.Steps)
+	c.Logger.Infof("Simulation %v loaded: %v", key, loadInfo.Filepath)
 }
 
-// stopSimulation stops a specified simulation.
-func (c *Controller) stopSimulation(w http.ResponseWriter, r *http.Request) {
+// newScenarioSimulation creates a simulation from a scenario and adds it
+// to the controller. The scenario is either read from a file on the
+// server or sent as part of the request.
+func (c *Controller) newScenarioSimulation(w http.ResponseWriter, r *http.Request) {
+	type info struct {
+		// Filepath is the JSON or YAML file storing the scenario
+		Filepath string `json:"filepath"`
+		// Scenario is the scenario to create, used if no filepath
+		// is given
+		Scenario json.RawMessage `json:"scenario"`
+	}
+
+	// response is the information sent back to the client
+	// after the request has been executed.
+	type response struct {
+		// Key stores the unique key given to the simulation
+		// created
+		Key string `json:"key"`
+		// Duration is the number of seconds the scenario should
+		// be run for, 0 if it does not say
+		Duration float64 `json:"duration"`
+		// Success is bool that is true if a new sim has been
+		// created
+		Success bool `json:"success"`
+		// Error is a string that is filled if an error occurs
+		// while creating a new simulation.
+		Error string `json:"error"`
+	}
+
 	var resp response
 
-	// Get the id from the url
-	params := mux.Vars(r)
-	id := params["id"]
+	// parse the scenario data
+	var scenarioInfo info
+	_ = json.NewDecoder(r.Body).Decode(&scenarioInfo)
+
+	var scenario simulation.Scenario
+	var err error
+	switch {
+	case scenarioInfo.Filepath != "":
+		scenario, err = simulation.ReadScenarioFile(scenarioInfo.Filepath)
+	case len(scenarioInfo.Scenario) > 0:
+		scenario, err = simulation.ReadScenario(bytes.NewReader(scenarioInfo.Scenario))
+	default:
+		err = errors.New("a filepath or scenario is required")
+	}
 
-	// Check if the id exists
-	if _, ok := c.simulations.Load(id); !ok {
-		// No Simulation found send error
+	var sim *simulation.Simulation
+	if err == nil {
+		sim, err = simulation.NewSimulationFromScenario(scenario)
+	}
+	if err != nil {
+		// The scenario could not be created send error
 		resp.Success = false
-		resp.Error = "No Simulation found with the id - " + id
+		resp.Error = "Unable to create scenario - " + err.Error()
 
 		// Encode response into json
 		jsonStr, _ := json.Marshal(resp)
@@ -267,16 +412,18 @@ func (c *Controller) stopSimulation(w http.ResponseWriter, r *http.Request) {
 		// Send response
 		fmt.Fprint(w, string(jsonStr))
 
-		c.Logger.Warnf("No Simulation found with id: %v", id)
+		c.Logger.Warnf("Unable to create scenario: %v", err)
 		return
 	}
 
-	// Stop the simulation
-	i, _ := c.simulations.Load(id)
-	sim := i.(simulation.Simulation)
-	sim.Stop()
-	c.simulations.Store(id, sim)
+	// Generate a unique key for the simulation
+	key := c.generateKey()
+
+	// Add the simulation to the map
+	c.simulations.Store(key, sim)
 
+	resp.Key = key
+	resp.Duration = scenario.Duration
 	resp.Success = true
 
 	// Encode response into json
@@ -285,34 +432,32 @@ func (c *Controller) stopSimulation(w http.ResponseWriter, r *http.Request) {
 	// Send response
 	fmt.Fprint(w, string(jsonStr))
 
-	c.Logger.Debugf("Sim stopped: %v", id)
+	c.Logger.Infof("New Simulation Created from scenario: %v", key)
 }
 
-// addAgent adds agents to a specified simulation.
-func (c *Controller) addAgent(w http.ResponseWriter, r *http.Request) {
-	type agentInfo struct {
-		StartLocation []float64   `json:"startLocation"`
-		StartSpeed    float64     `json:"startSpeed"`
-		MaxSpeed      float64     `json:"maxSpeed"`
-		Acceleration  float64     `json:"acceleration"`
-		Deceleration  float64     `json:"deceleration"`
-		Route         [][]float64 `json:"route"`
-		Type          string      `json:"type"`
-		Frequency     int         `json:"frequency"`
-	}
-
+// exportScenario writes a scenario that sets up a simulation like the
+// specified one to a file on the server.
+func (c *Controller) exportScenario(w http.ResponseWriter, r *http.Request) {
 	type info struct {
-		Agents []agentInfo `json:"agents"`
+		// Filepath is where the scenario should be written
+		Filepath string `json:"filepath"`
+		// Format is either json or yaml, if empty it is chosen
+		// from the file's extension
+		Format string `json:"format"`
+		// Duration is the number of seconds the scenario should
+		// be run for
+		Duration float64 `json:"duration"`
 	}
 
 	var resp response
 
-	// Get the id and type from the url
+	// Get the id from the url
 	params := mux.Vars(r)
 	id := params["id"]
 
 	// Check if the id exists
-	if _, ok := c.simulations.Load(id); !ok {
+	i, ok := c.simulations.Load(id)
+	if !ok {
 		// No Simulation found send error
 		resp.Success = false
 		resp.Error = "No Simulation found with the id - " + id
@@ -327,53 +472,32 @@ func (c *Controller) addAgent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Parse the agent data
-	var agentsInfo info
-	_ = json.NewDecoder(r.Body).Decode(&agentsInfo)
-
-	// Get the simulation from the map
-	i, _ := c.simulations.Load(id)
-	sim := i.(simulation.Simulation)
-
-	// Create and add new agent for each of the agents information given
-	for _, agent := range agentsInfo.Agents {
-		switch agent.Type {
-		case "vehicle":
-			// Convert []float64 to Vector for starting location
-			startLoc := simulation.NewVector(agent.StartLocation[0], agent.StartLocation[0])
-
-			// convert [][]float64 to list of Vectors for route
-			var route []simulation.Vector
-			for i := 0; i < len(agent.Route); i++ {
-				newWaypoint := simulation.NewVector(agent.Route[i][0], agent.Route[i][1])
-				route = append(route, newWaypoint)
-			}
+	// parse the export data
+	var exportInfo info
+	_ = json.NewDecoder(r.Body).Decode(&exportInfo)
 
-			// Create a vehicle
-			newAgent := simulation.NewVehicle(
-				-1,
-				startLoc,
-				agent.StartSpeed,
-				agent.MaxSpeed,
-				agent.Acceleration,
-				agent.Deceleration,
-				route,
-				agent.Frequency)
+	sim := i.(*simulation.Simulation)
 
-			sim.AddAgent(newAgent)
+	scenario, err := sim.GetScenario()
+	if err == nil {
+		scenario.Duration = exportInfo.Duration
+		err = scenario.WriteFile(exportInfo.Filepath, exportInfo.Format)
+	}
+	if err != nil {
+		// The scenario could not be written send error
+		resp.Success = false
+		resp.Error = "Unable to export scenario - " + err.Error()
 
-		default:
-			// No agent of that type
-			resp.Error += "No agent of that type found - " + agent.Type + "\n"
+		// Encode response into json
+		jsonStr, _ := json.Marshal(resp)
 
-			c.Logger.Warnf("Incorrect agent type given: %v", agent.Type)
-		}
+		// Send response
+		fmt.Fprint(w, string(jsonStr))
 
+		c.Logger.Warnf("Unable to export scenario: %v", err)
+		return
 	}
 
-	// Store the new simulation with new vehicles
-	c.simulations.Store(id, sim)
-
 	resp.Success = true
 
 	// Encode response into json
@@ -382,24 +506,20 @@ func (c *Controller) addAgent(w http.ResponseWriter, r *http.Request) {
 	// Send response
 	fmt.Fprint(w, string(jsonStr))
 
-	c.Logger.Infof("Agents been added to sim: %v", id)
+	c.Logger.Infof("Simulation %v exported as a scenario: %v", id, exportInfo.Filepath)
 }
 
-// addLight adds a new traffic light to a given simulation.
-func (c *Controller) addLight(w http.ResponseWriter, r *http.Request) {
-	type info struct {
-		Position []float64 `json:"position"`
-		Stop     bool      `json:"stop"`
-	}
-
-	// Get the id and type from the url
+// removeSimulation removes a specific simulation from the server.
+func (c *Controller) removeSimulation(w http.ResponseWriter, r *http.Request) {
+	// Get the id from the url
 	params := mux.Vars(r)
 	id := params["id"]
 
 	var resp response
 
 	// Check if the id exists
-	if _, ok := c.simulations.Load(id); !ok {
+	i, ok := c.simulations.Load(id)
+	if !ok {
 		// No Simulation found send error
 		resp.Success = false
 		resp.Error = "No Simulation found with the id - " + id
@@ -414,18 +534,12 @@ func (c *Controller) addLight(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Parse the agent data
-	var lightInfo info
-	_ = json.NewDecoder(r.Body).Decode(&lightInfo)
-
-	// Get the simulation from the map
-	i, _ := c.simulations.Load(id)
-	sim := i.(simulation.Simulation)
-
-	sim.AddLight(simulation.NewVector(lightInfo.Position[0], lightInfo.Position[1]), lightInfo.Stop)
-
-	c.simulations.Store(id, sim)
+	// Stop the simulation if it is running in the background
+	// and remove the simulation from the server.
+	i.(*simulation.Simulation).Stop()
+	c.simulations.Delete(id)
 
+	// No Simulation found send error
 	resp.Success = true
 
 	// Encode response into json
@@ -433,25 +547,43 @@ func (c *Controller) addLight(w http.ResponseWriter, r *http.Request) {
 
 	// Send response
 	fmt.Fprint(w, string(jsonStr))
-	c.Logger.Debug("Light added")
+	c.Logger.Infof("Simulation Removed: %v", id)
 	return
 }
 
-// updateLight updates a traffic light in a given simulation.
-func (c *Controller) updateLight(w http.ResponseWriter, r *http.Request) {
+// runSimulation runs a specified simulation.
+func (c *Controller) runSimulation(w http.ResponseWriter, r *http.Request) {
 	type info struct {
-		ID   int  `json:"id"`
-		Stop bool `json:"stop"`
+		// Steps is the number of steps that the simulation needs to run.
+		// If the number is negative the simulation should run until
+		// it is told to stop.
+		Steps int `json:"steps"`
+		// Seconds is the number of simulated seconds to run for,
+		// used instead of the steps if it is more than 0.
+		Seconds float64 `json:"seconds"`
+		// Background is true if the response should be sent straight
+		// away while the simulation runs. Negative steps always run in
+		// the background.
+		Background bool `json:"background"`
+		// TicksPerSecond limits how many ticks a background simulation
+		// runs every second, 0 runs as fast as possible.
+		TicksPerSecond float64 `json:"ticksPerSecond"`
 	}
 
-	// Get the id and type from the url
-	params := mux.Vars(r)
-	id := params["id"]
+	// parse the setup data
+	var cmdInfo info
+	_ = json.NewDecoder(r.Body).Decode(&cmdInfo)
 
+	// Create response for client
 	var resp response
 
+	// Get the id from the url
+	params := mux.Vars(r)
+	id := params["id"]
+
 	// Check if the id exists
-	if _, ok := c.simulations.Load(id); !ok {
+	i, ok := c.simulations.Load(id)
+	if !ok {
 		// No Simulation found send error
 		resp.Success = false
 		resp.Error = "No Simulation found with the id - " + id
@@ -466,17 +598,33 @@ func (c *Controller) updateLight(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Parse the agent data
-	var lightInfo info
-	_ = json.NewDecoder(r.Body).Decode(&lightInfo)
+	// Get the simulation and run for specifed number of steps
+	sim := i.(*simulation.Simulation)
+
+	if cmdInfo.Seconds > 0 {
+		cmdInfo.Steps = sim.StepsFor(cmdInfo.Seconds)
+	}
 
-	// Get the simulation from the map
-	i, _ := c.simulations.Load(id)
-	sim := i.(simulation.Simulation)
+	var err error
+	if cmdInfo.Background || cmdInfo.Steps < 0 {
+		err = sim.Start(cmdInfo.Steps, cmdInfo.TicksPerSecond)
+	} else {
+		err = sim.RunSteps(cmdInfo.Steps)
+	}
+	if err != nil {
+		// The simulation could not be ran send error
+		resp.Success = false
+		resp.Error = "Unable to run simulation - " + err.Error()
 
-	sim.UpdateLight(lightInfo.ID, lightInfo.Stop)
+		// Encode response into json
+		jsonStr, _ := json.Marshal(resp)
 
-	c.simulations.Store(id, sim)
+		// Send response
+		fmt.Fprint(w, string(jsonStr))
+
+		c.Logger.Warnf("Unable to run simulation: %v", err)
+		return
+	}
 
 	resp.Success = true
 
@@ -485,8 +633,1537 @@ func (c *Controller) updateLight(w http.ResponseWriter, r *http.Request) {
 
 	// Send response
 	fmt.Fprint(w, string(jsonStr))
-	c.Logger.Debug("Light Updated")
-	return
+
+	c.Logger.Debugf("Sim: %v Run for %v steps", id, cmdInfo.Steps)
+}
+
+// pauseSimulation pauses a specified simulation running in the background.
+func (c *Controller) pauseSimulation(w http.ResponseWriter, r *http.Request) {
+	c.controlSimulation(w, r, (*simulation.Simulation).Pause)
+}
+
+// resumeSimulation resumes a specified simulation that has been paused.
+func (c *Controller) resumeSimulation(w http.ResponseWriter, r *http.Request) {
+	c.controlSimulation(w, r, (*simulation.Simulation).Resume)
+}
+
+// controlSimulation calls a command on the simulation specified in the
+// url and sends the result to the client.
+func (c *Controller) controlSimulation(w http.ResponseWriter, r *http.Request, command func(*simulation.Simulation) error) {
+	var resp response
+
+	// Get the id from the url
+	params := mux.Vars(r)
+	id := params["id"]
+
+	// Check if the id exists
+	i, ok := c.simulations.Load(id)
+	if !ok {
+		// No Simulation found send error
+		resp.Success = false
+		resp.Error = "No Simulation found with the id - " + id
+
+		// Encode response into json
+		jsonStr, _ := json.Marshal(resp)
+
+		// Send response
+		fmt.Fprint(w, string(jsonStr))
+
+		c.Logger.Warnf("No Simulation found with id: %v", id)
+		return
+	}
+
+	err := command(i.(*simulation.Simulation))
+	if err != nil {
+		resp.Success = false
+		resp.Error = err.Error()
+	} else {
+		resp.Success = true
+	}
+
+	// Encode response into json
+	jsonStr, _ := json.Marshal(resp)
+
+	// Send response
+	fmt.Fprint(w, string(jsonStr))
+
+	c.Logger.Debugf("Sim: %v %v - %v", id, r.URL.Path, resp.Error)
+}
+
+// getStatus gets the run status and current tick of a specified simulation.
+func (c *Controller) getStatus(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		// Success is true if the status was found.
+		Success bool `json:"success"`
+		// Error is a string that is set if something goes wrong.
+		Error string `json:"error"`
+		// Status is the run status of the simulation.
+		Status string `json:"status"`
+		// Tick is the tick the simulation is currently at.
+		Tick int `json:"tick"`
+		// Time is the number of seconds simulated so far.
+		Time float64 `json:"time"`
+	}
+
+	var resp response
+
+	// Get the id from the url
+	params := mux.Vars(r)
+	id := params["id"]
+
+	// Check if the id exists
+	i, ok := c.simulations.Load(id)
+	if !ok {
+		// No Simulation found send error
+		resp.Success = false
+		resp.Error = "No Simulation found with the id - " + id
+
+		// Encode response into json
+		jsonStr, _ := json.Marshal(resp)
+
+		// Send response
+		fmt.Fprint(w, string(jsonStr))
+
+		c.Logger.Warnf("No Simulation found with id: %v", id)
+		return
+	}
+
+	sim := i.(*simulation.Simulation)
+	resp.Status, resp.Tick = sim.GetStatus()
+	resp.Time = float64(resp.Tick) * sim.GetTimeStep()
+	resp.Success = true
+
+	// Encode response into json
+	jsonStr, _ := json.Marshal(resp)
+
+	// Send response
+	fmt.Fprint(w, string(jsonStr))
+}
+
+// stopSimulation stops a specified simulation.
+func (c *Controller) stopSimulation(w http.ResponseWriter, r *http.Request) {
+	var resp response
+
+	// Get the id from the url
+	params := mux.Vars(r)
+	id := params["id"]
+
+	// Check if the id exists
+	i, ok := c.simulations.Load(id)
+	if !ok {
+		// No Simulation found send error
+		resp.Success = false
+		resp.Error = "No Simulation found with the id - " + id
+
+		// Encode response into json
+		jsonStr, _ := json.Marshal(resp)
+
+		// Send response
+		fmt.Fprint(w, string(jsonStr))
+
+		c.Logger.Warnf("No Simulation found with id: %v", id)
+		return
+	}
+
+	// Stop the simulation
+	sim := i.(*simulation.Simulation)
+	sim.Stop()
+
+	resp.Success = true
+
+	// Encode response into json
+	jsonStr, _ := json.Marshal(resp)
+
+	// Send response
+	fmt.Fprint(w, string(jsonStr))
+
+	c.Logger.Debugf("Sim stopped: %v", id)
+}
+
+// addAgent adds agents to a specified simulation. Any agent type
+// registered with the simulation package can be added, the parameters
+// of the type being read from the same json object as the agent.
+func (c *Controller) addAgent(w http.ResponseWriter, r *http.Request) {
+	type info struct {
+		Agents []json.RawMessage `json:"agents"`
+	}
+
+	var resp response
+
+	// Get the id and type from the url
+	params := mux.Vars(r)
+	id := params["id"]
+
+	// Check if the id exists
+	i, ok := c.simulations.Load(id)
+	if !ok {
+		// No Simulation found send error
+		resp.Success = false
+		resp.Error = "No Simulation found with the id - " + id
+
+		// Encode response into json
+		jsonStr, _ := json.Marshal(resp)
+
+		// Send response
+		fmt.Fprint(w, string(jsonStr))
+
+		c.Logger.Warnf("No Simulation found with id: %v", id)
+		return
+	}
+
+	// Parse the agent data
+	var agentsInfo info
+	_ = json.NewDecoder(r.Body).Decode(&agentsInfo)
+
+	sim := i.(*simulation.Simulation)
+
+	// Create and add new agent for each of the agents information given
+	for _, raw := range agentsInfo.Agents {
+		if err := sim.AddScenarioAgent(raw); err != nil {
+			resp.Error += "Unable to add agent - " + err.Error() + "\n"
+
+			c.Logger.Warnf("Unable to add agent: %v", err)
+		}
+	}
+
+	resp.Success = true
+
+	// Encode response into json
+	jsonStr, _ := json.Marshal(resp)
+
+	// Send response
+	fmt.Fprint(w, string(jsonStr))
+
+	c.Logger.Infof("Agents been added to sim: %v", id)
+}
+
+// getAgentTypes returns the types of agent that can be added to a
+// simulation and the parameters each is created with.
+func (c *Controller) getAgentTypes(w http.ResponseWriter, r *http.Request) {
+	type info struct {
+		response
+		// Types stores the registered agent types.
+		Types []simulation.AgentType `json:"types"`
+	}
+
+	var resp info
+	resp.Success = true
+	resp.Types = simulation.GetAgentTypes()
+
+	// Encode response into json
+	jsonStr, _ := json.Marshal(resp)
+
+	// Send response
+	fmt.Fprint(w, string(jsonStr))
+
+	c.Logger.Debug("Agent types returned")
+}
+
+// setParameters changes the parameters that control how the agents in a
+// specified simulation behave. Only the parameters given are changed, and
+// the change applies from the next tick.
+func (c *Controller) setParameters(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		// Success is true if the parameters were changed.
+		Success bool `json:"success"`
+		// Error is a string that is set if something goes wrong.
+		Error string `json:"error"`
+		// Parameters are the parameters the simulation now uses.
+		Parameters simulation.Parameters `json:"parameters"`
+	}
+
+	var resp response
+
+	// Get the id from the url
+	params := mux.Vars(r)
+	id := params["id"]
+
+	// Check if the id exists
+	i, ok := c.simulations.Load(id)
+	if !ok {
+		// No Simulation found send error
+		resp.Success = false
+		resp.Error = "No Simulation found with the id - " + id
+
+		// Encode response into json
+		jsonStr, _ := json.Marshal(resp)
+
+		// Send response
+		fmt.Fprint(w, string(jsonStr))
+
+		c.Logger.Warnf("No Simulation found with id: %v", id)
+		return
+	}
+
+	sim := i.(*simulation.Simulation)
+
+	// The parameters given replace the ones in use, the rest are kept
+	raw, err := ioutil.ReadAll(r.Body)
+	if err == nil {
+		resp.Parameters, err = sim.UpdateParameters(raw)
+	} else {
+		resp.Parameters = sim.GetParameters()
+	}
+	if err != nil {
+		// The parameters could not be set send error
+		resp.Success = false
+		resp.Error = "Unable to set parameters - " + err.Error()
+
+		// Encode response into json
+		jsonStr, _ := json.Marshal(resp)
+
+		// Send response
+		fmt.Fprint(w, string(jsonStr))
+
+		c.Logger.Warnf("Unable to set parameters: %v", err)
+		return
+	}
+
+	resp.Success = true
+
+	// Encode response into json
+	jsonStr, _ := json.Marshal(resp)
+
+	// Send response
+	fmt.Fprint(w, string(jsonStr))
+
+	c.Logger.Infof("Parameters set for sim: %v", id)
+}
+
+// addLight adds a new traffic light to a given simulation.
+func (c *Controller) addLight(w http.ResponseWriter, r *http.Request) {
+	type info struct {
+		Position []float64 `json:"position"`
+		Stop     bool      `json:"stop"`
+	}
+
+	// Get the id and type from the url
+	params := mux.Vars(r)
+	id := params["id"]
+
+	var resp response
+
+	// Check if the id exists
+	i, ok := c.simulations.Load(id)
+	if !ok {
+		// No Simulation found send error
+		resp.Success = false
+		resp.Error = "No Simulation found with the id - " + id
+
+		// Encode response into json
+		jsonStr, _ := json.Marshal(resp)
+
+		// Send response
+		fmt.Fprint(w, string(jsonStr))
+
+		c.Logger.Warnf("No Simulation found with id: %v", id)
+		return
+	}
+
+	// Parse the agent data
+	var lightInfo info
+	_ = json.NewDecoder(r.Body).Decode(&lightInfo)
+
+	sim := i.(*simulation.Simulation)
+
+	sim.AddLight(simulation.NewVector(lightInfo.Position[0], lightInfo.Position[1]), lightInfo.Stop)
+
+	resp.Success = true
+
+	// Encode response into json
+	jsonStr, _ := json.Marshal(resp)
+
+	// Send response
+	fmt.Fprint(w, string(jsonStr))
+	c.Logger.Debug("Light added")
+	return
+}
+
+// updateLight updates a traffic light in a given simulation.
+func (c *Controller) updateLight(w http.ResponseWriter, r *http.Request) {
+	type info struct {
+		ID   int  `json:"id"`
+		Stop bool `json:"stop"`
+	}
+
+	// Get the id and type from the url
+	params := mux.Vars(r)
+	id := params["id"]
+
+	var resp response
+
+	// Check if the id exists
+	i, ok := c.simulations.Load(id)
+	if !ok {
+		// No Simulation found send error
+		resp.Success = false
+		resp.Error = "No Simulation found with the id - " + id
+
+		// Encode response into json
+		jsonStr, _ := json.Marshal(resp)
+
+		// Send response
+		fmt.Fprint(w, string(jsonStr))
+
+		c.Logger.Warnf("No Simulation found with id: %v", id)
+		return
+	}
+
+	// Parse the agent data
+	var lightInfo info
+	_ = json.NewDecoder(r.Body).Decode(&lightInfo)
+
+	sim := i.(*simulation.Simulation)
+
+	sim.UpdateLight(lightInfo.ID, lightInfo.Stop)
+
+	resp.Success = true
+
+	// Encode response into json
+	jsonStr, _ := json.Marshal(resp)
+
+	// Send response
+	fmt.Fprint(w, string(jsonStr))
+	c.Logger.Debug("Light Updated")
+	return
+}
+
+// addSignal adds a signal controller that runs fixed time plans to
+// a given simulation.
+func (c *Controller) addSignal(w http.ResponseWriter, r *http.Request) {
+	type phaseInfo struct {
+		Lights []int `json:"lights"`
+		Green  int   `json:"green"`
+		Amber  int   `json:"amber"`
+		AllRed int   `json:"allRed"`
+	}
+
+	type planInfo struct {
+		Name        string      `json:"name"`
+		CycleLength int         `json:"cycleLength"`
+		Offset      int         `json:"offset"`
+		Phases      []phaseInfo `json:"phases"`
+	}
+
+	type scheduleInfo struct {
+		Start int    `json:"start"`
+		Plan  string `json:"plan"`
+	}
+
+	type info struct {
+		Plans    []planInfo     `json:"plans"`
+		Schedule []scheduleInfo `json:"schedule"`
+	}
+
+	// Get the id and type from the url
+	params := mux.Vars(r)
+	id := params["id"]
+
+	var resp response
+
+	// Check if the id exists
+	i, ok := c.simulations.Load(id)
+	if !ok {
+		// No Simulation found send error
+		resp.Success = false
+		resp.Error = "No Simulation found with the id - " + id
+
+		// Encode response into json
+		jsonStr, _ := json.Marshal(resp)
+
+		// Send response
+		fmt.Fprint(w, string(jsonStr))
+
+		c.Logger.Warnf("No Simulation found with id: %v", id)
+		return
+	}
+
+	// Parse the signal data
+	var signalInfo info
+	_ = json.NewDecoder(r.Body).Decode(&signalInfo)
+
+	// Convert the plans into simulation.SignalPlans
+	var plans []simulation.SignalPlan
+	var err error
+	for _, p := range signalInfo.Plans {
+		var phases []simulation.Phase
+		for _, phase := range p.Phases {
+			phases = append(phases, simulation.NewPhase(phase.Lights, phase.Green, phase.Amber, phase.AllRed))
+		}
+
+		var plan simulation.SignalPlan
+		plan, err = simulation.NewSignalPlan(p.Name, phases, p.CycleLength, p.Offset)
+		if err != nil {
+			break
+		}
+		plans = append(plans, plan)
+	}
+
+	var schedule []simulation.ScheduleEntry
+	for _, entry := range signalInfo.Schedule {
+		schedule = append(schedule, simulation.ScheduleEntry{Start: entry.Start, Plan: entry.Plan})
+	}
+
+	sim := i.(*simulation.Simulation)
+
+	if err == nil {
+		err = sim.AddSignalController(plans, schedule)
+	}
+	if err != nil {
+		// The signal controller could not be created send error
+		resp.Success = false
+		resp.Error = "Unable to add signal - " + err.Error()
+
+		// Encode response into json
+		jsonStr, _ := json.Marshal(resp)
+
+		// Send response
+		fmt.Fprint(w, string(jsonStr))
+
+		c.Logger.Warnf("Unable to add signal: %v", err)
+		return
+	}
+
+	resp.Success = true
+
+	// Encode response into json
+	jsonStr, _ := json.Marshal(resp)
+
+	// Send response
+	fmt.Fprint(w, string(jsonStr))
+	c.Logger.Debug("Signal added")
+	return
+}
+
+// updateSignalMode changes how a signal controller in a given simulation
+// decides the length of its phases.
+func (c *Controller) updateSignalMode(w http.ResponseWriter, r *http.Request) {
+	type info struct {
+		ID                int     `json:"id"`
+		Mode              string  `json:"mode"`
+		MinGreen          int     `json:"minGreen"`
+		MaxGreen          int     `json:"maxGreen"`
+		Gap               float64 `json:"gap"`
+		DetectionDistance float64 `json:"detectionDistance"`
+	}
+
+	// Get the id and type from the url
+	params := mux.Vars(r)
+	id := params["id"]
+
+	var resp response
+
+	// Check if the id exists
+	i, ok := c.simulations.Load(id)
+	if !ok {
+		// No Simulation found send error
+		resp.Success = false
+		resp.Error = "No Simulation found with the id - " + id
+
+		// Encode response into json
+		jsonStr, _ := json.Marshal(resp)
+
+		// Send response
+		fmt.Fprint(w, string(jsonStr))
+
+		c.Logger.Warnf("No Simulation found with id: %v", id)
+		return
+	}
+
+	// Parse the mode data
+	var modeInfo info
+	_ = json.NewDecoder(r.Body).Decode(&modeInfo)
+
+	sim := i.(*simulation.Simulation)
+
+	err := sim.SetSignalMode(
+		modeInfo.ID,
+		modeInfo.Mode,
+		modeInfo.MinGreen,
+		modeInfo.MaxGreen,
+		modeInfo.Gap,
+		modeInfo.DetectionDistance)
+	if err != nil {
+		// The mode could not be changed send error
+		resp.Success = false
+		resp.Error = "Unable to change signal mode - " + err.Error()
+
+		// Encode response into json
+		jsonStr, _ := json.Marshal(resp)
+
+		// Send response
+		fmt.Fprint(w, string(jsonStr))
+
+		c.Logger.Warnf("Unable to change signal mode: %v", err)
+		return
+	}
+
+	resp.Success = true
+
+	// Encode response into json
+	jsonStr, _ := json.Marshal(resp)
+
+	// Send response
+	fmt.Fprint(w, string(jsonStr))
+	c.Logger.Debug("Signal mode updated")
+	return
+}
+
+// addDetector adds a point or area detector to a given simulation.
+func (c *Controller) addDetector(w http.ResponseWriter, r *http.Request) {
+	type info struct {
+		Type     string    `json:"type"`
+		Position []float64 `json:"position"`
+		Length   float64   `json:"length"`
+		Corner   []float64 `json:"corner"`
+		Opposite []float64 `json:"opposite"`
+		Interval int       `json:"interval"`
+	}
+
+	// Get the id and type from the url
+	params := mux.Vars(r)
+	id := params["id"]
+
+	var resp response
+
+	// Check if the id exists
+	i, ok := c.simulations.Load(id)
+	if !ok {
+		// No Simulation found send error
+		resp.Success = false
+		resp.Error = "No Simulation found with the id - " + id
+
+		// Encode response into json
+		jsonStr, _ := json.Marshal(resp)
+
+		// Send response
+		fmt.Fprint(w, string(jsonStr))
+
+		c.Logger.Warnf("No Simulation found with id: %v", id)
+		return
+	}
+
+	// Parse the detector data
+	var detectorInfo info
+	_ = json.NewDecoder(r.Body).Decode(&detectorInfo)
+
+	sim := i.(*simulation.Simulation)
+
+	var err error
+	switch detectorInfo.Type {
+	case simulation.PointDetector:
+		if len(detectorInfo.Position) < 2 {
+			err = errors.New("a point detector needs a position")
+			break
+		}
+		pos := simulation.NewVector(detectorInfo.Position[0], detectorInfo.Position[1])
+		err = sim.AddPointDetector(pos, detectorInfo.Length, detectorInfo.Interval)
+	case simulation.AreaDetector:
+		if len(detectorInfo.Corner) < 2 || len(detectorInfo.Opposite) < 2 {
+			err = errors.New("an area detector needs two corners")
+			break
+		}
+		corner := simulation.NewVector(detectorInfo.Corner[0], detectorInfo.Corner[1])
+		opposite := simulation.NewVector(detectorInfo.Opposite[0], detectorInfo.Opposite[1])
+		err = sim.AddAreaDetector(corner, opposite, detectorInfo.Interval)
+	default:
+		err = errors.New("no detector of that type found - " + detectorInfo.Type)
+	}
+	if err != nil {
+		// The detector could not be added send error
+		resp.Success = false
+		resp.Error = "Unable to add detector - " + err.Error()
+
+		// Encode response into json
+		jsonStr, _ := json.Marshal(resp)
+
+		// Send response
+		fmt.Fprint(w, string(jsonStr))
+
+		c.Logger.Warnf("Unable to add detector: %v", err)
+		return
+	}
+
+	resp.Success = true
+
+	// Encode response into json
+	jsonStr, _ := json.Marshal(resp)
+
+	// Send response
+	fmt.Fprint(w, string(jsonStr))
+	c.Logger.Debug("Detector added")
+	return
+}
+
+// addCrosswalk adds a crosswalk to a given simulation. Vehicles give way
+// to pedestrians on the crosswalk, and if a light is given pedestrians
+// wait for it to show green before crossing.
+func (c *Controller) addCrosswalk(w http.ResponseWriter, r *http.Request) {
+	type info struct {
+		Start []float64 `json:"start"`
+		End   []float64 `json:"end"`
+		Width float64   `json:"width"`
+		Light *int      `json:"light"`
+	}
+
+	// Get the id and type from the url
+	params := mux.Vars(r)
+	id := params["id"]
+
+	var resp response
+
+	// Check if the id exists
+	i, ok := c.simulations.Load(id)
+	if !ok {
+		// No Simulation found send error
+		resp.Success = false
+		resp.Error = "No Simulation found with the id - " + id
+
+		// Encode response into json
+		jsonStr, _ := json.Marshal(resp)
+
+		// Send response
+		fmt.Fprint(w, string(jsonStr))
+
+		c.Logger.Warnf("No Simulation found with id: %v", id)
+		return
+	}
+
+	// Parse the crosswalk data
+	var crosswalkInfo info
+	_ = json.NewDecoder(r.Body).Decode(&crosswalkInfo)
+
+	sim := i.(*simulation.Simulation)
+
+	var err error
+	if len(crosswalkInfo.Start) < 2 || len(crosswalkInfo.End) < 2 {
+		err = errors.New("a crosswalk needs a start and an end")
+	} else {
+		// Crosswalks without a light are not signalised
+		light := -1
+		if crosswalkInfo.Light != nil {
+			light = *crosswalkInfo.Light
+		}
+
+		start := simulation.NewVector(crosswalkInfo.Start[0], crosswalkInfo.Start[1])
+		end := simulation.NewVector(crosswalkInfo.End[0], crosswalkInfo.End[1])
+		err = sim.AddCrosswalk(start, end, crosswalkInfo.Width, light)
+	}
+	if err != nil {
+		// The crosswalk could not be added send error
+		resp.Success = false
+		resp.Error = "Unable to add crosswalk - " + err.Error()
+
+		// Encode response into json
+		jsonStr, _ := json.Marshal(resp)
+
+		// Send response
+		fmt.Fprint(w, string(jsonStr))
+
+		c.Logger.Warnf("Unable to add crosswalk: %v", err)
+		return
+	}
+
+	resp.Success = true
+
+	// Encode response into json
+	jsonStr, _ := json.Marshal(resp)
+
+	// Send response
+	fmt.Fprint(w, string(jsonStr))
+	c.Logger.Debug("Crosswalk added")
+	return
+}
+
+// addJunction adds priority rules to a node in a given simulation.
+// Vehicles approaching the node give way using the control given.
+func (c *Controller) addJunction(w http.ResponseWriter, r *http.Request) {
+	type info struct {
+		Position    []float64   `json:"position"`
+		Control     string      `json:"control"`
+		Major       [][]float64 `json:"major"`
+		CriticalGap float64     `json:"criticalGap"`
+	}
+
+	// Get the id from the url
+	params := mux.Vars(r)
+	id := params["id"]
+
+	var resp response
+
+	// Check if the id exists
+	i, ok := c.simulations.Load(id)
+	if !ok {
+		// No Simulation found send error
+		resp.Success = false
+		resp.Error = "No Simulation found with the id - " + id
+
+		// Encode response into json
+		jsonStr, _ := json.Marshal(resp)
+
+		// Send response
+		fmt.Fprint(w, string(jsonStr))
+
+		c.Logger.Warnf("No Simulation found with id: %v", id)
+		return
+	}
+
+	// Parse the junction data
+	var junctionInfo info
+	_ = json.NewDecoder(r.Body).Decode(&junctionInfo)
+
+	sim := i.(*simulation.Simulation)
+
+	var err error
+	var major []simulation.Vector
+	for _, m := range junctionInfo.Major {
+		if len(m) < 2 {
+			err = errors.New("major roads need the position of the node they come from")
+			break
+		}
+		major = append(major, simulation.NewVector(m[0], m[1]))
+	}
+	if len(junctionInfo.Position) < 2 {
+		err = errors.New("a junction needs a position")
+	}
+	if err == nil {
+		pos := simulation.NewVector(junctionInfo.Position[0], junctionInfo.Position[1])
+		err = sim.AddJunction(pos, junctionInfo.Control, major, junctionInfo.CriticalGap)
+	}
+	if err != nil {
+		// The junction could not be added send error
+		resp.Success = false
+		resp.Error = "Unable to add junction - " + err.Error()
+
+		// Encode response into json
+		jsonStr, _ := json.Marshal(resp)
+
+		// Send response
+		fmt.Fprint(w, string(jsonStr))
+
+		c.Logger.Warnf("Unable to add junction: %v", err)
+		return
+	}
+
+	resp.Success = true
+
+	// Encode response into json
+	jsonStr, _ := json.Marshal(resp)
+
+	// Send response
+	fmt.Fprint(w, string(jsonStr))
+	c.Logger.Debug("Junction added")
+	return
+}
+
+// setDefaultJunction sets the control used at the nodes of a given
+// simulation where roads meet that have no signals or junction of their
+// own.
+func (c *Controller) setDefaultJunction(w http.ResponseWriter, r *http.Request) {
+	type info struct {
+		Control string `json:"control"`
+	}
+
+	// Get the id from the url
+	params := mux.Vars(r)
+	id := params["id"]
+
+	var resp response
+
+	// Check if the id exists
+	i, ok := c.simulations.Load(id)
+	if !ok {
+		// No Simulation found send error
+		resp.Success = false
+		resp.Error = "No Simulation found with the id - " + id
+
+		// Encode response into json
+		jsonStr, _ := json.Marshal(resp)
+
+		// Send response
+		fmt.Fprint(w, string(jsonStr))
+
+		c.Logger.Warnf("No Simulation found with id: %v", id)
+		return
+	}
+
+	// Parse the control
+	var controlInfo info
+	_ = json.NewDecoder(r.Body).Decode(&controlInfo)
+
+	sim := i.(*simulation.Simulation)
+	err := sim.SetDefaultJunction(controlInfo.Control)
+	if err != nil {
+		// The default junction could not be set send error
+		resp.Success = false
+		resp.Error = "Unable to set default junction - " + err.Error()
+
+		// Encode response into json
+		jsonStr, _ := json.Marshal(resp)
+
+		// Send response
+		fmt.Fprint(w, string(jsonStr))
+
+		c.Logger.Warnf("Unable to set default junction: %v", err)
+		return
+	}
+
+	resp.Success = true
+
+	// Encode response into json
+	jsonStr, _ := json.Marshal(resp)
+
+	// Send response
+	fmt.Fprint(w, string(jsonStr))
+	c.Logger.Debug("Default junction set")
+	return
+}
+
+// addBusStop adds a bus stop to a given simulation.
+func (c *Controller) addBusStop(w http.ResponseWriter, r *http.Request) {
+	type info struct {
+		Position    []float64 `json:"position"`
+		ArrivalRate float64   `json:"arrivalRate"`
+	}
+
+	// Get the id and type from the url
+	params := mux.Vars(r)
+	id := params["id"]
+
+	var resp response
+
+	// Check if the id exists
+	i, ok := c.simulations.Load(id)
+	if !ok {
+		// No Simulation found send error
+		resp.Success = false
+		resp.Error = "No Simulation found with the id - " + id
+
+		// Encode response into json
+		jsonStr, _ := json.Marshal(resp)
+
+		// Send response
+		fmt.Fprint(w, string(jsonStr))
+
+		c.Logger.Warnf("No Simulation found with id: %v", id)
+		return
+	}
+
+	// Parse the bus stop data
+	var stopInfo info
+	_ = json.NewDecoder(r.Body).Decode(&stopInfo)
+
+	sim := i.(*simulation.Simulation)
+
+	var err error
+	if len(stopInfo.Position) < 2 {
+		err = errors.New("a bus stop needs a position")
+	} else {
+		pos := simulation.NewVector(stopInfo.Position[0], stopInfo.Position[1])
+		err = sim.AddBusStop(pos, stopInfo.ArrivalRate)
+	}
+	if err != nil {
+		// The bus stop could not be added send error
+		resp.Success = false
+		resp.Error = "Unable to add bus stop - " + err.Error()
+
+		// Encode response into json
+		jsonStr, _ := json.Marshal(resp)
+
+		// Send response
+		fmt.Fprint(w, string(jsonStr))
+
+		c.Logger.Warnf("Unable to add bus stop: %v", err)
+		return
+	}
+
+	resp.Success = true
+
+	// Encode response into json
+	jsonStr, _ := json.Marshal(resp)
+
+	// Send response
+	fmt.Fprint(w, string(jsonStr))
+	c.Logger.Debug("Bus stop added")
+	return
+}
+
+// addBusLine adds a bus line to a given simulation. Buses are sent along
+// the line's route following its schedule.
+func (c *Controller) addBusLine(w http.ResponseWriter, r *http.Request) {
+	// Get the id and type from the url
+	params := mux.Vars(r)
+	id := params["id"]
+
+	var resp response
+
+	// Check if the id exists
+	i, ok := c.simulations.Load(id)
+	if !ok {
+		// No Simulation found send error
+		resp.Success = false
+		resp.Error = "No Simulation found with the id - " + id
+
+		// Encode response into json
+		jsonStr, _ := json.Marshal(resp)
+
+		// Send response
+		fmt.Fprint(w, string(jsonStr))
+
+		c.Logger.Warnf("No Simulation found with id: %v", id)
+		return
+	}
+
+	// Parse the bus line data
+	var lineInfo simulation.ScenarioBusLine
+	_ = json.NewDecoder(r.Body).Decode(&lineInfo)
+
+	sim := i.(*simulation.Simulation)
+
+	err := sim.AddScenarioBusLine(lineInfo)
+	if err != nil {
+		// The bus line could not be added send error
+		resp.Success = false
+		resp.Error = "Unable to add bus line - " + err.Error()
+
+		// Encode response into json
+		jsonStr, _ := json.Marshal(resp)
+
+		// Send response
+		fmt.Fprint(w, string(jsonStr))
+
+		c.Logger.Warnf("Unable to add bus line: %v", err)
+		return
+	}
+
+	resp.Success = true
+
+	// Encode response into json
+	jsonStr, _ := json.Marshal(resp)
+
+	// Send response
+	fmt.Fprint(w, string(jsonStr))
+	c.Logger.Debug("Bus line added")
+	return
+}
+
+// getBusReport returns the bunching and schedule adherence report of a
+// bus line in a specified simulation.
+func (c *Controller) getBusReport(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		// Success is true if the report was found.
+		Success bool `json:"success"`
+		// Error is a string that is set if something goes wrong.
+		Error string `json:"error"`
+		// Report is the report of the bus line.
+		Report simulation.BusLineReport `json:"report"`
+	}
+
+	var resp response
+
+	// Get the id from the url
+	params := mux.Vars(r)
+	id := params["id"]
+
+	lineID, err := strconv.Atoi(params["lineId"])
+	if err != nil {
+		// Incorrect line Id
+		resp.Success = false
+		resp.Error = "Line Id Provided not a number - " + err.Error()
+
+		// Encode response into json
+		jsonStr, _ := json.Marshal(resp)
+
+		// Send response
+		fmt.Fprint(w, string(jsonStr))
+
+		c.Logger.Warnf("Wrong Line ID provided: %v", err.Error())
+		return
+	}
+
+	// Check if the id exists
+	i, ok := c.simulations.Load(id)
+	if !ok {
+		// No Simulation found send error
+		resp.Success = false
+		resp.Error = "No Simulation found with the id - " + id
+
+		// Encode response into json
+		jsonStr, _ := json.Marshal(resp)
+
+		// Send response
+		fmt.Fprint(w, string(jsonStr))
+
+		c.Logger.Warnf("No Simulation found with id: %v", id)
+		return
+	}
+
+	sim := i.(*simulation.Simulation)
+
+	resp.Report, err = sim.GetBusReport(lineID)
+	if err != nil {
+		// No line found send error
+		resp.Success = false
+		resp.Error = err.Error()
+
+		// Encode response into json
+		jsonStr, _ := json.Marshal(resp)
+
+		// Send response
+		fmt.Fprint(w, string(jsonStr))
+
+		c.Logger.Warnf("No Bus Line found with id: %v", lineID)
+		return
+	}
+	resp.Success = true
+
+	// Encode response into json
+	jsonStr, _ := json.Marshal(resp)
+
+	// Send response
+	fmt.Fprint(w, string(jsonStr))
+
+	c.Logger.Infof("Report returned for bus line: %v", lineID)
+}
+
+// addDemand adds an origin-destination matrix to a given simulation.
+// Vehicles are generated between the matrix's zones and routed through
+// the road network.
+func (c *Controller) addDemand(w http.ResponseWriter, r *http.Request) {
+	// Get the id from the url
+	params := mux.Vars(r)
+	id := params["id"]
+
+	var resp response
+
+	// Check if the id exists
+	i, ok := c.simulations.Load(id)
+	if !ok {
+		// No Simulation found send error
+		resp.Success = false
+		resp.Error = "No Simulation found with the id - " + id
+
+		// Encode response into json
+		jsonStr, _ := json.Marshal(resp)
+
+		// Send response
+		fmt.Fprint(w, string(jsonStr))
+
+		c.Logger.Warnf("No Simulation found with id: %v", id)
+		return
+	}
+
+	// Parse the demand data
+	var demandInfo simulation.ScenarioDemand
+	_ = json.NewDecoder(r.Body).Decode(&demandInfo)
+
+	sim := i.(*simulation.Simulation)
+
+	err := sim.AddScenarioDemand(demandInfo)
+	if err != nil {
+		// The demand could not be added send error
+		resp.Success = false
+		resp.Error = "Unable to add demand - " + err.Error()
+
+		// Encode response into json
+		jsonStr, _ := json.Marshal(resp)
+
+		// Send response
+		fmt.Fprint(w, string(jsonStr))
+
+		c.Logger.Warnf("Unable to add demand: %v", err)
+		return
+	}
+
+	resp.Success = true
+
+	// Encode response into json
+	jsonStr, _ := json.Marshal(resp)
+
+	// Send response
+	fmt.Fprint(w, string(jsonStr))
+	c.Logger.Debug("Demand added")
+	return
+}
+
+// getDemandReport returns the report comparing the vehicles generated by
+// a demand in a specified simulation with its origin-destination matrix.
+func (c *Controller) getDemandReport(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		// Success is true if the report was found.
+		Success bool `json:"success"`
+		// Error is a string that is set if something goes wrong.
+		Error string `json:"error"`
+		// Report is the report of the demand.
+		Report simulation.DemandReport `json:"report"`
+	}
+
+	var resp response
+
+	// Get the id from the url
+	params := mux.Vars(r)
+	id := params["id"]
+
+	demandID, err := strconv.Atoi(params["demandId"])
+	if err != nil {
+		// Incorrect demand Id
+		resp.Success = false
+		resp.Error = "Demand Id Provided not a number - " + err.Error()
+
+		// Encode response into json
+		jsonStr, _ := json.Marshal(resp)
+
+		// Send response
+		fmt.Fprint(w, string(jsonStr))
+
+		c.Logger.Warnf("Wrong Demand ID provided: %v", err.Error())
+		return
+	}
+
+	// Check if the id exists
+	i, ok := c.simulations.Load(id)
+	if !ok {
+		// No Simulation found send error
+		resp.Success = false
+		resp.Error = "No Simulation found with the id - " + id
+
+		// Encode response into json
+		jsonStr, _ := json.Marshal(resp)
+
+		// Send response
+		fmt.Fprint(w, string(jsonStr))
+
+		c.Logger.Warnf("No Simulation found with id: %v", id)
+		return
+	}
+
+	sim := i.(*simulation.Simulation)
+
+	resp.Report, err = sim.GetDemandReport(demandID)
+	if err != nil {
+		// No demand found send error
+		resp.Success = false
+		resp.Error = err.Error()
+
+		// Encode response into json
+		jsonStr, _ := json.Marshal(resp)
+
+		// Send response
+		fmt.Fprint(w, string(jsonStr))
+
+		c.Logger.Warnf("No Demand found with id: %v", demandID)
+		return
+	}
+	resp.Success = true
+
+	// Encode response into json
+	jsonStr, _ := json.Marshal(resp)
+
+	// Send response
+	fmt.Fprint(w, string(jsonStr))
+
+	c.Logger.Infof("Report returned for demand: %v", demandID)
+}
+
+// getDetectorInfo gets the data recorded by a detector in a
+// specified simulation.
+func (c *Controller) getDetectorInfo(w http.ResponseWriter, r *http.Request) {
+	detector, ok := c.loadDetector(w, r)
+	if !ok {
+		return
+	}
+
+	// Send the information to the client
+	fmt.Fprint(w, detector.GetInfo())
+
+	c.Logger.Infof("Info returned for detector: %v", detector.GetID())
+}
+
+// exportDetector sends the data recorded by a detector in a specified
+// simulation as a CSV file. The "data" query value can be set to "ticks"
+// to get a row for every tick instead of the aggregated intervals.
+func (c *Controller) exportDetector(w http.ResponseWriter, r *http.Request) {
+	detector, ok := c.loadDetector(w, r)
+	if !ok {
+		return
+	}
+
+	intervals := r.URL.Query().Get("data") != "ticks"
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=detector-%v.csv", detector.GetID()))
+	err := detector.ExportCSV(w, intervals)
+	if err != nil {
+		c.Logger.Error(err.Error())
+		return
+	}
+
+	c.Logger.Infof("Data exported for detector: %v", detector.GetID())
+}
+
+// loadDetector gets the detector specified in the url. If the detector
+// can not be found an error is sent to the client and false is returned.
+func (c *Controller) loadDetector(w http.ResponseWriter, r *http.Request) (detector simulation.Detector, found bool) {
+	var resp response
+
+	// Get the id from the url
+	params := mux.Vars(r)
+	id := params["id"]
+
+	detectorID, err := strconv.Atoi(params["detectorId"])
+	if err != nil {
+		// Incorrect detector Id
+		resp.Success = false
+		resp.Error = "Detector Id Provided not a number - " + err.Error()
+
+		// Encode response into json
+		jsonStr, _ := json.Marshal(resp)
+
+		// Send response
+		fmt.Fprint(w, string(jsonStr))
+
+		c.Logger.Warnf("Wrong Detector ID provided: %v", err.Error())
+		return detector, false
+	}
+
+	// Check if the id exists
+	i, ok := c.simulations.Load(id)
+	if !ok {
+		// No Simulation found send error
+		resp.Success = false
+		resp.Error = "No Simulation found with the id - " + id
+
+		// Encode response into json
+		jsonStr, _ := json.Marshal(resp)
+
+		// Send response
+		fmt.Fprint(w, string(jsonStr))
+
+		c.Logger.Warnf("No Simulation found with id: %v", id)
+		return detector, false
+	}
+
+	// Load the simulation from the map
+	sim := i.(*simulation.Simulation)
+
+	detector, found = sim.GetDetector(detectorID)
+	if !found {
+		// No Detector found send error
+		resp.Success = false
+		resp.Error = "No Detector found with the id: " + params["detectorId"]
+
+		// Encode response into json
+		jsonStr, _ := json.Marshal(resp)
+
+		// Send response
+		fmt.Fprint(w, string(jsonStr))
+
+		c.Logger.Warnf("No Detector found with id: %v", detectorID)
+	}
+	return
+}
+
+// getMetrics returns the network wide metrics of a specified simulation.
+// The from, to and interval query parameters choose the simulated seconds
+// returned and how many seconds each interval aggregates, with no interval
+// a record is returned for every tick.
+func (c *Controller) getMetrics(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		// Success is true if the metrics were found.
+		Success bool `json:"success"`
+		// Error is a string that is set if something goes wrong.
+		Error string `json:"error"`
+		// Records are the measurements taken at each tick.
+		Records []simulation.MetricsRecord `json:"records"`
+		// Intervals are the records aggregated over the interval.
+		Intervals []simulation.MetricsInterval `json:"intervals"`
+	}
+
+	var resp response
+
+	// Get the id from the url
+	params := mux.Vars(r)
+	id := params["id"]
+
+	// Check if the id exists
+	i, ok := c.simulations.Load(id)
+	if !ok {
+		// No Simulation found send error
+		resp.Success = false
+		resp.Error = "No Simulation found with the id - " + id
+
+		// Encode response into json
+		jsonStr, _ := json.Marshal(resp)
+
+		// Send response
+		fmt.Fprint(w, string(jsonStr))
+
+		c.Logger.Warnf("No Simulation found with id: %v", id)
+		return
+	}
+
+	// Read the query parameters, any that are missing are left as 0
+	var values [3]float64
+	for j, name := range []string{"from", "to", "interval"} {
+		value := r.URL.Query().Get(name)
+		if value == "" {
+			continue
+		}
+
+		var err error
+		values[j], err = strconv.ParseFloat(value, 64)
+		if err != nil {
+			// Incorrect query parameter
+			resp.Success = false
+			resp.Error = "Metrics " + name + " provided not a number - " + err.Error()
+
+			// Encode response into json
+			jsonStr, _ := json.Marshal(resp)
+
+			// Send response
+			fmt.Fprint(w, string(jsonStr))
+
+			c.Logger.Warnf("Wrong metrics %v provided: %v", name, err.Error())
+			return
+		}
+	}
+
+	// Convert the seconds into the ticks they fall in
+	sim := i.(*simulation.Simulation)
+	from, to, interval := sim.StepsFor(values[0]), sim.StepsFor(values[1]), sim.StepsFor(values[2])
+
+	if interval > 0 {
+		resp.Intervals, _ = sim.GetMetricsIntervals(from, to, interval)
+	} else {
+		resp.Records = sim.GetMetrics(from, to)
+	}
+	resp.Success = true
+
+	// Encode response into json
+	jsonStr, _ := json.Marshal(resp)
+
+	// Send response
+	fmt.Fprint(w, string(jsonStr))
+
+	c.Logger.Infof("Metrics returned for simulation: %v", id)
+}
+
+// getCollisions sends the collisions between vehicles recorded in a
+// specified simulation.
+func (c *Controller) getCollisions(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		// Success is true if the collisions were found.
+		Success bool `json:"success"`
+		// Error is a string that is set if something goes wrong.
+		Error string `json:"error"`
+		// Collisions are the vehicles that have collided, in the
+		// order they collided.
+		Collisions []simulation.Collision `json:"collisions"`
+		// HaltOnCollision is true if the simulation stops running
+		// when vehicles collide.
+		HaltOnCollision bool `json:"haltOnCollision"`
+	}
+
+	var resp response
+
+	// Get the id from the url
+	params := mux.Vars(r)
+	id := params["id"]
+
+	// Check if the id exists
+	i, ok := c.simulations.Load(id)
+	if !ok {
+		// No Simulation found send error
+		resp.Success = false
+		resp.Error = "No Simulation found with the id - " + id
+
+		// Encode response into json
+		jsonStr, _ := json.Marshal(resp)
+
+		// Send response
+		fmt.Fprint(w, string(jsonStr))
+
+		c.Logger.Warnf("No Simulation found with id: %v", id)
+		return
+	}
+
+	sim := i.(*simulation.Simulation)
+	resp.Collisions = sim.GetCollisions()
+	resp.HaltOnCollision = sim.GetHaltOnCollision()
+	resp.Success = true
+
+	// Encode response into json
+	jsonStr, _ := json.Marshal(resp)
+
+	// Send response
+	fmt.Fprint(w, string(jsonStr))
+
+	c.Logger.Infof("Collisions returned for simulation: %v", id)
+}
+
+// exportTrajectories sends the recorded trajectories of a specified
+// simulation's agents. The format query parameter chooses between
+// "csv", "jsonl" and "fcd", csv is used if none is given.
+func (c *Controller) exportTrajectories(w http.ResponseWriter, r *http.Request) {
+	var resp response
+
+	// Get the id from the url
+	params := mux.Vars(r)
+	id := params["id"]
+
+	// Check if the id exists
+	i, ok := c.simulations.Load(id)
+	if !ok {
+		// No Simulation found send error
+		resp.Success = false
+		resp.Error = "No Simulation found with the id - " + id
+
+		// Encode response into json
+		jsonStr, _ := json.Marshal(resp)
+
+		// Send response
+		fmt.Fprint(w, string(jsonStr))
+
+		c.Logger.Warnf("No Simulation found with id: %v", id)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	var contentType, extension string
+	switch format {
+	case "", simulation.CSVFormat:
+		format = simulation.CSVFormat
+		contentType, extension = "text/csv", "csv"
+	case simulation.JSONLinesFormat:
+		contentType, extension = "application/x-ndjson", "jsonl"
+	case simulation.FCDFormat:
+		contentType, extension = "application/xml", "xml"
+	default:
+		// Unknown format send error
+		resp.Success = false
+		resp.Error = "Unknown trajectory format - " + format
+
+		// Encode response into json
+		jsonStr, _ := json.Marshal(resp)
+
+		// Send response
+		fmt.Fprint(w, string(jsonStr))
+
+		c.Logger.Warnf("Unknown trajectory format: %v", format)
+		return
+	}
+
+	sim := i.(*simulation.Simulation)
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=trajectories-%v.%v", id, extension))
+	err := sim.ExportTrajectories(w, format)
+	if err != nil {
+		c.Logger.Error(err.Error())
+		return
+	}
+
+	c.Logger.Infof("Trajectories exported for simulation: %v", id)
 }
 
 // getInfo gets information about a specified simualtion.
@@ -507,7 +2184,8 @@ func (c *Controller) getInfo(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	id := params["id"]
 	// Check if the id exists
-	if _, ok := c.simulations.Load(id); !ok {
+	i, ok := c.simulations.Load(id)
+	if !ok {
 		// No Simulation found send error
 		resp.Success = false
 		resp.Error = "No Simulation found with the id - " + id
@@ -522,8 +2200,7 @@ func (c *Controller) getInfo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	i, _ := c.simulations.Load(id)
-	sim := i.(simulation.Simulation)
+	sim := i.(*simulation.Simulation)
 	jsonStr := sim.GetInfo()
 
 	fmt.Fprint(w, string(jsonStr))
@@ -566,7 +2243,8 @@ func (c *Controller) getAgentInfo(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Check if the id exists
-	if _, ok := c.simulations.Load(id); !ok {
+	i, ok := c.simulations.Load(id)
+	if !ok {
 		// No Simulation found send error
 		resp.Success = false
 		resp.Error = "No Simulation found with the id - " + id
@@ -582,8 +2260,7 @@ func (c *Controller) getAgentInfo(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Load the simulation from the map
-	i, _ := c.simulations.Load(id)
-	sim := i.(simulation.Simulation)
+	sim := i.(*simulation.Simulation)
 
 	// Get the agent from the simulation
 	agent := sim.GetAgent(agentID)
@@ -640,7 +2317,8 @@ func (c *Controller) getImage(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	id := params["id"]
 	// Check if the id exists
-	if _, ok := c.simulations.Load(id); !ok {
+	i, ok := c.simulations.Load(id)
+	if !ok {
 		// No Simulation found send error
 		resp.Success = false
 		resp.Error = "No Simulation found with the id - " + id
@@ -656,14 +2334,13 @@ func (c *Controller) getImage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get the simulation
-	i, _ := c.simulations.Load(id)
-	sim := i.(simulation.Simulation)
+	sim := i.(*simulation.Simulation)
 
 	// parse the setup data
 	var cameraInfo info
 	_ = json.NewDecoder(r.Body).Decode(&cameraInfo)
 
-	positions, goals := sim.GetAgentPositions()
+	positions, goals, lanes := sim.GetAgentPositions()
 	lightPostitions, lightStates := sim.GetLights()
 
 	// Get the filepath for image
@@ -671,6 +2348,7 @@ func (c *Controller) getImage(w http.ResponseWriter, r *http.Request) {
 		positions,
 		sim.GetWaypoints(),
 		goals,
+		lanes,
 		lightPostitions,
 		lightStates,
 		sim.GetTick(),
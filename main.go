@@ -1,8 +1,11 @@
 package main
 
 import (
+	"flag"
 	"os"
+	"runtime"
 
+	"./batch"
 	"./controller"
 	log "github.com/sirupsen/logrus"
 )
@@ -24,9 +27,24 @@ func init() {
 }
 
 // main is ran when the application is executed. Main setsup a controller and
-// starts it listening.
+// starts it listening, unless a scenario is given to run as a batch.
 func main() {
+	scenario := flag.String("batch", "", "run the saved simulation or scenario in the given file as a batch experiment instead of starting the server")
+	duration := flag.Float64("duration", 0, "the number of simulated seconds each replication runs for, 0 uses the scenario's duration or 3600")
+	replications := flag.Int("replications", defaultReplications, "the number of times the scenario is run")
+	seed := flag.Int64("seed", defaultSeed, "the seed of the first replication, each replication after uses the next seed")
+	workers := flag.Int("workers", runtime.NumCPU(), "the number of replications run at the same time")
+	interval := flag.Float64("interval", defaultInterval, "the number of simulated seconds the metrics are aggregated over")
+	output := flag.String("output", defaultOutput, "the directory the results are written to")
+	flag.Parse()
+
 	logger := log.WithFields(log.Fields{"package": "main"})
+
+	if *scenario != "" {
+		runBatch(*scenario, *duration, *replications, *seed, *workers, *interval, *output)
+		return
+	}
+
 	logger.Info("Server Starting")
 
 	demoServer()
@@ -45,3 +63,25 @@ func demoServer() {
 
 	c.Listen()
 }
+
+// runBatch runs the scenario as a batch experiment and exits with a
+// non-zero status if any of it fails.
+func runBatch(scenario string, duration float64, replications int, seed int64, workers int, interval float64, output string) {
+	logger := log.WithFields(log.Fields{"package": "main"})
+
+	// Logging every tick would slow the replications down
+	log.SetLevel(batchLogLevel)
+
+	e, err := batch.NewExperiment(scenario, duration, replications, seed, workers, interval, output)
+	if err != nil {
+		logger.Errorf("Unable to start experiment: %v", err)
+		os.Exit(1)
+	}
+
+	logger.Warnf("Running %v replications of %v", replications, scenario)
+	if _, err := e.Run(); err != nil {
+		logger.Errorf("Experiment failed: %v", err)
+		os.Exit(1)
+	}
+	logger.Warnf("Results written to %v", output)
+}
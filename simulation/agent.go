@@ -1,21 +1,35 @@
 package simulation
 
+import "math/rand"
+
 // Agent is an interface that models any actors that may apear in
 // the simulation.
 type Agent interface {
 	// Act is the method that simulates a tick for that agent.
 	// If true is returned the agent has reached its final destination.
-	Act(agents []Agent, env Environment) (Agent, bool)
+	// Any random behaviour must use rng so runs can be repeated.
+	// The agents and environment are as they were at the start of the
+	// tick and are shared with agents acting at the same time, so they
+	// must not be changed.
+	Act(agents []Agent, env Environment, rng *rand.Rand) (Agent, bool)
 	// GetPosition retrives the agent's current position.
 	GetPosition() Vector
 	// GetID retrives the agent's ID.
 	GetID() int
+	// GetLane returns the lane the agent is in, 0 being the kerbside lane.
+	GetLane() int
+	// GetLanePosition returns the agent's position in the centre of
+	// its lane.
+	GetLanePosition() Vector
 	// GetCurrentWaypoint retrives the agent's current target waypoint.
 	GetCurrentWaypoint() Vector
 	// GetSpeed returns the current speed of the agent.
 	GetSpeed() float64
 	// GetRoute retuns the current route of the agent.
 	GetRoute() []Vector
+	// GetPlannedRoute returns the full route the agent was given when
+	// it was created.
+	GetPlannedRoute() []Vector
 	// GetType returns the type of agent.
 	GetType() string
 	// GetInfo retuns a json string containing information about
@@ -0,0 +1,298 @@
+package simulation
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"math/rand"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// BusStop is a place along a road where buses stop to pick up the
+// passengers waiting there.
+type BusStop struct {
+	// id is a unique integer used to identify the stop.
+	id int
+	// position is the location of the stop.
+	position Vector
+	// arrivalRate is the number of passengers arriving at
+	// the stop each second.
+	arrivalRate float64
+	// waiting is the number of passengers waiting for a bus.
+	waiting float64
+	// boarded is the number of passengers that have boarded
+	// buses at the stop.
+	boarded int
+}
+
+// NewBusStop returns a bus stop at the position given that passengers
+// arrive at with the given rate each second.
+func NewBusStop(id int, pos Vector, arrivalRate float64) (BusStop, error) {
+	if arrivalRate < 0 {
+		return BusStop{}, errors.New("bus stop arrival rate can not be negative")
+	}
+	return BusStop{id: id, position: pos, arrivalRate: arrivalRate}, nil
+}
+
+// GetID returns the id of the stop.
+func (b *BusStop) GetID() int {
+	return b.id
+}
+
+// GetPosition returns the position of the stop.
+func (b *BusStop) GetPosition() Vector {
+	return b.position
+}
+
+// GetArrivalRate returns the number of passengers arriving at the stop
+// each second.
+func (b *BusStop) GetArrivalRate() float64 {
+	return b.arrivalRate
+}
+
+// GetWaiting returns the number of passengers waiting at the stop.
+func (b *BusStop) GetWaiting() int {
+	return int(b.waiting)
+}
+
+// GetBoarded returns the number of passengers that have boarded buses at
+// the stop.
+func (b *BusStop) GetBoarded() int {
+	return b.boarded
+}
+
+// board moves the passengers waiting at the stop onto a bus and returns
+// how many boarded.
+func (b *BusStop) board() int {
+	boarding := math.Floor(b.waiting)
+	b.waiting -= boarding
+	b.boarded += int(boarding)
+	return int(boarding)
+}
+
+// dwellTime returns the number of ticks a bus waits at a stop for the
+// given number of passengers to board, with each tick lasting the given
+// number of seconds.
+func dwellTime(boarding int, parameters Parameters, timeStep float64) int {
+	seconds := parameters.BusDeadTime + float64(boarding)*parameters.BoardingTime
+	return ticks(seconds, timeStep)
+}
+
+// Bus implements the agent interface.
+// The agent is a vehicle that follows a bus line's route, stopping at the
+// line's stops to pick up passengers. Buses keep to the kerbside lane.
+type Bus struct {
+	Vehicle
+	// line is the id of the bus line the bus runs on.
+	line int
+	// trip is the number of the bus's trip along the line,
+	// starting at 0.
+	trip int
+	// stops stores the stops the bus has still to serve.
+	stops []lineStop
+	// serving is true while the bus is stopped at its next stop.
+	serving bool
+	// dwell is the number of ticks left before the bus leaves the
+	// stop it is serving, -1 until the passengers have boarded.
+	dwell int
+	// departed is the id of the stop the bus left this tick,
+	// -1 if it did not leave a stop.
+	departed int
+}
+
+// newBus returns a bus at the start of its trip along the line given.
+func newBus(line *BusLine, trip int) Bus {
+	template := line.vehicle
+	v := NewVehicle(
+		-1,
+		line.route[0],
+		0,
+		template.maxSpeed,
+		template.acceleration,
+		template.deceleration,
+		line.route[1:],
+		0).SetModel(template.getModel()).SetSize(template.length, template.width)
+
+	b := Bus{
+		Vehicle:  v,
+		line:     line.id,
+		trip:     trip,
+		stops:    line.stops,
+		departed: -1}
+	return b.SetID(-1).(Bus)
+}
+
+// Act simulates a bus's behaviour for one tick in the simulation.
+// If true is returned the bus has reached the end of its line.
+func (b Bus) Act(agents []Agent, env Environment, rng *rand.Rand) (Agent, bool) {
+	b.departed = -1
+	parameters := env.GetParameters()
+
+	// Wait at the stop until the passengers have boarded
+	if b.serving {
+		if b.dwell > 0 {
+			b.dwell--
+		}
+		if b.dwell != 0 {
+			b.speed = 0
+			return b, false
+		}
+		b.Logger.Debugf("Leaving stop: %v", b.stops[0].stop)
+		b.departed = b.stops[0].stop
+		b.serving = false
+		b.stops = b.stops[1:]
+	}
+
+	// Stop when the next stop is reached
+	distance, found := b.nextStopDistance()
+	if found && distance <= parameters.Margin {
+		b.Logger.Debugf("Arrived at stop: %v", b.stops[0].stop)
+		b.speed = 0
+		b.serving = true
+		b.dwell = -1
+		return b, false
+	}
+
+	// Check if waypoint reached
+	if b.updateWaypoint(parameters.Margin) {
+		return b, true
+	}
+
+	b.updateLanes(env)
+	b.lane = 0
+
+	// Slow down to touch the next stop like a waypoint
+	surroundings := b.getSurroundings(agents, env)
+	if distance, found := b.nextStopDistance(); found {
+		surroundings.WaypointDistance = math.Min(surroundings.WaypointDistance, distance)
+	}
+	b.setSpeed(surroundings, parameters.MinimumGap, rng)
+
+	b.updatePosition(surroundings.TimeStep)
+	return b, false
+}
+
+// nextStopDistance returns the distance to the bus's next stop. If the
+// stop is not on the section of road the bus is travelling along false
+// is returned.
+func (b *Bus) nextStopDistance() (float64, bool) {
+	// The section of the line's route the bus is on
+	segment := len(b.plannedRoute) - len(b.route) - 1
+
+	// Skip any stops the bus has already passed
+	for len(b.stops) > 0 && b.stops[0].segment < segment {
+		b.Logger.Warnf("Missed stop: %v", b.stops[0].stop)
+		b.stops = b.stops[1:]
+	}
+	if len(b.stops) == 0 || b.stops[0].segment != segment {
+		return 0, false
+	}
+	return b.position.DistanceTo(b.stops[0].position), true
+}
+
+// GetLine returns the id of the bus line the bus runs on.
+func (b Bus) GetLine() int {
+	return b.line
+}
+
+// GetTrip returns the number of the bus's trip along its line.
+func (b Bus) GetTrip() int {
+	return b.trip
+}
+
+// GetNextStop returns the id of the next stop the bus will serve. If the
+// bus has no more stops false is returned.
+func (b Bus) GetNextStop() (id int, found bool) {
+	if len(b.stops) == 0 {
+		return -1, false
+	}
+	return b.stops[0].stop, true
+}
+
+// GetType returns the name of the type of agent, in this case "bus"
+func (b Bus) GetType() string {
+	return "bus"
+}
+
+// GetInfo retuns information about the bus in a json string.
+func (b Bus) GetInfo() string {
+	type busInfo struct {
+		ID              int         `json:"id"`
+		Position        []float64   `json:"position"`
+		Lane            int         `json:"lane"`
+		LanePosition    []float64   `json:"lanePosition"`
+		Speed           float64     `json:"speed"`
+		CurrentWaypoint []float64   `json:"currentWaypoint"`
+		Route           [][]float64 `json:"route"`
+		PlannedRoute    [][]float64 `json:"plannedRoute"`
+		Model           string      `json:"model"`
+		Length          float64     `json:"length"`
+		Width           float64     `json:"width"`
+		Line            int         `json:"line"`
+		Trip            int         `json:"trip"`
+		NextStop        int         `json:"nextStop"`
+		Serving         bool        `json:"serving"`
+		Type            string      `json:"type"`
+	}
+
+	type response struct {
+		Success bool    `json:"success"`
+		Error   string  `json:"error"`
+		Info    busInfo `json:"info"`
+	}
+
+	p := b.GetPosition()
+	lp := b.GetLanePosition()
+	cwp := b.GetCurrentWaypoint()
+	// Convert []Vector to [][]float64
+	var r [][]float64
+	for _, wp := range b.GetRoute() {
+		r = append(r, wp.ConvertToSlice())
+	}
+	var pr [][]float64
+	for _, wp := range b.GetPlannedRoute() {
+		pr = append(pr, wp.ConvertToSlice())
+	}
+	nextStop, _ := b.GetNextStop()
+
+	// Convert the infomation into a json string
+	var resp response
+	resp.Success = true
+	resp.Info = busInfo{
+		ID:              b.GetID(),
+		Position:        p.ConvertToSlice(),
+		Lane:            b.GetLane(),
+		LanePosition:    lp.ConvertToSlice(),
+		Speed:           b.GetSpeed(),
+		CurrentWaypoint: cwp.ConvertToSlice(),
+		Route:           r,
+		PlannedRoute:    pr,
+		Model:           b.getModel().GetName(),
+		Length:          b.length,
+		Width:           b.width,
+		Line:            b.line,
+		Trip:            b.trip,
+		NextStop:        nextStop,
+		Serving:         b.serving,
+		Type:            b.GetType()}
+	jsonStr, _ := json.Marshal(resp)
+	return string(jsonStr)
+}
+
+// SetID changes the value of the bus's id.
+func (b Bus) SetID(newID int) Agent {
+	b.id = newID
+	// Update Logger to display new ID
+	b.Logger = log.WithFields(log.Fields{
+		"package": "simulation",
+		"section": "bus",
+		"id":      b.id})
+	return b
+}
+
+// SetFrequency does nothing as buses are spawned by their line's
+// schedule.
+func (b Bus) SetFrequency(freq int) Agent {
+	return b
+}
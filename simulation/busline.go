@@ -0,0 +1,464 @@
+package simulation
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// BusSchedule sets when buses leave the start of a bus line. Buses
+// either run at a fixed headway or follow a timetable.
+type BusSchedule struct {
+	// Headway is the number of seconds between buses.
+	Headway int `json:"headway"`
+	// First is the number of seconds into the simulation the first
+	// bus leaves when running at a headway.
+	First int `json:"first"`
+	// Last is the latest number of seconds into the simulation a bus
+	// can leave when running at a headway, 0 keeps buses running until
+	// the simulation ends.
+	Last int `json:"last"`
+	// Timetable is the number of seconds into the simulation each bus
+	// leaves, in order.
+	Timetable []int `json:"timetable"`
+}
+
+// StopVisit records a bus serving a stop.
+type StopVisit struct {
+	// Trip is the number of the bus's trip along the line.
+	Trip int `json:"trip"`
+	// Bus is the id of the bus.
+	Bus int `json:"bus"`
+	// Stop is the id of the stop.
+	Stop int `json:"stop"`
+	// Arrival is the tick the bus arrived at the stop.
+	Arrival int `json:"arrival"`
+	// Departure is the tick the bus left the stop, -1 if the bus
+	// is still at the stop.
+	Departure int `json:"departure"`
+	// Boarded is the number of passengers that boarded the bus.
+	Boarded int `json:"boarded"`
+	// Scheduled is the tick the bus should have left the stop, -1 if
+	// the line has no timetabled times at its stops.
+	Scheduled int `json:"scheduled"`
+}
+
+// BusLineReport summarises how regularly and punctually a bus line ran.
+type BusLineReport struct {
+	// Line is the id of the bus line.
+	Line int `json:"line"`
+	// Name is the name of the bus line.
+	Name string `json:"name"`
+	// Trips is the number of trips that have been due to leave the
+	// start of the line.
+	Trips int `json:"trips"`
+	// Stops stores the summary for each stop, in route order.
+	Stops []BusStopReport `json:"stops"`
+	// Visits stores every visit a bus on the line made to a stop.
+	Visits []StopVisit `json:"visits"`
+}
+
+// BusStopReport summarises the buses on one line that served a stop.
+type BusStopReport struct {
+	// Stop is the id of the stop.
+	Stop int `json:"stop"`
+	// Arrivals is the number of buses that arrived at the stop.
+	Arrivals int `json:"arrivals"`
+	// Boarded is the number of passengers that boarded the line's
+	// buses at the stop.
+	Boarded int `json:"boarded"`
+	// MeanDwell is the average number of ticks buses spent at the stop.
+	MeanDwell float64 `json:"meanDwell"`
+	// MeanDwellTime is the average number of seconds buses spent at
+	// the stop.
+	MeanDwellTime float64 `json:"meanDwellTime"`
+	// MeanHeadway is the average number of ticks between buses
+	// arriving at the stop.
+	MeanHeadway float64 `json:"meanHeadway"`
+	// MeanHeadwayTime is the average number of seconds between buses
+	// arriving at the stop.
+	MeanHeadwayTime float64 `json:"meanHeadwayTime"`
+	// HeadwayCV is the coefficient of variation of the headways, 0
+	// when buses arrive perfectly evenly.
+	HeadwayCV float64 `json:"headwayCV"`
+	// Bunched is the number of buses that arrived less than the
+	// bunching ratio of the scheduled headway after the bus before.
+	Bunched int `json:"bunched"`
+	// MeanDelay is the average number of ticks buses left the stop
+	// after their scheduled time, negative if they left early.
+	MeanDelay float64 `json:"meanDelay"`
+	// MeanDelayTime is the average number of seconds buses left the
+	// stop after their scheduled time, negative if they left early.
+	MeanDelayTime float64 `json:"meanDelayTime"`
+	// OnTime is the fraction of timetabled departures that were no
+	// more than the early departure seconds early or the late
+	// departure seconds late.
+	OnTime float64 `json:"onTime"`
+}
+
+// lineStop is a stop on a bus line's route.
+type lineStop struct {
+	// stop is the id of the bus stop.
+	stop int
+	// position is the point on the route buses stop at.
+	position Vector
+	// segment is the index of the section of the route the stop
+	// is on, section i running from route[i] to route[i+1].
+	segment int
+	// time is the number of seconds after leaving the start of the
+	// line the bus should leave the stop, -1 if not timetabled.
+	time int
+}
+
+// BusLine is a route that buses run along, stopping at each of its stops.
+type BusLine struct {
+	// id is a unique integer used to identify the line.
+	id int
+	// name is the name of the line shown in reports.
+	name string
+	// route is the waypoints buses on the line visit.
+	route []Vector
+	// stops stores the stops on the line in route order.
+	stops []lineStop
+	// schedule sets when buses leave the start of the line.
+	schedule BusSchedule
+	// trips is the number of trips that have been due to leave.
+	trips int
+	// vehicle is the vehicle each bus on the line copies its
+	// speeds and car-following model from.
+	vehicle Vehicle
+	// visits stores the visits of the line's buses to its stops.
+	visits []StopVisit
+}
+
+// NewBusLine returns a bus line along the route given. Each of the stops
+// must be within busStopRange of the route, in the order they are passed.
+// The times are the seconds after leaving the start of the line that a bus
+// should leave each stop, they can be left empty if buses only run to a
+// headway. Buses copy their speeds and model from the vehicle given.
+func NewBusLine(id int, name string, route []Vector, stops []BusStop, times []int, schedule BusSchedule, vehicle Vehicle) (BusLine, error) {
+	line := BusLine{id: id, name: name, route: route, schedule: schedule, vehicle: vehicle}
+
+	if len(route) < 2 {
+		return line, errors.New("a bus line needs at least two waypoints")
+	}
+	if len(times) > 0 && len(times) != len(stops) {
+		return line, errors.New("a bus line needs a time for each of its stops")
+	}
+
+	// Check the schedule
+	if (schedule.Headway > 0) == (len(schedule.Timetable) > 0) {
+		return line, errors.New("a bus line needs either a headway or a timetable")
+	}
+	if schedule.Headway < 0 {
+		return line, errors.New("bus line headway can not be negative")
+	}
+	for i, departure := range schedule.Timetable {
+		if departure < 0 || (i > 0 && departure < schedule.Timetable[i-1]) {
+			return line, fmt.Errorf("bus line timetable must be in order: %v", departure)
+		}
+	}
+
+	// Find where each stop is along the route
+	segment := 0
+	for i, stop := range stops {
+		pos, found := -1, false
+		var position Vector
+		for s := segment; s+1 < len(route) && !found; s++ {
+			if position, found = nearestOnSegment(route[s], route[s+1], stop.position); found {
+				pos = s
+			}
+		}
+		if !found {
+			return line, fmt.Errorf("bus stop %v is not along the line's route", stop.id)
+		}
+		segment = pos
+
+		time := -1
+		if len(times) > 0 {
+			time = times[i]
+		}
+		line.stops = append(line.stops, lineStop{stop: stop.id, position: position, segment: pos, time: time})
+	}
+
+	return line, nil
+}
+
+// nearestOnSegment returns the closest point to pos on the section of road
+// between from and to. If pos is more than busStopRange from the section
+// false is returned.
+func nearestOnSegment(from, to, pos Vector) (Vector, bool) {
+	dx, dy := to.x-from.x, to.y-from.y
+	length := dx*dx + dy*dy
+	if length == 0 {
+		return from, false
+	}
+
+	t := ((pos.x-from.x)*dx + (pos.y-from.y)*dy) / length
+	if t < 0 || t > 1 {
+		return from, false
+	}
+	nearest := NewVector(from.x+t*dx, from.y+t*dy)
+	return nearest, nearest.DistanceTo(pos) <= busStopRange
+}
+
+// GetID returns the id of the line.
+func (l *BusLine) GetID() int {
+	return l.id
+}
+
+// GetName returns the name of the line.
+func (l *BusLine) GetName() string {
+	return l.name
+}
+
+// GetSchedule returns when buses leave the start of the line.
+func (l *BusLine) GetSchedule() BusSchedule {
+	return l.schedule
+}
+
+// GetStops returns the ids of the line's stops in route order.
+func (l *BusLine) GetStops() []int {
+	var stops []int
+	for _, s := range l.stops {
+		stops = append(stops, s.stop)
+	}
+	return stops
+}
+
+// departureTime returns the number of seconds into the simulation the trip
+// given should leave the start of the line. If there is no such trip false
+// is returned.
+func (l *BusLine) departureTime(trip int) (int, bool) {
+	if len(l.schedule.Timetable) > 0 {
+		if trip >= len(l.schedule.Timetable) {
+			return 0, false
+		}
+		return l.schedule.Timetable[trip], true
+	}
+
+	departure := l.schedule.First + trip*l.schedule.Headway
+	if l.schedule.Last > 0 && departure > l.schedule.Last {
+		return 0, false
+	}
+	return departure, true
+}
+
+// dispatch returns the buses due to leave the start of the line at the
+// given tick, with each tick lasting the given number of seconds. Trips
+// due before the tick, from before the line was added, do not run.
+func (l *BusLine) dispatch(tick int, timeStep float64) []Bus {
+	var buses []Bus
+	for {
+		departure, found := l.departureTime(l.trips)
+		if !found {
+			return buses
+		}
+		due := ticks(float64(departure), timeStep)
+		if due > tick {
+			return buses
+		}
+		if due == tick {
+			buses = append(buses, newBus(l, l.trips))
+		}
+		l.trips++
+	}
+}
+
+// arrive records a bus arriving at one of the line's stops at the given
+// tick, with each tick lasting the given number of seconds.
+func (l *BusLine) arrive(b Bus, tick, boarded int, timeStep float64) {
+	visit := StopVisit{
+		Trip:      b.trip,
+		Bus:       b.id,
+		Stop:      b.stops[0].stop,
+		Arrival:   tick,
+		Departure: -1,
+		Boarded:   boarded,
+		Scheduled: -1}
+	if b.stops[0].time >= 0 {
+		departure, _ := l.departureTime(b.trip)
+		visit.Scheduled = ticks(float64(departure+b.stops[0].time), timeStep)
+	}
+	l.visits = append(l.visits, visit)
+}
+
+// depart records a bus leaving one of the line's stops.
+func (l *BusLine) depart(b Bus, stop, tick int) {
+	for i := len(l.visits) - 1; i >= 0; i-- {
+		if l.visits[i].Bus == b.id && l.visits[i].Stop == stop {
+			l.visits[i].Departure = tick
+			return
+		}
+	}
+}
+
+// scheduledHeadway returns the average number of seconds between buses
+// leaving the start of the line.
+func (l *BusLine) scheduledHeadway() float64 {
+	if l.schedule.Headway > 0 {
+		return float64(l.schedule.Headway)
+	}
+	timetable := l.schedule.Timetable
+	if len(timetable) < 2 {
+		return 0
+	}
+	return float64(timetable[len(timetable)-1]-timetable[0]) / float64(len(timetable)-1)
+}
+
+// Report summarises the visits made by the line's buses to each stop,
+// using the bunching ratio and on time window of the parameters given
+// and the number of seconds each tick lasts.
+func (l *BusLine) Report(parameters Parameters, timeStep float64) BusLineReport {
+	report := BusLineReport{
+		Line:   l.id,
+		Name:   l.name,
+		Trips:  l.trips,
+		Visits: append([]StopVisit{}, l.visits...)}
+	scheduled := l.scheduledHeadway()
+
+	for _, s := range l.stops {
+		stop := BusStopReport{Stop: s.stop}
+
+		var arrivals []int
+		var dwell, dwelled, timed, onTime int
+		var delay float64
+		for _, v := range l.visits {
+			if v.Stop != s.stop {
+				continue
+			}
+			stop.Arrivals++
+			stop.Boarded += v.Boarded
+			arrivals = append(arrivals, v.Arrival)
+
+			if v.Departure < 0 {
+				continue
+			}
+			dwell += v.Departure - v.Arrival
+			dwelled++
+
+			// Schedule adherence
+			if v.Scheduled >= 0 {
+				late := v.Departure - v.Scheduled
+				delay += float64(late)
+				timed++
+				seconds := float64(late) * timeStep
+				if seconds >= -parameters.EarlyDeparture && seconds <= parameters.LateDeparture {
+					onTime++
+				}
+			}
+		}
+
+		if dwelled > 0 {
+			stop.MeanDwell = float64(dwell) / float64(dwelled)
+			stop.MeanDwellTime = stop.MeanDwell * timeStep
+		}
+		if timed > 0 {
+			stop.MeanDelay = delay / float64(timed)
+			stop.MeanDelayTime = stop.MeanDelay * timeStep
+			stop.OnTime = float64(onTime) / float64(timed)
+		}
+
+		// Headways between buses arriving at the stop
+		sort.Ints(arrivals)
+		if len(arrivals) > 1 {
+			var sum, squares float64
+			for i := 1; i < len(arrivals); i++ {
+				headway := float64(arrivals[i] - arrivals[i-1])
+				sum += headway
+				squares += headway * headway
+				if headway*timeStep < parameters.BunchingRatio*scheduled {
+					stop.Bunched++
+				}
+			}
+			n := float64(len(arrivals) - 1)
+			stop.MeanHeadway = sum / n
+			stop.MeanHeadwayTime = stop.MeanHeadway * timeStep
+			if stop.MeanHeadway > 0 {
+				variance := math.Max(squares/n-stop.MeanHeadway*stop.MeanHeadway, 0)
+				stop.HeadwayCV = math.Sqrt(variance) / stop.MeanHeadway
+			}
+		}
+
+		report.Stops = append(report.Stops, stop)
+	}
+	return report
+}
+
+// AddBusStop adds a bus stop to the simulation that passengers arrive at
+// with the given rate each second.
+func (s *Simulation) AddBusStop(pos Vector, arrivalRate float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.environment.AddBusStop(pos, arrivalRate)
+}
+
+// AddBusLine adds a bus line along the route given, stopping at the stops
+// with the ids given. Buses start running at the line's schedule from the
+// next tick.
+func (s *Simulation) AddBusLine(name string, route []Vector, stops []int, times []int, schedule BusSchedule, vehicle Vehicle) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var busStops []BusStop
+	for _, id := range stops {
+		stop, found := s.environment.GetBusStop(id)
+		if !found {
+			return fmt.Errorf("no bus stop found with the id: %v", id)
+		}
+		busStops = append(busStops, stop)
+	}
+
+	line, err := NewBusLine(len(s.lines), name, route, busStops, times, schedule, vehicle)
+	if err != nil {
+		return err
+	}
+	s.lines = append(s.lines, line)
+	return nil
+}
+
+// GetBusReport returns the report of the bus line with the given id.
+func (s *Simulation) GetBusReport(id int) (BusLineReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id < 0 || id >= len(s.lines) {
+		return BusLineReport{}, fmt.Errorf("no bus line found with the id: %v", id)
+	}
+	return s.lines[id].Report(s.environment.GetParameters(), s.environment.GetTimeStep()), nil
+}
+
+// dispatchBuses adds the buses due to leave the start of their line.
+func (s *Simulation) dispatchBuses() {
+	for i := range s.lines {
+		for _, bus := range s.lines[i].dispatch(s.currentTick, s.environment.GetTimeStep()) {
+			s.addAgent(bus)
+		}
+	}
+}
+
+// serveBusStops boards the passengers onto buses that have arrived at a
+// stop and records the buses that arrived at and left the stops.
+func (s *Simulation) serveBusStops() {
+	for i, agent := range s.agents {
+		bus, ok := agent.(Bus)
+		if !ok || bus.line >= len(s.lines) {
+			continue
+		}
+		line := &s.lines[bus.line]
+
+		if bus.departed >= 0 {
+			line.depart(bus, bus.departed, s.currentTick)
+		}
+
+		if bus.serving && bus.dwell < 0 {
+			boarded := s.environment.boardBus(bus.stops[0].stop)
+			bus.dwell = dwellTime(boarded, s.environment.GetParameters(), s.environment.GetTimeStep())
+			line.arrive(bus, s.currentTick, boarded, s.environment.GetTimeStep())
+			s.agents[i] = bus
+			s.environment.traffic.update(bus)
+		}
+	}
+}
@@ -0,0 +1,357 @@
+package simulation
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// RulesModel is the name of the cellular automaton rules, based on the
+// Nagel-Schreckenberg model, that vehicles follow by default.
+const RulesModel = "rules"
+
+// IDMModel is the name of the Intelligent Driver Model.
+const IDMModel = "idm"
+
+// GippsModel is the name of Gipps' car-following model.
+const GippsModel = "gipps"
+
+// modelParameters are the names of the parameters each car-following
+// model can be set up with.
+var modelParameters = map[string][]string{
+	RulesModel: {},
+	IDMModel:   {"timeHeadway", "minGap", "comfortableDeceleration", "exponent"},
+	GippsModel: {"reactionTime", "minGap", "leaderDeceleration"}}
+
+// Surroundings is what a vehicle can see when deciding its speed for the
+// next tick. Distances without anything to stop for are math.MaxFloat64.
+// Speeds are in metres per second and rates of change of speed in metres
+// per second squared.
+type Surroundings struct {
+	// TimeStep is the number of seconds the next tick lasts, 0 is
+	// treated as 1 second.
+	TimeStep float64
+	// Speed is the current speed of the vehicle.
+	Speed float64
+	// MaxSpeed is the fastest the vehicle wants to travel.
+	MaxSpeed float64
+	// Acceleration is the rate the vehicle can increase its speed.
+	Acceleration float64
+	// Deceleration is the rate the vehicle can decrease its speed.
+	Deceleration float64
+	// Gap is the distance to the vehicle infront.
+	Gap float64
+	// LeaderSpeed is the speed of the vehicle infront.
+	LeaderSpeed float64
+	// LightDistance is the distance to the light ahead if it shows stop,
+	// or to a crosswalk ahead with pedestrians on it if that is closer.
+	LightDistance float64
+	// WaypointDistance is the distance to the vehicle's current waypoint.
+	WaypointDistance float64
+	// DecelerationProbability is the chance a vehicle following the
+	// Rules slows down even if there is nothing ahead.
+	DecelerationProbability float64
+}
+
+// step returns the number of seconds the next tick lasts.
+func (s Surroundings) step() float64 {
+	if s.TimeStep <= 0 {
+		return defaultTimeStep
+	}
+	return s.TimeStep
+}
+
+// CarFollowingModel decides how fast a vehicle travels based on the
+// vehicles and lights around it.
+type CarFollowingModel interface {
+	// GetName returns the name used to select the model.
+	GetName() string
+	// GetParameters returns the values the model was created with,
+	// keyed by the names used by NewCarFollowingModel.
+	GetParameters() map[string]float64
+	// NextSpeed returns the speed of the vehicle for the next tick.
+	// Any random behaviour must use rng so runs can be repeated.
+	NextSpeed(s Surroundings, rng *rand.Rand) float64
+}
+
+// NewCarFollowingModel returns the model with the given name set up with
+// the parameters given. Parameters that are missing use their defaults,
+// an empty name returns the RulesModel. An error is returned if a
+// parameter is negative or is not used by the model.
+func NewCarFollowingModel(name string, parameters map[string]float64) (CarFollowingModel, error) {
+	if name == "" {
+		name = RulesModel
+	}
+	known, ok := modelParameters[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown car-following model: %v", name)
+	}
+
+	// Check the parameters in order so the same error is always given
+	keys := make([]string, 0, len(parameters))
+	for key := range parameters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		found := false
+		for _, k := range known {
+			if k == key {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown parameter for the %v model: %v", name, key)
+		}
+		if parameters[key] < 0 {
+			return nil, fmt.Errorf("%v can not be negative: %v", key, parameters[key])
+		}
+	}
+
+	switch name {
+	case IDMModel:
+		return NewIDM(
+			parameters["timeHeadway"],
+			parameters["minGap"],
+			parameters["comfortableDeceleration"],
+			parameters["exponent"]), nil
+	case GippsModel:
+		return NewGipps(
+			parameters["reactionTime"],
+			parameters["minGap"],
+			parameters["leaderDeceleration"]), nil
+	default:
+		return Rules{}, nil
+	}
+}
+
+// Rules is the car-following model vehicles have always used. Vehicles
+// stop for lights, accelerate when there is space and otherwise slow
+// down at random.
+type Rules struct{}
+
+// GetName returns RulesModel.
+func (m Rules) GetName() string {
+	return RulesModel
+}
+
+// GetParameters returns an empty map as the rules have no parameters.
+func (m Rules) GetParameters() map[string]float64 {
+	return map[string]float64{}
+}
+
+// NextSpeed applies the rules in order, using the first that matches.
+func (m Rules) NextSpeed(s Surroundings, rng *rand.Rand) float64 {
+	// 1. Slow down for lights
+	// 2. Slow down to touch waypoint
+	// 3. Slow down due to other agents
+	// 4. Accelerate if space
+	// 5. Random Decelerate
+
+	// The rules are applied to the distances travelled in one tick
+	dt := s.step()
+	travel := s.Speed * dt
+	accelerated := s.Speed + s.Acceleration*dt
+
+	// If the vehicle is going to pass a light showing stop, stop
+	if s.LightDistance <= accelerated*dt {
+		return 0
+	}
+
+	// Slow down to touch waypoint
+	if s.WaypointDistance <= travel {
+		return s.WaypointDistance / dt
+	}
+
+	// Slowing down due to other cars:
+	//	Each vehicle (speed v) with gap ≤ v−d reduces its speed to gap: v → gap.
+	//	if gap ≤ v-d then v = gap
+	if s.Gap <= travel {
+		// decelerate to create a gap between the vehicles
+		return math.Max(s.Gap/dt-s.Deceleration*dt, 0)
+	}
+
+	// Acceleration of free vehicles:
+	// 	Each vehicle of speed v < vmax with gap ≥ v+1 accelerates to v+1.
+	// 	if v < vmax & gap ≥ v + a then v = v + a
+	if s.Speed < s.MaxSpeed && s.Gap >= accelerated*dt {
+		return math.Min(accelerated, s.MaxSpeed)
+	}
+
+	// Randomization:
+	//	Each vehicle reduces its speed by deceleration with the
+	//	simulation's deceleration probability: v → max[ v − 1, 0 ]
+	if rng.Float64() < s.DecelerationProbability {
+		return math.Max(s.Speed-s.Deceleration*dt, 0)
+	}
+
+	return s.Speed
+}
+
+// IDM is the Intelligent Driver Model. Vehicles accelerate smoothly
+// towards their maximum speed and brake to keep a safe time gap to the
+// vehicle infront. A light showing stop is treated as a stopped vehicle.
+type IDM struct {
+	// timeHeadway is the time gap the vehicle tries to keep to
+	// the vehicle infront.
+	timeHeadway float64
+	// minGap is the gap left to a stopped vehicle.
+	minGap float64
+	// comfortableDeceleration is how hard the vehicle
+	// prefers to brake.
+	comfortableDeceleration float64
+	// exponent controls how quickly the acceleration falls
+	// as the vehicle nears its maximum speed.
+	exponent float64
+}
+
+// NewIDM returns an Intelligent Driver Model using the parameters given.
+// Any parameter that is 0 uses its default.
+func NewIDM(timeHeadway, minGap, comfortableDeceleration, exponent float64) IDM {
+	if timeHeadway == 0 {
+		timeHeadway = defaultTimeHeadway
+	}
+	if minGap == 0 {
+		minGap = defaultMinGap
+	}
+	if comfortableDeceleration == 0 {
+		comfortableDeceleration = defaultComfortableDeceleration
+	}
+	if exponent == 0 {
+		exponent = defaultAccelerationExponent
+	}
+	return IDM{
+		timeHeadway:             timeHeadway,
+		minGap:                  minGap,
+		comfortableDeceleration: comfortableDeceleration,
+		exponent:                exponent}
+}
+
+// GetName returns IDMModel.
+func (m IDM) GetName() string {
+	return IDMModel
+}
+
+// GetParameters returns the parameters of the model.
+func (m IDM) GetParameters() map[string]float64 {
+	return map[string]float64{
+		"timeHeadway":             m.timeHeadway,
+		"minGap":                  m.minGap,
+		"comfortableDeceleration": m.comfortableDeceleration,
+		"exponent":                m.exponent}
+}
+
+// NextSpeed applies the IDM acceleration for one tick.
+func (m IDM) NextSpeed(s Surroundings, rng *rand.Rand) float64 {
+	dt := s.step()
+	speed := math.Max(s.Speed+m.acceleration(s)*dt, 0)
+	return math.Min(speed, s.WaypointDistance/dt)
+}
+
+// acceleration returns the acceleration the IDM gives, in metres per
+// second squared.
+func (m IDM) acceleration(s Surroundings) float64 {
+	// Free road acceleration
+	acceleration := 1.0
+	if s.MaxSpeed > 0 {
+		acceleration -= math.Pow(s.Speed/s.MaxSpeed, m.exponent)
+	}
+
+	// Interaction with the vehicle or light infront
+	gap, leaderSpeed := nearestObstacle(s)
+	if gap < math.MaxFloat64 {
+		desiredGap := m.minGap + math.Max(0, s.Speed*m.timeHeadway+
+			s.Speed*(s.Speed-leaderSpeed)/(2*math.Sqrt(s.Acceleration*m.comfortableDeceleration)))
+		acceleration -= math.Pow(desiredGap/math.Max(gap, 0.01), 2)
+	}
+
+	return s.Acceleration * acceleration
+}
+
+// Gipps is Gipps' car-following model. Vehicles travel as fast as they
+// can while still being able to stop if the vehicle infront brakes.
+// A light showing stop is treated as a stopped vehicle.
+type Gipps struct {
+	// reactionTime is the number of seconds the vehicle takes
+	// to react to the vehicle infront.
+	reactionTime float64
+	// minGap is the gap left to a stopped vehicle.
+	minGap float64
+	// leaderDeceleration is how hard the vehicle expects the
+	// vehicle infront to brake, 0 assumes it brakes as hard
+	// as this vehicle.
+	leaderDeceleration float64
+}
+
+// NewGipps returns a Gipps model using the parameters given. The
+// reaction time and minimum gap use their defaults if they are 0.
+func NewGipps(reactionTime, minGap, leaderDeceleration float64) Gipps {
+	if reactionTime == 0 {
+		reactionTime = defaultReactionTime
+	}
+	if minGap == 0 {
+		minGap = defaultMinGap
+	}
+	return Gipps{
+		reactionTime:       reactionTime,
+		minGap:             minGap,
+		leaderDeceleration: leaderDeceleration}
+}
+
+// GetName returns GippsModel.
+func (m Gipps) GetName() string {
+	return GippsModel
+}
+
+// GetParameters returns the parameters of the model.
+func (m Gipps) GetParameters() map[string]float64 {
+	return map[string]float64{
+		"reactionTime":       m.reactionTime,
+		"minGap":             m.minGap,
+		"leaderDeceleration": m.leaderDeceleration}
+}
+
+// NextSpeed returns the lower of the free road speed and the
+// safe speed behind the vehicle infront.
+func (m Gipps) NextSpeed(s Surroundings, rng *rand.Rand) float64 {
+	tau := m.reactionTime
+	dt := s.step()
+
+	// Free road speed
+	speed := s.Speed
+	if s.MaxSpeed > 0 {
+		ratio := math.Max(s.Speed/s.MaxSpeed, 0)
+		speed += 2.5 * s.Acceleration * dt * (1 - ratio) * math.Sqrt(0.025+ratio)
+	}
+
+	// Safe speed behind the vehicle or light infront
+	gap, leaderSpeed := nearestObstacle(s)
+	if gap < math.MaxFloat64 && s.Deceleration > 0 {
+		leaderDeceleration := m.leaderDeceleration
+		if leaderDeceleration <= 0 {
+			leaderDeceleration = s.Deceleration
+		}
+
+		b := s.Deceleration
+		root := b*b*tau*tau + b*(2*(gap-m.minGap)-s.Speed*tau+leaderSpeed*leaderSpeed/leaderDeceleration)
+		safe := 0.0
+		if root > 0 {
+			safe = -b*tau + math.Sqrt(root)
+		}
+		speed = math.Min(speed, safe)
+	}
+
+	speed = math.Max(speed, 0)
+	return math.Min(speed, s.WaypointDistance/dt)
+}
+
+// nearestObstacle returns the gap to, and speed of, whichever is closer
+// out of the vehicle infront and a light showing stop or busy crosswalk.
+func nearestObstacle(s Surroundings) (gap float64, speed float64) {
+	if s.LightDistance < s.Gap {
+		return s.LightDistance, 0
+	}
+	return s.Gap, s.LeaderSpeed
+}
@@ -0,0 +1,149 @@
+package simulation
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// TestIDMSteadyStateGap checks a vehicle following a leader at a constant
+// speed settles at the IDM's equilibrium gap.
+func TestIDMSteadyStateGap(t *testing.T) {
+	model := NewIDM(1.5, 2, 1.5, 4)
+	rng := rand.New(rand.NewSource(1))
+	const leaderSpeed, maxSpeed, dt = 10.0, 30.0, 0.1
+
+	speed, gap := 0.0, 50.0
+	for i := 0; i < 5000; i++ {
+		s := Surroundings{
+			TimeStep:         dt,
+			Speed:            speed,
+			MaxSpeed:         maxSpeed,
+			Acceleration:     2,
+			Deceleration:     4,
+			Gap:              gap,
+			LeaderSpeed:      leaderSpeed,
+			LightDistance:    math.MaxFloat64,
+			WaypointDistance: math.MaxFloat64}
+		next := model.NextSpeed(s, rng)
+		gap += (leaderSpeed - (speed+next)/2) * dt
+		speed = next
+	}
+
+	// s = (s0 + vT) / sqrt(1 - (v/v0)^δ)
+	want := (2 + leaderSpeed*1.5) / math.Sqrt(1-math.Pow(leaderSpeed/maxSpeed, 4))
+	if math.Abs(gap-want) > 0.05 {
+		t.Fatalf("gap %v, want %v", gap, want)
+	}
+	if math.Abs(speed-leaderSpeed) > 0.01 {
+		t.Fatalf("speed %v, want %v", speed, leaderSpeed)
+	}
+}
+
+// TestGippsSafeSpeed checks the speed Gipps' model gives always lets the
+// vehicle react and then stop at least the min gap behind where the
+// leader stops if it brakes as hard as expected.
+func TestGippsSafeSpeed(t *testing.T) {
+	const reactionTime, minGap, deceleration, leaderDeceleration = 1.0, 2.0, 4.0, 3.0
+	model := NewGipps(reactionTime, minGap, leaderDeceleration)
+	rng := rand.New(rand.NewSource(1))
+
+	for speed := 0.0; speed <= 30; speed += 2.5 {
+		for leaderSpeed := 0.0; leaderSpeed <= 30; leaderSpeed += 2.5 {
+			for gap := minGap; gap <= 100; gap += 4 {
+				s := Surroundings{
+					TimeStep:         1,
+					Speed:            speed,
+					MaxSpeed:         30,
+					Acceleration:     2,
+					Deceleration:     deceleration,
+					Gap:              gap,
+					LeaderSpeed:      leaderSpeed,
+					LightDistance:    math.MaxFloat64,
+					WaypointDistance: math.MaxFloat64}
+				next := model.NextSpeed(s, rng)
+
+				stopping := next*reactionTime + next*next/(2*deceleration)
+				available := gap - minGap + leaderSpeed*leaderSpeed/(2*leaderDeceleration)
+				if stopping > available+1e-9 {
+					t.Fatalf("speed %v behind a leader at %v with gap %v gives %v, needing %v to stop with %v available",
+						speed, leaderSpeed, gap, next, stopping, available)
+				}
+			}
+		}
+	}
+}
+
+// TestAddAgentModel checks each vehicle added the way /simulation/add
+// adds them uses the model and parameters it is given.
+func TestAddAgentModel(t *testing.T) {
+	sc, err := ReadScenario(strings.NewReader(`{"environment": {"nodes": [[0,0],[500,0]], "links": [{"from":0,"to":1}]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewSimulationFromScenario(sc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	agents := []struct {
+		json       string
+		model      string
+		parameters map[string]float64
+	}{
+		{`{"type":"vehicle","route":[[500,0]],"startLocation":[0,0],"maxSpeed":10,"acceleration":2,"deceleration":4}`,
+			RulesModel, map[string]float64{}},
+		{`{"type":"vehicle","route":[[500,0]],"startLocation":[0,0],"maxSpeed":10,"acceleration":2,"deceleration":4,
+			"model":"idm","modelParameters":{"timeHeadway":2}}`,
+			IDMModel, map[string]float64{"timeHeadway": 2, "minGap": defaultMinGap,
+				"comfortableDeceleration": defaultComfortableDeceleration, "exponent": defaultAccelerationExponent}},
+		{`{"type":"vehicle","route":[[500,0]],"startLocation":[0,0],"maxSpeed":10,"acceleration":2,"deceleration":4,
+			"model":"gipps","modelParameters":{"reactionTime":0.5,"leaderDeceleration":3}}`,
+			GippsModel, map[string]float64{"reactionTime": 0.5, "minGap": defaultMinGap, "leaderDeceleration": 3}},
+	}
+	for _, a := range agents {
+		if err := s.AddScenarioAgent(json.RawMessage(a.json)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	added := s.GetAgents()
+	if len(added) != len(agents) {
+		t.Fatalf("%v agents, want %v", len(added), len(agents))
+	}
+	for i, a := range added {
+		model := a.(Vehicle).GetModel()
+		if model.GetName() != agents[i].model {
+			t.Fatalf("vehicle %v uses %v, want %v", i, model.GetName(), agents[i].model)
+		}
+		parameters := model.GetParameters()
+		if len(parameters) != len(agents[i].parameters) {
+			t.Fatalf("vehicle %v has parameters %v, want %v", i, parameters, agents[i].parameters)
+		}
+		for key, value := range agents[i].parameters {
+			if parameters[key] != value {
+				t.Fatalf("vehicle %v has parameters %v, want %v", i, parameters, agents[i].parameters)
+			}
+		}
+	}
+
+	// Parameters the model does not use or that are negative are rejected
+	invalid := []string{
+		`{"type":"vehicle","route":[[500,0]],"startLocation":[0,0],"maxSpeed":10,"acceleration":2,"deceleration":4,
+			"model":"idm","modelParameters":{"reactionTime":1}}`,
+		`{"type":"vehicle","route":[[500,0]],"startLocation":[0,0],"maxSpeed":10,"acceleration":2,"deceleration":4,
+			"model":"gipps","modelParameters":{"minGap":-1}}`,
+		`{"type":"vehicle","route":[[500,0]],"startLocation":[0,0],"maxSpeed":10,"acceleration":2,"deceleration":4,
+			"model":"unknown"}`,
+	}
+	for _, raw := range invalid {
+		if err := s.AddScenarioAgent(json.RawMessage(raw)); err == nil {
+			t.Fatalf("no error adding %v", raw)
+		}
+	}
+	if len(s.GetAgents()) != len(agents) {
+		t.Fatalf("%v agents after invalid agents, want %v", len(s.GetAgents()), len(agents))
+	}
+}
@@ -0,0 +1,262 @@
+package simulation
+
+import (
+	"math"
+	"sort"
+)
+
+// Collision records two vehicles whose bodies started to overlap.
+type Collision struct {
+	// Tick is the tick the vehicles started to overlap.
+	Tick int `json:"tick"`
+	// Time is the number of seconds simulated by the end of the tick.
+	Time float64 `json:"time"`
+	// Position is the point halfway between the fronts of the
+	// two vehicles.
+	Position Vector `json:"position"`
+	// Agents stores the ids of the two vehicles.
+	Agents []int `json:"agents"`
+	// Types stores the types of the two vehicles.
+	Types []string `json:"types"`
+}
+
+// agentPair is the ids of two agents, the lower id first.
+type agentPair struct {
+	first  int
+	second int
+}
+
+// footprint is the rectangle of road covered by a vehicle.
+type footprint struct {
+	// front is the centre of the vehicle's front bumper.
+	front Vector
+	// heading is the unit vector the vehicle is facing.
+	heading Vector
+	// length is the length of the vehicle.
+	length float64
+	// width is the width of the vehicle.
+	width float64
+}
+
+// newFootprint returns the rectangle covered by the vehicle. The vehicle's
+// lane position is the centre of its front bumper and the body extends
+// back along the road it is travelling.
+func newFootprint(v Vehicle) footprint {
+	heading := v.lastWaypoint.DirectionTo(v.currentWaypoint)
+	if heading.Equals(Vector{}) {
+		heading = v.position.DirectionTo(v.currentWaypoint)
+	}
+	if heading.Equals(Vector{}) {
+		heading = Vector{x: 1}
+	}
+	return footprint{
+		front:   v.GetLanePosition(),
+		heading: heading,
+		length:  v.length,
+		width:   v.width}
+}
+
+// corners returns the four corners of the footprint.
+func (f footprint) corners() []Vector {
+	rear := Vector{x: f.front.x - f.heading.x*f.length, y: f.front.y - f.heading.y*f.length}
+	side := Vector{x: -f.heading.y * f.width / 2, y: f.heading.x * f.width / 2}
+	return []Vector{
+		{x: f.front.x + side.x, y: f.front.y + side.y},
+		{x: f.front.x - side.x, y: f.front.y - side.y},
+		{x: rear.x - side.x, y: rear.y - side.y},
+		{x: rear.x + side.x, y: rear.y + side.y}}
+}
+
+// overlaps returns true if the two footprints cover any of the same road,
+// using the separating axis test for rectangles.
+func (f footprint) overlaps(other footprint) bool {
+	// Footprints too far apart can not touch
+	reach := f.length + other.length + (f.width+other.width)/2
+	if f.front.DistanceTo(other.front) > reach {
+		return false
+	}
+
+	corners := f.corners()
+	otherCorners := other.corners()
+	axes := []Vector{
+		f.heading,
+		{x: -f.heading.y, y: f.heading.x},
+		other.heading,
+		{x: -other.heading.y, y: other.heading.x}}
+	for _, axis := range axes {
+		min, max := project(corners, axis)
+		otherMin, otherMax := project(otherCorners, axis)
+		if max <= otherMin || otherMax <= min {
+			return false
+		}
+	}
+	return true
+}
+
+// project returns the smallest and largest projections of the points
+// onto the axis.
+func project(points []Vector, axis Vector) (min float64, max float64) {
+	min = math.MaxFloat64
+	max = -math.MaxFloat64
+	for _, p := range points {
+		d := p.x*axis.x + p.y*axis.y
+		min = math.Min(min, d)
+		max = math.Max(max, d)
+	}
+	return
+}
+
+// agentLength returns the length of an agent, 0 for agents that are not
+// vehicles.
+func agentLength(agent Agent) float64 {
+	if v, ok := asVehicle(agent); ok {
+		return v.length
+	}
+	return 0
+}
+
+// moved returns the footprint moved back along the vehicle's path by the
+// given fraction of the distance it travelled this tick.
+func (f footprint) moved(travelled Vector, fraction float64) footprint {
+	f.front.x -= travelled.x * fraction
+	f.front.y -= travelled.y * fraction
+	return f
+}
+
+// collide returns true if the two vehicles overlapped at any point during
+// the tick. As vehicles can pass through each other in a single tick their
+// paths are checked in steps no longer than collisionStep.
+func collide(a, b footprint, aTravelled, bTravelled Vector) bool {
+	distance := math.Max(aTravelled.Magnitude(), bTravelled.Magnitude())
+	steps := int(math.Ceil(distance / collisionStep))
+	for i := 0; i <= steps; i++ {
+		fraction := 0.0
+		if steps > 0 {
+			fraction = float64(i) / float64(steps)
+		}
+		if a.moved(aTravelled, fraction).overlaps(b.moved(bTravelled, fraction)) {
+			return true
+		}
+	}
+	return false
+}
+
+// detectCollisions records the vehicles that started to overlap this
+// tick, given where each agent started the tick. Pedestrians are not
+// checked as they walk beside the road. True is returned if there were
+// any new collisions.
+func (s *Simulation) detectCollisions(previous map[int]Vector) bool {
+	var vehicles []Vehicle
+	var types []string
+	var footprints []footprint
+	var travelled []Vector
+	for _, agent := range s.agents {
+		if v, ok := asVehicle(agent); ok {
+			vehicles = append(vehicles, v)
+			types = append(types, agent.GetType())
+			footprints = append(footprints, newFootprint(v))
+
+			// Vehicles added this tick have not moved
+			start, found := previous[v.id]
+			if !found {
+				start = v.position
+			}
+			travelled = append(travelled, Vector{x: v.position.x - start.x, y: v.position.y - start.y})
+		}
+	}
+
+	// Place the vehicles in a grid so only the vehicles near each other
+	// are checked. reaches stores how far from its front a vehicle's body
+	// could have been during the tick.
+	reaches := make([]float64, len(vehicles))
+	furthest := 0.0
+	cells := make(map[gridCell][]int)
+	for i := range vehicles {
+		reaches[i] = footprints[i].length + footprints[i].width/2 + travelled[i].Magnitude()
+		furthest = math.Max(furthest, reaches[i])
+		cell := cellOf(footprints[i].front)
+		cells[cell] = append(cells[cell], i)
+	}
+
+	collided := false
+	overlapping := make(map[agentPair]bool)
+	for i := 0; i < len(vehicles); i++ {
+		for _, j := range nearbyFootprints(cells, footprints, reaches, i, furthest) {
+			if !collide(footprints[i], footprints[j], travelled[i], travelled[j]) {
+				continue
+			}
+
+			a, b := vehicles[i], vehicles[j]
+			aType, bType := types[i], types[j]
+			if a.id > b.id {
+				a, b = b, a
+				aType, bType = bType, aType
+			}
+			pair := agentPair{first: a.id, second: b.id}
+			overlapping[pair] = true
+
+			// Only record the tick the vehicles first touch
+			if s.overlapping[pair] {
+				continue
+			}
+			collided = true
+			s.Logger.Warnf("Collision between %v and %v at %v", a.id, b.id, a.position)
+			s.collisions = append(s.collisions, Collision{
+				Tick:     s.currentTick,
+				Time:     s.seconds(s.currentTick),
+				Position: Vector{x: (a.position.x + b.position.x) / 2, y: (a.position.y + b.position.y) / 2},
+				Agents:   []int{a.id, b.id},
+				Types:    []string{aType, bType}})
+		}
+	}
+	s.overlapping = overlapping
+	return collided
+}
+
+// nearbyFootprints returns the indexes, in increasing order and greater
+// than i, of the footprints that could have touched footprint i during
+// the tick.
+func nearbyFootprints(cells map[gridCell][]int, footprints []footprint, reaches []float64, i int, furthest float64) []int {
+	var nearby []int
+	front := footprints[i].front
+	radius := reaches[i] + furthest
+	low := cellOf(Vector{x: front.x - radius, y: front.y - radius})
+	high := cellOf(Vector{x: front.x + radius, y: front.y + radius})
+	for x := low.x; x <= high.x; x++ {
+		for y := low.y; y <= high.y; y++ {
+			for _, j := range cells[gridCell{x: x, y: y}] {
+				if j > i && front.DistanceTo(footprints[j].front) <= reaches[i]+reaches[j] {
+					nearby = append(nearby, j)
+				}
+			}
+		}
+	}
+	sort.Ints(nearby)
+	return nearby
+}
+
+// GetCollisions returns a copy of the collisions recorded so far.
+func (s *Simulation) GetCollisions() []Collision {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Collision{}, s.collisions...)
+}
+
+// SetHaltOnCollision sets whether the simulation stops running at the end
+// of a tick where vehicles collide.
+func (s *Simulation) SetHaltOnCollision(halt bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.haltOnCollision = halt
+}
+
+// GetHaltOnCollision returns true if the simulation stops running when
+// vehicles collide.
+func (s *Simulation) GetHaltOnCollision() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.haltOnCollision
+}
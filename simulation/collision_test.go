@@ -0,0 +1,39 @@
+package simulation
+
+import (
+	"testing"
+
+	shp "github.com/jonas-p/go-shp"
+)
+
+// TestOpposingTraffic checks vehicles passing each other in opposite
+// directions along a two-way road do not collide, while vehicles on top
+// of each other do.
+func TestOpposingTraffic(t *testing.T) {
+	env := NewEnvironment()
+	env.addPolyLine([]int32{0}, []shp.Point{{X: 0, Y: 0}, {X: 200, Y: 0}}, map[string]string{})
+	s := NewSimulation(env)
+
+	for _, size := range [][]float64{{defaultVehicleLength, defaultVehicleWidth}, {defaultBusLength, defaultBusWidth}} {
+		east := NewVehicle(-1, NewVector(0, 0), 10, 10, 2, 4, []Vector{NewVector(200, 0)}, 0)
+		west := NewVehicle(-1, NewVector(200, 0), 10, 10, 2, 4, []Vector{NewVector(0, 0)}, 0)
+		s.AddAgent(east.SetSize(size[0], size[1]))
+		s.AddAgent(west.SetSize(size[0], size[1]))
+		s.RunSteps(30)
+		if len(s.GetAgents()) > 0 {
+			t.Fatalf("vehicles %v did not pass each other", s.GetAgents())
+		}
+		if len(s.GetCollisions()) > 0 {
+			t.Fatalf("vehicles %v long and %v wide passing each other collided: %+v", size[0], size[1], s.GetCollisions())
+		}
+	}
+
+	// Vehicles starting in the same place overlap
+	for i := 0; i < 2; i++ {
+		s.AddAgent(NewVehicle(-1, NewVector(0, 0), 0, 10, 2, 4, []Vector{NewVector(200, 0)}, 0))
+	}
+	s.RunSteps(1)
+	if len(s.GetCollisions()) != 1 {
+		t.Fatalf("collisions %+v, want 1", s.GetCollisions())
+	}
+}
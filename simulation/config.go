@@ -1,8 +1,183 @@
 package simulation
 
-// decelerationProbability is the proabability that a vehicle might deccelerate
-const decelerationProbability = 0.5
+// defaultTimeStep is the number of seconds each tick simulates when no
+// time step is set.
+const defaultTimeStep = 1.0
 
-// margin is the maximum distance a agent can be to a point for
-// it to register that the agent has visited that point.
-const margin = 1.0
+// defaultDecelerationProbability is the proabability that a vehicle might
+// deccelerate, unless the simulation's parameters say otherwise.
+const defaultDecelerationProbability = 0.5
+
+// defaultMargin is the maximum distance a agent can be to a point for
+// it to register that the agent has visited that point, unless the
+// simulation's parameters say otherwise.
+const defaultMargin = 1.0
+
+// defaultSpeedLimit is the speed assumed for links that do not have a
+// speed limit when finding the fastest route.
+const defaultSpeedLimit = 13.4
+
+// defaultMinGreen is the shortest green, in seconds, given by actuated
+// and adaptive signal controllers unless one is set.
+const defaultMinGreen = 5
+
+// defaultGap is the longest time, in seconds, an approaching vehicle can
+// be from a light and still extend an actuated green.
+const defaultGap = 3.0
+
+// defaultDetectionDistance is how far from a light vehicles are counted
+// by actuated and adaptive signal controllers.
+const defaultDetectionDistance = 50.0
+
+// defaultDetectorLength is the length of road covered by a point detector
+// when no length is given.
+const defaultDetectorLength = 2.0
+
+// defaultDetectorInterval is the number of seconds detector data is
+// aggregated over when no interval is given.
+const defaultDetectorInterval = 60
+
+// stoppedSpeed is the speed below which a vehicle is counted as stopped
+// by the simulation's metrics.
+const stoppedSpeed = 0.1
+
+// defaultTimeHeadway is the time gap, in seconds, vehicles using the
+// Intelligent Driver Model keep to the vehicle infront.
+const defaultTimeHeadway = 1.5
+
+// defaultMinGap is the gap left to a stopped vehicle by the Intelligent
+// Driver Model and Gipps' model.
+const defaultMinGap = 2.0
+
+// defaultComfortableDeceleration is how hard vehicles using the
+// Intelligent Driver Model prefer to brake.
+const defaultComfortableDeceleration = 1.5
+
+// defaultAccelerationExponent controls how quickly vehicles using the
+// Intelligent Driver Model stop accelerating near their maximum speed.
+const defaultAccelerationExponent = 4.0
+
+// defaultReactionTime is the time, in seconds, vehicles using Gipps'
+// model take to react to the vehicle infront.
+const defaultReactionTime = 1.0
+
+// laneWidth is the distance between the centres of two lanes.
+const laneWidth = 3.5
+
+// defaultLaneChangeCooldown is the number of seconds a vehicle waits after
+// changing lane before it can change lane again.
+const defaultLaneChangeCooldown = 3.0
+
+// defaultLaneChangeThreshold is the acceleration a vehicle must gain
+// before it changes lane.
+const defaultLaneChangeThreshold = 0.1
+
+// defaultKerbsideBias is the extra acceleration a vehicle needs to move
+// away from the kerbside lane, and is given for moving back towards it.
+const defaultKerbsideBias = 0.2
+
+// defaultPoliteness is how much a vehicle cares about the vehicles it
+// slows down when changing lane, between 0 and 1.
+const defaultPoliteness = 0.2
+
+// defaultSafeDeceleration is the hardest a vehicle can make the vehicle
+// behind it in a new lane brake.
+const defaultSafeDeceleration = 4.0
+
+// defaultTurnLaneDistance is how far from a junction vehicles start
+// moving into the lane they need to turn.
+const defaultTurnLaneDistance = 100.0
+
+// turnThreshold is the sine of the smallest angle between two links that
+// counts as a turn.
+const turnThreshold = 0.5
+
+// sameDirection is the largest distance between the directions of two
+// vehicles, as unit vectors, for them to be travelling the same way.
+const sameDirection = 1e-6
+
+// defaultCrosswalkWidth is the width of a crosswalk along the road
+// if no width is given.
+const defaultCrosswalkWidth = 3.0
+
+// defaultWalkingSpeed is the speed, in metres per second, a pedestrian
+// walks at if no walking speed is given.
+const defaultWalkingSpeed = 1.4
+
+// defaultBusDeadTime is the number of seconds a bus spends at a stop
+// opening and closing its doors, however many passengers board.
+const defaultBusDeadTime = 5.0
+
+// defaultBoardingTime is the number of seconds each passenger takes to
+// board a bus.
+const defaultBoardingTime = 2.0
+
+// busStopRange is the furthest a bus stop can be from a bus line's route.
+const busStopRange = 10.0
+
+// defaultBunchingRatio is the fraction of the scheduled headway below
+// which a bus arriving at a stop counts as bunched with the bus before.
+const defaultBunchingRatio = 0.5
+
+// defaultEarlyDeparture is the most seconds a bus can leave a stop before
+// its scheduled time and still be on time.
+const defaultEarlyDeparture = 60.0
+
+// defaultLateDeparture is the most seconds a bus can leave a stop after
+// its scheduled time and still be on time.
+const defaultLateDeparture = 300.0
+
+// defaultCriticalGap is the shortest time, in seconds, before a vehicle with
+// priority reaches a junction that a vehicle giving way will accept.
+const defaultCriticalGap = 5.0
+
+// defaultJunctionDistance is how close to a junction vehicles start
+// following its priority rules.
+const defaultJunctionDistance = 50.0
+
+// defaultJunctionStopLine is how far before a junction's node vehicles
+// wait.
+const defaultJunctionStopLine = 3.0
+
+// defaultStopLineRange is how close to the stop line a stopped vehicle
+// must be to count as waiting at it.
+const defaultStopLineRange = 5.0
+
+// defaultJunctionClearance is how far past a junction's node a vehicle
+// must be before the next vehicle at an all-way stop can go.
+const defaultJunctionClearance = 10.0
+
+// defaultJunctionDeadlockWait is the number of seconds a vehicle waits
+// when every approach to a junction is giving way before the longest
+// waiting goes.
+const defaultJunctionDeadlockWait = 5.0
+
+// defaultVehicleLength is the length of a vehicle, in metres, when none
+// is given.
+const defaultVehicleLength = 4.5
+
+// defaultVehicleWidth is the width of a vehicle, in metres, when none is
+// given.
+const defaultVehicleWidth = 1.8
+
+// defaultBusLength & defaultBusWidth are the size, in metres, of the
+// buses on a bus line when none is given.
+const defaultBusLength = 12.0
+const defaultBusWidth = 2.5
+
+// defaultMinimumGap is the smallest bumper to bumper gap, in metres, a
+// vehicle leaves to the vehicle infront whatever its car-following model
+// decides.
+const defaultMinimumGap = 0.5
+
+// collisionStep is the longest distance, in metres, a vehicle moves
+// between the positions checked for collisions during a tick.
+const collisionStep = 1.0
+
+// gridCellSize is the width, in metres, of the squares the environment is
+// divided into to find the agents near a position.
+const gridCellSize = 50.0
+
+// actBatchSize is the number of agents a goroutine acts for at a time
+// during a tick.
+const actBatchSize = 256
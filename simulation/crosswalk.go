@@ -0,0 +1,123 @@
+package simulation
+
+import (
+	"errors"
+	"math"
+)
+
+// Crosswalk is a place where pedestrians cross a road. Vehicles must
+// give way to pedestrians on the crosswalk. A signalised crosswalk has a
+// light that pedestrians wait to show green before they start crossing.
+type Crosswalk struct {
+	// id is a unique integer used to identify the crosswalk.
+	id int
+	// start and end are the kerbs at each side of the road.
+	start Vector
+	end   Vector
+	// width is the width of the crosswalk along the road.
+	width float64
+	// light is the id of the light pedestrians obey, -1 if
+	// the crosswalk is not signalised.
+	light int
+	// pedestrians is the number of pedestrians on the
+	// crosswalk at the start of the tick.
+	pedestrians int
+}
+
+// NewCrosswalk returns a crosswalk between the two kerbs given. If the
+// width is 0 the default is used. The light should be -1 for a crosswalk
+// without signals.
+func NewCrosswalk(id int, start, end Vector, width float64, light int) (Crosswalk, error) {
+	if width == 0 {
+		width = defaultCrosswalkWidth
+	}
+	if width < 0 {
+		return Crosswalk{}, errors.New("crosswalk width can not be negative")
+	}
+	if start.Equals(end) {
+		return Crosswalk{}, errors.New("crosswalk must cross between two different points")
+	}
+	if light < 0 {
+		light = -1
+	}
+
+	return Crosswalk{id: id, start: start, end: end, width: width, light: light}, nil
+}
+
+// GetID returns the id of the crosswalk.
+func (c *Crosswalk) GetID() int {
+	return c.id
+}
+
+// GetStart returns the kerb the crosswalk starts at.
+func (c *Crosswalk) GetStart() Vector {
+	return c.start
+}
+
+// GetEnd returns the kerb the crosswalk ends at.
+func (c *Crosswalk) GetEnd() Vector {
+	return c.end
+}
+
+// GetWidth returns the width of the crosswalk.
+func (c *Crosswalk) GetWidth() float64 {
+	return c.width
+}
+
+// GetLight returns the id of the light pedestrians obey. If the
+// crosswalk is not signalised false is returned.
+func (c *Crosswalk) GetLight() (id int, signalised bool) {
+	return c.light, c.light >= 0
+}
+
+// GetPedestrians returns the number of pedestrians on the crosswalk.
+func (c *Crosswalk) GetPedestrians() int {
+	return c.pedestrians
+}
+
+// connects returns true if the crosswalk runs between the two
+// positions, in either direction, to within the margin given.
+func (c *Crosswalk) connects(from, to Vector, margin float64) bool {
+	return (from.InRange(c.start, margin) && to.InRange(c.end, margin)) ||
+		(from.InRange(c.end, margin) && to.InRange(c.start, margin))
+}
+
+// canCross returns true if pedestrians are allowed to start crossing.
+func (c *Crosswalk) canCross(lights []Light) bool {
+	if c.light < 0 {
+		return true
+	}
+	for _, l := range lights {
+		if l.id == c.light {
+			return l.state == GreenSignal
+		}
+	}
+	return true
+}
+
+// distanceAlong returns how far along the path from one position to
+// another the near edge of the crosswalk is. If the path does not cross
+// the crosswalk, or has already reached it, false is returned.
+func (c *Crosswalk) distanceAlong(from, to Vector) (float64, bool) {
+	// Find where the two segments meet
+	px, py := to.x-from.x, to.y-from.y
+	qx, qy := c.end.x-c.start.x, c.end.y-c.start.y
+	denominator := px*qy - py*qx
+	if denominator == 0 {
+		return 0, false
+	}
+
+	t := ((c.start.x-from.x)*qy - (c.start.y-from.y)*qx) / denominator
+	u := ((c.start.x-from.x)*py - (c.start.y-from.y)*px) / denominator
+	if u < 0 || u > 1 {
+		return 0, false
+	}
+
+	// Allow for the width of the crosswalk either side of the line
+	length := math.Sqrt(px*px + py*py)
+	distance := t*length - c.width/2
+	if distance <= 0 || distance > length {
+		return 0, false
+	}
+	return distance, true
+}
@@ -0,0 +1,343 @@
+package simulation
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// PoissonArrivals is the arrival process where vehicles arrive at random,
+// the number each tick following a Poisson distribution.
+const PoissonArrivals = "poisson"
+
+// UniformArrivals is the arrival process where vehicles arrive evenly
+// spaced at the rate of the flow.
+const UniformArrivals = "uniform"
+
+// ODFlow is an entry of an origin-destination matrix.
+type ODFlow struct {
+	// Origin is the name of the zone vehicles start from.
+	Origin string `json:"origin"`
+	// Destination is the name of the zone vehicles travel to.
+	Destination string `json:"destination"`
+	// Flow is the number of vehicles per hour.
+	Flow float64 `json:"flow"`
+}
+
+// DemandProfile scales the flows of a demand over time. Each factor is
+// used for Period seconds in turn, repeating once they have all been used.
+type DemandProfile struct {
+	// Period is the number of seconds each factor is used for.
+	Period int `json:"period"`
+	// Factors are the numbers the flows are multiplied by.
+	Factors []float64 `json:"factors"`
+}
+
+// DemandReport compares the vehicles a demand generated with its matrix.
+type DemandReport struct {
+	// Demand is the id of the demand.
+	Demand int `json:"demand"`
+	// Ticks is the number of ticks the demand has been running.
+	Ticks int `json:"ticks"`
+	// Time is the number of seconds the demand has been running.
+	Time float64 `json:"time"`
+	// Flows stores the report for each entry of the matrix.
+	Flows []ODFlowReport `json:"flows"`
+}
+
+// ODFlowReport compares the vehicles generated for one entry of an
+// origin-destination matrix with the flow asked for.
+type ODFlowReport struct {
+	// Origin is the name of the zone vehicles start from.
+	Origin string `json:"origin"`
+	// Destination is the name of the zone vehicles travel to.
+	Destination string `json:"destination"`
+	// Flow is the number of vehicles per hour in the matrix.
+	Flow float64 `json:"flow"`
+	// Expected is the number of vehicles the matrix and profile
+	// expect to have arrived so far.
+	Expected float64 `json:"expected"`
+	// Generated is the number of vehicles added to the simulation.
+	Generated int `json:"generated"`
+	// Unrouted is the number of vehicles that could not be added as
+	// no route was found between the nodes chosen.
+	Unrouted int `json:"unrouted"`
+	// Arrived is the number of vehicles that reached their
+	// destination.
+	Arrived int `json:"arrived"`
+	// ExpectedFlow is the number of vehicles per hour the matrix
+	// and profile ask for on average.
+	ExpectedFlow float64 `json:"expectedFlow"`
+	// RealisedFlow is the number of vehicles per hour generated.
+	RealisedFlow float64 `json:"realisedFlow"`
+}
+
+// Demand generates vehicles travelling between the zones of an
+// origin-destination matrix.
+type Demand struct {
+	// id is a unique integer used to identify the demand.
+	id int
+	// zones stores the nodes vehicles start and end at, by
+	// zone name.
+	zones map[string][]Vector
+	// flows stores the entries of the matrix.
+	flows []ODFlow
+	// process is the arrival process used.
+	process string
+	// profile scales the flows over time.
+	profile DemandProfile
+	// routeType is the type of route vehicles take.
+	routeType string
+	// vehicle is the vehicle each generated vehicle copies its
+	// speeds, model and size from.
+	vehicle Vehicle
+	// start is the tick the demand was added.
+	start int
+	// pending is the fraction of a vehicle waiting to arrive
+	// for each flow when arrivals are uniform.
+	pending []float64
+	// expected is the number of vehicles expected so far for
+	// each flow.
+	expected []float64
+	// generated is the number of vehicles added for each flow.
+	generated []int
+	// unrouted is the number of vehicles without a route for
+	// each flow.
+	unrouted []int
+	// arrived is the number of vehicles that reached their
+	// destination for each flow.
+	arrived []int
+	// trips maps the ids of the vehicles on the road to the
+	// flow they were generated for.
+	trips map[int]int
+}
+
+// NewDemand returns a demand that generates vehicles for each of the flows
+// between the zones given, using the arrival process given. An empty
+// profile keeps the flows constant. Vehicles copy their speeds, model and
+// size from the vehicle given.
+func NewDemand(id int, zones map[string][]Vector, flows []ODFlow, process string, profile DemandProfile, routeType string, vehicle Vehicle, start int) (Demand, error) {
+	d := Demand{
+		id:        id,
+		zones:     zones,
+		flows:     flows,
+		process:   process,
+		profile:   profile,
+		routeType: routeType,
+		vehicle:   vehicle,
+		start:     start,
+		pending:   make([]float64, len(flows)),
+		expected:  make([]float64, len(flows)),
+		generated: make([]int, len(flows)),
+		unrouted:  make([]int, len(flows)),
+		arrived:   make([]int, len(flows)),
+		trips:     make(map[int]int)}
+
+	switch process {
+	case "":
+		d.process = PoissonArrivals
+	case PoissonArrivals, UniformArrivals:
+	default:
+		return d, fmt.Errorf("unknown arrival process: %v", process)
+	}
+
+	if len(profile.Factors) > 0 && profile.Period <= 0 {
+		return d, errors.New("a demand profile needs a period of at least 1 second")
+	}
+	for _, factor := range profile.Factors {
+		if factor < 0 {
+			return d, fmt.Errorf("demand profile factors can not be negative: %v", factor)
+		}
+	}
+
+	for _, f := range flows {
+		if f.Flow < 0 {
+			return d, fmt.Errorf("flow from %v to %v can not be negative", f.Origin, f.Destination)
+		}
+		for _, zone := range []string{f.Origin, f.Destination} {
+			if len(zones[zone]) == 0 {
+				return d, fmt.Errorf("no nodes found in zone: %v", zone)
+			}
+		}
+	}
+
+	return d, nil
+}
+
+// GetID returns the id of the demand.
+func (d *Demand) GetID() int {
+	return d.id
+}
+
+// GetFlows returns the entries of the demand's matrix.
+func (d *Demand) GetFlows() []ODFlow {
+	return d.flows
+}
+
+// GetProcess returns the arrival process used by the demand.
+func (d *Demand) GetProcess() string {
+	return d.process
+}
+
+// factor returns the number the flows are multiplied by at the given tick,
+// with each tick lasting the given number of seconds.
+func (d *Demand) factor(tick int, timeStep float64) float64 {
+	if len(d.profile.Factors) == 0 {
+		return 1
+	}
+	// The first tick after the demand was added uses the first factor,
+	// each tick uses the factor in use at its start
+	elapsed := float64(tick-d.start-1) * timeStep
+	period := int(math.Floor(elapsed/float64(d.profile.Period) + 1e-9))
+	return d.profile.Factors[period%len(d.profile.Factors)]
+}
+
+// arrivals returns the number of vehicles that arrive for the flow with
+// the given index this tick, which lasts the given number of seconds.
+func (d *Demand) arrivals(i int, tick int, timeStep float64, rng *rand.Rand) int {
+	// Flows are in vehicles per hour
+	rate := d.flows[i].Flow * d.factor(tick, timeStep) * timeStep / 3600
+	d.expected[i] += rate
+
+	if d.process == UniformArrivals {
+		d.pending[i] += rate
+		// Allow for rounding errors building up over many ticks
+		n := math.Floor(d.pending[i] + 1e-9)
+		d.pending[i] -= n
+		return int(n)
+	}
+	return poisson(rate, rng)
+}
+
+// poisson returns a number drawn from a Poisson distribution with the
+// given mean.
+func poisson(mean float64, rng *rand.Rand) int {
+	if mean <= 0 {
+		return 0
+	}
+	limit := math.Exp(-mean)
+	n := 0
+	for p := rng.Float64(); p > limit; p *= rng.Float64() {
+		n++
+	}
+	return n
+}
+
+// generate returns the vehicles arriving this tick, routed between a
+// random node of their origin zone and a random node of their
+// destination zone, along with the index of the flow each is for.
+func (d *Demand) generate(tick int, env Environment, rng *rand.Rand) (vehicles []Vehicle, flows []int) {
+	for i, f := range d.flows {
+		for n := d.arrivals(i, tick, env.GetTimeStep(), rng); n > 0; n-- {
+			origins := d.zones[f.Origin]
+			destinations := d.zones[f.Destination]
+			origin := origins[rng.Intn(len(origins))]
+			destination := destinations[rng.Intn(len(destinations))]
+
+			route, err := env.FindRoute(origin, destination, nil, d.routeType)
+			if err != nil || len(route) < 2 {
+				d.unrouted[i]++
+				continue
+			}
+
+			template := d.vehicle
+			v := NewVehicle(
+				-1,
+				route[0],
+				0,
+				template.maxSpeed,
+				template.acceleration,
+				template.deceleration,
+				route[1:],
+				0).SetModel(template.getModel()).SetSize(template.length, template.width)
+			d.generated[i]++
+			vehicles = append(vehicles, v)
+			flows = append(flows, i)
+		}
+	}
+	return
+}
+
+// Report compares the vehicles generated for each flow with the matrix,
+// given the current tick and the number of seconds each tick lasts.
+func (d *Demand) Report(tick int, timeStep float64) DemandReport {
+	report := DemandReport{Demand: d.id, Ticks: tick - d.start}
+	report.Time = float64(report.Ticks) * timeStep
+	for i, f := range d.flows {
+		flow := ODFlowReport{
+			Origin:      f.Origin,
+			Destination: f.Destination,
+			Flow:        f.Flow,
+			Expected:    d.expected[i],
+			Generated:   d.generated[i],
+			Unrouted:    d.unrouted[i],
+			Arrived:     d.arrived[i]}
+		if report.Ticks > 0 {
+			hours := report.Time / 3600
+			flow.ExpectedFlow = d.expected[i] / hours
+			flow.RealisedFlow = float64(d.generated[i]) / hours
+		}
+		report.Flows = append(report.Flows, flow)
+	}
+	return report
+}
+
+// AddDemand adds an origin-destination matrix to the simulation. The zones
+// map a name to the nodes in the zone, a node can be used on its own by
+// giving it a zone of its own. Vehicles start arriving from the next tick.
+func (s *Simulation) AddDemand(zones map[string][]Vector, flows []ODFlow, process string, profile DemandProfile, routeType string, vehicle Vehicle) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for name, nodes := range zones {
+		for _, pos := range nodes {
+			if _, found := s.environment.GetNodeAt(pos); !found {
+				return fmt.Errorf("no node found at %v in zone %v", pos, name)
+			}
+		}
+	}
+
+	demand, err := NewDemand(len(s.demands), zones, flows, process, profile, routeType, vehicle, s.currentTick)
+	if err != nil {
+		return err
+	}
+	s.demands = append(s.demands, demand)
+	return nil
+}
+
+// GetDemandReport returns the report of the demand with the given id.
+func (s *Simulation) GetDemandReport(id int) (DemandReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id < 0 || id >= len(s.demands) {
+		return DemandReport{}, fmt.Errorf("no demand found with the id: %v", id)
+	}
+	return s.demands[id].Report(s.currentTick, s.environment.GetTimeStep()), nil
+}
+
+// generateDemand adds the vehicles arriving from each demand.
+func (s *Simulation) generateDemand() {
+	for i := range s.demands {
+		d := &s.demands[i]
+		vehicles, flows := d.generate(s.currentTick, s.environment, s.rng)
+		for j, v := range vehicles {
+			s.addAgent(v)
+			d.trips[s.currentAgentID] = flows[j]
+		}
+	}
+}
+
+// demandArrived records a vehicle generated by a demand reaching its
+// destination.
+func (s *Simulation) demandArrived(id int) {
+	for i := range s.demands {
+		d := &s.demands[i]
+		if flow, found := d.trips[id]; found {
+			d.arrived[flow]++
+			delete(d.trips, id)
+			return
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package simulation
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// demandScenario is a two-way road between zones w and e, with a node in
+// zone x that can not be reached. %v is replaced with the demand's process
+// and profile.
+const demandScenario = `{
+  "environment": {"nodes": [[0,0],[500,0],[0,1000]],
+    "links": [{"from":0,"to":1},{"from":1,"to":0}]},
+  "seed": 3,
+  "demands": [{"zones":{"w":[[0,0]],"e":[[500,0]],"x":[[0,1000]]},
+    "flows":[{"origin":"w","destination":"e","flow":720},{"origin":"e","destination":"w","flow":360},{"origin":"w","destination":"x","flow":180}],
+    %v, "maxSpeed":13,"acceleration":2,"deceleration":4}]
+}`
+
+// runDemand runs the demandScenario with the process and profile given,
+// returning the demand's report after 600 ticks and after an hour.
+func runDemand(t *testing.T, process string) (early DemandReport, report DemandReport) {
+	sc, err := ReadScenario(strings.NewReader(fmt.Sprintf(demandScenario, process)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewSimulationFromScenario(sc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.RunSteps(600)
+	if early, err = s.GetDemandReport(0); err != nil {
+		t.Fatal(err)
+	}
+	s.RunSteps(3000)
+	if report, err = s.GetDemandReport(0); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+// TestDemandFlows checks each arrival process generates the flows of the
+// matrix, scaled by the profile, and the report gives the flows realised.
+func TestDemandFlows(t *testing.T) {
+	processes := []struct {
+		process string
+		// factor is what the profile scales the flows by over
+		// the first 600 seconds.
+		factor float64
+	}{
+		{`"process":"poisson"`, 1},
+		{`"process":"uniform"`, 1},
+		{`"process":"poisson","profile":{"period":600,"factors":[0.5,1.5]}`, 0.5},
+		{`"process":"uniform","profile":{"period":600,"factors":[0.5,1.5]}`, 0.5},
+	}
+	for _, p := range processes {
+		early, report := runDemand(t, p.process)
+
+		// A seeded run is repeated exactly
+		if _, again := runDemand(t, p.process); !reflect.DeepEqual(report, again) {
+			t.Fatalf("%v: report %+v, then %+v with the same seed", p.process, report, again)
+		}
+
+		if report.Ticks != 3600 || report.Time != 3600 {
+			t.Fatalf("%v: report after %v ticks and %v seconds, want 3600", p.process, report.Ticks, report.Time)
+		}
+		for i, f := range report.Flows {
+			want := early.Flows[i].Flow * p.factor / 6
+			if math.Abs(early.Flows[i].Expected-want) > 1e-6 {
+				t.Fatalf("%v: %v expected %v vehicles after 600 seconds, want %v", p.process, f, early.Flows[i].Expected, want)
+			}
+
+			// Over an hour the profile averages to 1
+			if math.Abs(f.Expected-f.Flow) > 1e-6 || math.Abs(f.ExpectedFlow-f.Flow) > 1e-6 {
+				t.Fatalf("%v: %+v expected %v vehicles, want %v", p.process, f, f.Expected, f.Flow)
+			}
+
+			added := float64(f.Generated + f.Unrouted)
+			if strings.Contains(p.process, "uniform") {
+				if math.Abs(added-math.Floor(f.Expected+1e-9)) > 0 {
+					t.Fatalf("%v: %+v added %v vehicles, want %v", p.process, f, added, math.Floor(f.Expected))
+				}
+			} else if math.Abs(added-f.Expected) > 4*math.Sqrt(f.Expected) {
+				t.Fatalf("%v: %+v added %v vehicles, want about %v", p.process, f, added, f.Expected)
+			}
+
+			if f.Destination == "x" {
+				if f.Generated != 0 || f.Unrouted == 0 {
+					t.Fatalf("%v: %+v generated vehicles to a zone that can not be reached", p.process, f)
+				}
+			} else if f.Unrouted != 0 || f.Arrived == 0 || f.Arrived > f.Generated {
+				t.Fatalf("%v: %+v, want every vehicle routed and some arrived", p.process, f)
+			}
+			if f.RealisedFlow != float64(f.Generated) {
+				t.Fatalf("%v: %+v realised flow %v, want %v", p.process, f, f.RealisedFlow, f.Generated)
+			}
+		}
+	}
+}
@@ -0,0 +1,411 @@
+package simulation
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// PointDetector is the detector type that acts like an inductive loop,
+// recording the vehicles that pass over a short length of road.
+const PointDetector = "point"
+
+// AreaDetector is the detector type that records the vehicles inside
+// a rectangular area.
+const AreaDetector = "area"
+
+// DetectorRecord stores what a detector measured during one tick.
+type DetectorRecord struct {
+	// Tick is the tick the measurements were taken at.
+	Tick int `json:"tick"`
+	// Time is the number of seconds simulated by the end of the tick.
+	Time float64 `json:"time"`
+	// Count is the number of vehicles that entered the detector.
+	Count int `json:"count"`
+	// Present is the number of vehicles on the detector at the end
+	// of the tick.
+	Present int `json:"present"`
+	// Occupied is true if a vehicle was on the detector during the tick.
+	Occupied bool `json:"occupied"`
+	// Speeds are the spot speeds of the vehicles entering a point
+	// detector, or the speeds of all the vehicles inside an area detector.
+	Speeds []float64 `json:"speeds"`
+	// Headways are the number of seconds between each vehicle entering
+	// the detector and the vehicle before it.
+	Headways []float64 `json:"headways"`
+}
+
+// DetectorInterval stores the measurements of a detector aggregated over
+// a number of ticks.
+type DetectorInterval struct {
+	// Start is the first tick in the interval.
+	Start int `json:"start"`
+	// End is the last tick in the interval.
+	End int `json:"end"`
+	// StartTime and EndTime are the number of seconds simulated by
+	// the end of the first and last ticks in the interval.
+	StartTime float64 `json:"startTime"`
+	EndTime   float64 `json:"endTime"`
+	// Count is the number of vehicles that entered the detector.
+	Count int `json:"count"`
+	// Flow is the count converted into vehicles per hour.
+	Flow float64 `json:"flow"`
+	// Occupancy is the fraction of ticks a vehicle was on the detector.
+	Occupancy float64 `json:"occupancy"`
+	// MeanPresent is the average number of vehicles on the detector.
+	MeanPresent float64 `json:"meanPresent"`
+	// MeanSpeed is the average of the speeds measured, 0 if none were.
+	MeanSpeed float64 `json:"meanSpeed"`
+	// MeanHeadway is the average of the headways measured, 0 if none were.
+	MeanHeadway float64 `json:"meanHeadway"`
+}
+
+// Detector is a virtual sensor placed in the environment that records
+// the agents that pass it.
+type Detector struct {
+	// id is a unique integer used to identify the detector.
+	id int
+	// kind is the type of detector, either PointDetector or AreaDetector.
+	kind string
+	// position is the centre of a point detector.
+	position Vector
+	// length is the length of road covered by a point detector.
+	length float64
+	// min and max are the opposite corners of an area detector.
+	min Vector
+	max Vector
+	// interval is the number of seconds the records are aggregated
+	// over.
+	interval int
+	// timeStep is the number of seconds each tick recorded lasted.
+	timeStep float64
+	// occupying stores the ids of the agents on the detector
+	// at the end of the last tick.
+	occupying map[int]bool
+	// lastEntry is the tick the last vehicle entered the detector,
+	// -1 if no vehicle has.
+	lastEntry int
+	// records store the measurements taken at each tick.
+	records []DetectorRecord
+
+	// Logger is used to give a context based log to the stdout
+	Logger *log.Entry
+}
+
+// NewPointDetector returns a detector covering a length of road centred on
+// the given position. If the length or interval are 0 the defaults are used.
+func NewPointDetector(id int, pos Vector, length float64, interval int) (Detector, error) {
+	if length == 0 {
+		length = defaultDetectorLength
+	}
+	if length < 0 {
+		return Detector{}, errors.New("detector length can not be negative")
+	}
+
+	d, err := newDetector(id, PointDetector, interval)
+	d.position = pos
+	d.length = length
+	return d, err
+}
+
+// NewAreaDetector returns a detector covering the rectangle between the two
+// corners given. If the interval is 0 the default is used.
+func NewAreaDetector(id int, corner Vector, opposite Vector, interval int) (Detector, error) {
+	d, err := newDetector(id, AreaDetector, interval)
+	d.min = NewVector(math.Min(corner.x, opposite.x), math.Min(corner.y, opposite.y))
+	d.max = NewVector(math.Max(corner.x, opposite.x), math.Max(corner.y, opposite.y))
+	d.position = NewVector((d.min.x+d.max.x)/2, (d.min.y+d.max.y)/2)
+	return d, err
+}
+
+// newDetector sets up the values shared by all the types of detector.
+func newDetector(id int, kind string, interval int) (Detector, error) {
+	var d Detector
+
+	if interval == 0 {
+		interval = defaultDetectorInterval
+	}
+	if interval < 0 {
+		return d, errors.New("detector interval can not be negative")
+	}
+
+	d.id = id
+	d.kind = kind
+	d.interval = interval
+	d.occupying = make(map[int]bool)
+	d.lastEntry = -1
+
+	// Setup the logger
+	d.Logger = log.WithFields(log.Fields{
+		"package": "simulation",
+		"section": "Detector",
+		"id":      d.id})
+
+	return d, nil
+}
+
+// GetID returns the id of the detector.
+func (d *Detector) GetID() int {
+	return d.id
+}
+
+// GetType returns the type of detector.
+func (d *Detector) GetType() string {
+	return d.kind
+}
+
+// GetPosition returns the centre of the detector.
+func (d *Detector) GetPosition() Vector {
+	return d.position
+}
+
+// GetLength returns the length of road covered by a point detector.
+func (d *Detector) GetLength() float64 {
+	return d.length
+}
+
+// GetArea returns the corners of an area detector.
+func (d *Detector) GetArea() (min Vector, max Vector) {
+	return d.min, d.max
+}
+
+// GetInterval returns the number of seconds the records are aggregated
+// over.
+func (d *Detector) GetInterval() int {
+	return d.interval
+}
+
+// GetRecords returns the measurements taken at each tick.
+func (d *Detector) GetRecords() []DetectorRecord {
+	return d.records
+}
+
+// covers returns true if an agent that moved from the previous position
+// to the current position was on the detector during the tick.
+func (d *Detector) covers(previous, current Vector) bool {
+	if d.kind == AreaDetector {
+		return d.inside(current) || d.inside(previous)
+	}
+	return d.position.DistanceToSegment(previous, current) <= d.length/2
+}
+
+// inside returns true if the position is on the detector.
+func (d *Detector) inside(pos Vector) bool {
+	if d.kind == AreaDetector {
+		return pos.x >= d.min.x && pos.x <= d.max.x && pos.y >= d.min.y && pos.y <= d.max.y
+	}
+	return pos.InRange(d.position, d.length/2)
+}
+
+// Update records the agents that were on the detector during the tick.
+// The previous positions map agent ids to where they were at the start of
+// the tick, agents without a previous position have just been added.
+// Detectors only sense vehicles, so pedestrians are ignored. Each tick
+// lasts the given number of seconds.
+func (d *Detector) Update(tick int, timeStep float64, previous map[int]Vector, agents []Agent) {
+	d.timeStep = timeStep
+	record := DetectorRecord{Tick: tick, Time: float64(tick) * timeStep}
+	occupying := make(map[int]bool)
+
+	for _, agent := range agents {
+		if _, walking := agent.(Pedestrian); walking {
+			continue
+		}
+		current := agent.GetPosition()
+		start, found := previous[agent.GetID()]
+		if !found {
+			start = current
+		}
+
+		if !d.covers(start, current) {
+			continue
+		}
+		record.Occupied = true
+
+		// Count vehicles the first tick they are seen on the detector
+		if !d.occupying[agent.GetID()] {
+			record.Count++
+			if d.lastEntry >= 0 {
+				record.Headways = append(record.Headways, float64(tick-d.lastEntry)*timeStep)
+			}
+			d.lastEntry = tick
+
+			if d.kind == PointDetector {
+				record.Speeds = append(record.Speeds, agent.GetSpeed())
+			}
+		}
+
+		if d.inside(current) {
+			occupying[agent.GetID()] = true
+			record.Present++
+
+			if d.kind == AreaDetector {
+				record.Speeds = append(record.Speeds, agent.GetSpeed())
+			}
+		}
+	}
+
+	d.occupying = occupying
+	d.records = append(d.records, record)
+}
+
+// GetIntervals aggregates the records into intervals of the
+// detector's interval length.
+func (d *Detector) GetIntervals() []DetectorInterval {
+	var intervals []DetectorInterval
+
+	// The number of records in each interval
+	length := ticks(float64(d.interval), d.getTimeStep())
+	if length < 1 {
+		length = 1
+	}
+
+	for start := 0; start < len(d.records); start += length {
+		end := start + length
+		if end > len(d.records) {
+			end = len(d.records)
+		}
+
+		var interval DetectorInterval
+		var occupied, present, speeds, headways int
+		var speedSum, headwaySum float64
+		interval.Start = d.records[start].Tick
+		interval.End = d.records[end-1].Tick
+		interval.StartTime = d.records[start].Time
+		interval.EndTime = d.records[end-1].Time
+
+		for _, record := range d.records[start:end] {
+			interval.Count += record.Count
+			present += record.Present
+			if record.Occupied {
+				occupied++
+			}
+			for _, speed := range record.Speeds {
+				speedSum += speed
+				speeds++
+			}
+			for _, headway := range record.Headways {
+				headwaySum += headway
+				headways++
+			}
+		}
+
+		ticks := float64(end - start)
+		interval.Flow = float64(interval.Count) * 3600 / (ticks * d.getTimeStep())
+		interval.Occupancy = float64(occupied) / ticks
+		interval.MeanPresent = float64(present) / ticks
+		if speeds > 0 {
+			interval.MeanSpeed = speedSum / float64(speeds)
+		}
+		if headways > 0 {
+			interval.MeanHeadway = headwaySum / float64(headways)
+		}
+
+		intervals = append(intervals, interval)
+	}
+	return intervals
+}
+
+// getTimeStep returns the number of seconds each tick recorded lasted.
+func (d *Detector) getTimeStep() float64 {
+	if d.timeStep <= 0 {
+		return defaultTimeStep
+	}
+	return d.timeStep
+}
+
+// GetInfo returns a json string containing the detector's records and
+// the intervals they are aggregated into.
+func (d *Detector) GetInfo() string {
+	type detectorInfo struct {
+		ID        int                `json:"id"`
+		Type      string             `json:"type"`
+		Position  []float64          `json:"position"`
+		Interval  int                `json:"interval"`
+		Records   []DetectorRecord   `json:"records"`
+		Intervals []DetectorInterval `json:"intervals"`
+	}
+
+	type response struct {
+		Success bool         `json:"success"`
+		Error   string       `json:"error"`
+		Info    detectorInfo `json:"info"`
+	}
+
+	var resp response
+	resp.Success = true
+	resp.Info = detectorInfo{
+		ID:        d.id,
+		Type:      d.kind,
+		Position:  d.position.ConvertToSlice(),
+		Interval:  d.interval,
+		Records:   d.records,
+		Intervals: d.GetIntervals()}
+
+	jsonStr, _ := json.Marshal(resp)
+	return string(jsonStr)
+}
+
+// ExportCSV writes the detector's data to w as CSV. If intervals is true
+// the aggregated intervals are written, otherwise a row is written for
+// each tick.
+func (d *Detector) ExportCSV(w io.Writer, intervals bool) error {
+	out := csv.NewWriter(w)
+	format := func(f float64) string {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+
+	if intervals {
+		out.Write([]string{"start", "end", "startTime", "endTime", "count", "flow", "occupancy", "meanPresent", "meanSpeed", "meanHeadway"})
+		for _, i := range d.GetIntervals() {
+			out.Write([]string{
+				strconv.Itoa(i.Start),
+				strconv.Itoa(i.End),
+				format(i.StartTime),
+				format(i.EndTime),
+				strconv.Itoa(i.Count),
+				format(i.Flow),
+				format(i.Occupancy),
+				format(i.MeanPresent),
+				format(i.MeanSpeed),
+				format(i.MeanHeadway)})
+		}
+	} else {
+		out.Write([]string{"tick", "time", "count", "present", "occupied", "speeds", "headways"})
+		for _, r := range d.records {
+			out.Write([]string{
+				strconv.Itoa(r.Tick),
+				format(r.Time),
+				strconv.Itoa(r.Count),
+				strconv.Itoa(r.Present),
+				strconv.FormatBool(r.Occupied),
+				joinFloats(r.Speeds, format),
+				joinFloats(r.Headways, format)})
+		}
+	}
+
+	out.Flush()
+	if err := out.Error(); err != nil {
+		return fmt.Errorf("unable to export detector %v: %v", d.id, err)
+	}
+	return nil
+}
+
+// joinFloats converts a list of numbers into a single space
+// separated string.
+func joinFloats(values []float64, format func(float64) string) string {
+	s := ""
+	for i, v := range values {
+		if i > 0 {
+			s += " "
+		}
+		s += format(v)
+	}
+	return s
+}
@@ -1,6 +1,9 @@
 package simulation
 
 import (
+	"fmt"
+	"math"
+	"strconv"
 	"strings"
 
 	shp "github.com/jonas-p/go-shp"
@@ -9,10 +12,47 @@ import (
 
 // Environment models the road network for the traffic simulation.
 type Environment struct {
-	// waypoints store the locations of the road network
-	waypoints []Vector
+	// nodes store the points where links in the road network meet
+	nodes []Node
+	// links store the directed sections of road between nodes
+	links []Link
+	// nodeIndex maps a position to the id of the node at that position
+	nodeIndex map[Vector]int
+	// outgoing stores the ids of the links leaving each node, indexed
+	// by node id
+	outgoing [][]int
+	// incoming stores the number of links entering each node, indexed
+	// by node id
+	incoming []int
 	// lights store the traffic lights in the environment
 	lights []Light
+	// lightIndex maps a position to the index of the light at that
+	// position
+	lightIndex map[Vector]int
+	// signals store the controllers that change the state of the lights
+	signals []SignalController
+	// detectors store the virtual sensors placed in the environment
+	detectors []Detector
+	// crosswalks store the places pedestrians cross the roads
+	crosswalks []Crosswalk
+	// busStops store the places buses stop to pick up passengers
+	busStops []BusStop
+	// junctions store the priority rules used at nodes without signals
+	junctions []Junction
+	// junctionIndex maps a position to the index of the junction at
+	// that position
+	junctionIndex map[Vector]int
+	// defaultJunction is the control used at nodes where roads meet that
+	// have no signals or junction of their own, empty for none
+	defaultJunction string
+	// timeStep is the number of seconds each tick simulates
+	timeStep float64
+	// parameters control how the agents behave, nil uses the
+	// DefaultParameters
+	parameters *Parameters
+	// traffic indexes the agents of the simulation the environment is
+	// part of, nil if it is not part of one
+	traffic *trafficIndex
 
 	// Logger is used to give a context based log to the stdout
 	Logger *log.Entry
@@ -22,6 +62,11 @@ type Environment struct {
 func NewEnvironment() Environment {
 	var env Environment
 
+	env.nodeIndex = make(map[Vector]int)
+	env.lightIndex = make(map[Vector]int)
+	env.junctionIndex = make(map[Vector]int)
+	env.timeStep = defaultTimeStep
+
 	// Setup the logger
 	env.Logger = log.WithFields(log.Fields{
 		"package": "simulation",
@@ -30,16 +75,140 @@ func NewEnvironment() Environment {
 	return env
 }
 
-// GetWaypoints returns the waypoints from that environment.
+// GetTimeStep returns the number of seconds each tick simulates.
+func (e *Environment) GetTimeStep() float64 {
+	if e.timeStep <= 0 {
+		return defaultTimeStep
+	}
+	return e.timeStep
+}
+
+// GetParameters returns the parameters that control how the agents in
+// the environment behave.
+func (e *Environment) GetParameters() Parameters {
+	if e.parameters == nil {
+		return DefaultParameters()
+	}
+	return *e.parameters
+}
+
+// trafficFor returns the index of the simulation's agents, indexing the
+// agents given if the environment is not part of a simulation.
+func (e *Environment) trafficFor(agents []Agent) *trafficIndex {
+	if e.traffic != nil {
+		return e.traffic
+	}
+	return newTrafficIndex(agents)
+}
+
+// GetWaypoints returns the positions of the nodes in the road network.
 func (e *Environment) GetWaypoints() []Vector {
-	return e.waypoints
+	var waypoints []Vector
+	for _, n := range e.nodes {
+		waypoints = append(waypoints, n.GetPosition())
+	}
+	return waypoints
+}
+
+// GetNodes returns the nodes in the road network.
+func (e *Environment) GetNodes() []Node {
+	return e.nodes
+}
+
+// GetLinks returns the links in the road network.
+func (e *Environment) GetLinks() []Link {
+	return e.links
+}
+
+// GetNodeAt returns the node at a given position. If no node
+// is found false is returned.
+func (e *Environment) GetNodeAt(pos Vector) (node Node, found bool) {
+	id, found := e.nodeIndex[pos]
+	if !found {
+		return node, false
+	}
+	return e.nodes[id], true
+}
+
+// GetOutgoingLinks returns the links that start at the given node.
+func (e *Environment) GetOutgoingLinks(nodeID int) []Link {
+	var links []Link
+	if nodeID < 0 || nodeID >= len(e.outgoing) {
+		return links
+	}
+	for _, id := range e.outgoing[nodeID] {
+		links = append(links, e.links[id])
+	}
+	return links
+}
+
+// GetLinkBetween returns the link that starts at the position from and ends
+// at the position to. If there is no such link false is returned.
+func (e *Environment) GetLinkBetween(from, to Vector) (link Link, found bool) {
+	start, ok := e.GetNodeAt(from)
+	if !ok {
+		return link, false
+	}
+	end, ok := e.GetNodeAt(to)
+	if !ok {
+		return link, false
+	}
+	for _, id := range e.outgoing[start.id] {
+		if e.links[id].to == end.id {
+			return e.links[id], true
+		}
+	}
+	return link, false
+}
+
+// addNode returns the node at the given position, creating a new
+// node if one does not exist yet.
+func (e *Environment) addNode(pos Vector) Node {
+	if node, found := e.GetNodeAt(pos); found {
+		return node
+	}
+	if e.nodeIndex == nil {
+		e.nodeIndex = make(map[Vector]int)
+	}
+
+	node := NewNode(len(e.nodes), pos)
+	e.nodes = append(e.nodes, node)
+	e.nodeIndex[pos] = node.id
+	e.outgoing = append(e.outgoing, nil)
+	e.incoming = append(e.incoming, 0)
+	return node
+}
+
+// addLink creates a directed link between two nodes and adds it to the
+// road network.
+func (e *Environment) addLink(from, to Node, oneway bool, lanes int, speedLimit float64, attributes map[string]string) Link {
+	link := NewLink(len(e.links), from, to, oneway, lanes, speedLimit, attributes)
+	e.links = append(e.links, link)
+	e.outgoing[from.id] = append(e.outgoing[from.id], link.id)
+	e.incoming[to.id]++
+	return link
 }
 
 // ReadShapefile takes a shape file and sets up the environment.
-func (e *Environment) ReadShapefile(fileName string) {
+// POLYLINE and POLYLINEZ features are split into directed links between
+// each of their vertices, vertices shared between features become the same
+// node. The attribute table is checked for the following fields:
+//
+//	oneway   - "yes", "true", "1" or "FT" for one way in the digitised
+//	           direction, "-1", "reverse" or "TF" for the opposite direction
+//	lanes    - the number of lanes in each direction of travel
+//	maxspeed - the speed limit of the road
+//	detector - "point" to place a point detector at the end of the feature
+//	           or "area" to place an area detector over the feature
+//
+// POINT features with a "waypoint" attribute are added as unconnected
+// nodes so older environment files can still be used, as are other features
+// with a "waypoint" attribute, at the top right corner of their bounding box.
+// Null features and polylines without any points are skipped.
+func (e *Environment) ReadShapefile(fileName string) error {
 	shape, err := shp.Open(fileName)
 	if err != nil {
-		e.Logger.Fatal(err)
+		return err
 	}
 	defer shape.Close()
 
@@ -50,21 +219,138 @@ func (e *Environment) ReadShapefile(fileName string) {
 	for shape.Next() {
 		n, p := shape.Shape()
 
+		// Read the feature's attributes
+		attributes := make(map[string]string)
 		for k := range fields {
-			val := shape.ReadAttribute(n, k)
+			name := strings.ToLower(strings.Trim(fields[k].String(), "\x00 "))
+			attributes[name] = strings.Trim(shape.ReadAttribute(n, k), "\x00 ")
+		}
+
+		// end is where a point detector on the feature is placed
+		var end Vector
+		switch geometry := p.(type) {
+		case *shp.Null:
+			// Null records have no geometry to add
+			continue
+		case *shp.PolyLine:
+			if len(geometry.Points) == 0 {
+				continue
+			}
+			e.addPolyLine(geometry.Parts, geometry.Points, attributes)
+			end = NewVector(geometry.Points[len(geometry.Points)-1].X, geometry.Points[len(geometry.Points)-1].Y)
+		case *shp.PolyLineZ:
+			if len(geometry.Points) == 0 {
+				continue
+			}
+			e.addPolyLine(geometry.Parts, geometry.Points, attributes)
+			end = NewVector(geometry.Points[len(geometry.Points)-1].X, geometry.Points[len(geometry.Points)-1].Y)
+		case *shp.Point:
+			end = NewVector(geometry.X, geometry.Y)
+			for _, val := range attributes {
+				// Add the waypoints to the environment
+				if strings.Contains(val, "waypoint") {
+					e.addNode(end)
+					break
+				}
+				// Points can also mark where detectors are
+				if val == "detector" {
+					attributes["detector"] = PointDetector
+				}
+			}
+		default:
+			// Other features are only used for their waypoints, which
+			// are placed at the corner of their bounding box
+			box := p.BBox()
+			end = NewVector(box.MaxX, box.MaxY)
+			for _, val := range attributes {
+				if strings.Contains(val, "waypoint") {
+					e.addNode(end)
+					break
+				}
+			}
+		}
 
-			// Add the waypoints to the environment
-			if strings.Contains(val, "waypoint") {
-				e.waypoints = append(e.waypoints, Vector{x: p.BBox().MaxX, y: p.BBox().MaxY})
+		// Add the detector described by the feature's detector attribute
+		var err error
+		box := p.BBox()
+		switch attributes["detector"] {
+		case PointDetector:
+			err = e.AddPointDetector(end, 0, 0)
+		case AreaDetector:
+			err = e.AddAreaDetector(NewVector(box.MinX, box.MinY), NewVector(box.MaxX, box.MaxY), 0)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	e.Logger.Debugf("Nodes: %v, Links: %v", len(e.nodes), len(e.links))
+	return nil
+}
+
+// addPolyLine adds the links that make up each part of a polyline to the
+// road network.
+func (e *Environment) addPolyLine(parts []int32, points []shp.Point, attributes map[string]string) {
+	forward, backward := parseOneway(attributes["oneway"])
+	oneway := !(forward && backward)
+
+	lanes, err := strconv.Atoi(attributes["lanes"])
+	if err != nil || lanes < 1 {
+		lanes = 1
+	}
+
+	speedLimit, err := strconv.ParseFloat(attributes["maxspeed"], 64)
+	if err != nil || speedLimit < 0 {
+		speedLimit = 0
+	}
+
+	for i := 0; i < len(parts); i++ {
+		// Each part runs from its start index to the start of the next part
+		start := int(parts[i])
+		end := len(points)
+		if i+1 < len(parts) {
+			end = int(parts[i+1])
+		}
+
+		for j := start; j+1 < end; j++ {
+			from := e.addNode(NewVector(points[j].X, points[j].Y))
+			to := e.addNode(NewVector(points[j+1].X, points[j+1].Y))
+			if from.id == to.id {
+				continue
+			}
+
+			if forward {
+				e.addLink(from, to, oneway, lanes, speedLimit, attributes)
+			}
+			if backward {
+				e.addLink(to, from, oneway, lanes, speedLimit, attributes)
 			}
 		}
 	}
-	e.Logger.Debug(e.waypoints)
+}
+
+// parseOneway converts the value of a oneway attribute into the directions
+// the road can be travelled in, relative to the order it was digitised.
+func parseOneway(value string) (forward, backward bool) {
+	switch strings.ToLower(value) {
+	case "yes", "true", "1", "ft":
+		return true, false
+	case "-1", "reverse", "tf":
+		return false, true
+	default:
+		return true, true
+	}
 }
 
 // AddLight adds a new traffic light to the environment.
 func (e *Environment) AddLight(pos Vector, stop bool) {
-	light := NewLight(len(e.lights), pos, stop)
+	e.addLight(NewLight(len(e.lights), pos, stop))
+}
+
+// addLight stores the light in the environment.
+func (e *Environment) addLight(light Light) {
+	if _, found := e.lightIndex[light.position]; !found {
+		e.lightIndex[light.position] = len(e.lights)
+	}
 	e.lights = append(e.lights, light)
 }
 
@@ -76,11 +362,8 @@ func (e *Environment) GetLights() []Light {
 // GetLightAt retuns the Light at a given position. If no light
 // is found false is returned.
 func (e *Environment) GetLightAt(pos Vector) (light Light, found bool) {
-	for i := 0; i < len(e.lights); i++ {
-		currentLightPos := e.lights[i].GetPosition()
-		if currentLightPos.Equals(pos) {
-			return e.lights[i], true
-		}
+	if i, found := e.lightIndex[pos]; found {
+		return e.lights[i], true
 	}
 	return light, false
 }
@@ -95,6 +378,272 @@ func (e *Environment) UpdateLight(id int, stop bool) {
 	}
 }
 
+// AddSignalController adds a controller that runs the given plans to the
+// environment. The lights used by the plans must already exist.
+func (e *Environment) AddSignalController(plans []SignalPlan, schedule []ScheduleEntry) error {
+	controller, err := NewSignalController(len(e.signals), plans, schedule)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range controller.GetLights() {
+		if _, found := e.GetLight(id); !found {
+			return fmt.Errorf("no light found with the id: %v", id)
+		}
+	}
+
+	e.signals = append(e.signals, controller)
+	return nil
+}
+
+// GetSignalControllers returns the signal controllers in the environment.
+func (e *Environment) GetSignalControllers() []SignalController {
+	return e.signals
+}
+
+// UpdateSignals advances each of the signal controllers to the given
+// time, in seconds after midnight. The agents are used by controllers
+// that respond to the vehicles approaching their lights.
+func (e *Environment) UpdateSignals(time int, agents []Agent) {
+	if len(e.signals) == 0 {
+		return
+	}
+	traffic := e.trafficFor(agents)
+	for i := range e.signals {
+		e.signals[i].update(time, e.lights, traffic)
+	}
+}
+
+// SetSignalMode changes how the signal controller with the given id
+// decides the length of its phases.
+func (e *Environment) SetSignalMode(id int, mode string, minGreen, maxGreen int, gap, detectionDistance float64) error {
+	for i := range e.signals {
+		if e.signals[i].GetID() == id {
+			return e.signals[i].SetMode(mode, minGreen, maxGreen, gap, detectionDistance)
+		}
+	}
+	return fmt.Errorf("no signal found with the id: %v", id)
+}
+
+// AddPointDetector adds a point detector covering a length of road
+// centred on the given position.
+func (e *Environment) AddPointDetector(pos Vector, length float64, interval int) error {
+	d, err := NewPointDetector(len(e.detectors), pos, length, interval)
+	if err != nil {
+		return err
+	}
+	e.detectors = append(e.detectors, d)
+	return nil
+}
+
+// AddAreaDetector adds an area detector covering the rectangle between
+// the two corners given.
+func (e *Environment) AddAreaDetector(corner, opposite Vector, interval int) error {
+	d, err := NewAreaDetector(len(e.detectors), corner, opposite, interval)
+	if err != nil {
+		return err
+	}
+	e.detectors = append(e.detectors, d)
+	return nil
+}
+
+// GetDetectors returns the detectors in the environment.
+func (e *Environment) GetDetectors() []Detector {
+	return e.detectors
+}
+
+// GetDetector returns the detector with a given id.
+func (e *Environment) GetDetector(id int) (d Detector, found bool) {
+	for i := 0; i < len(e.detectors); i++ {
+		if e.detectors[i].GetID() == id {
+			return e.detectors[i], true
+		}
+	}
+	return d, false
+}
+
+// UpdateDetectors records the agents that passed each of the detectors
+// during the tick.
+func (e *Environment) UpdateDetectors(tick int, previous map[int]Vector, agents []Agent) {
+	for i := range e.detectors {
+		e.detectors[i].Update(tick, e.GetTimeStep(), previous, agents)
+	}
+}
+
+// AddCrosswalk adds a crosswalk between the two kerbs given. A light id
+// of -1 adds a crosswalk without signals, otherwise the light must exist.
+func (e *Environment) AddCrosswalk(start, end Vector, width float64, light int) error {
+	if light >= 0 {
+		if _, found := e.GetLight(light); !found {
+			return fmt.Errorf("no light found with the id: %v", light)
+		}
+	}
+
+	c, err := NewCrosswalk(len(e.crosswalks), start, end, width, light)
+	if err != nil {
+		return err
+	}
+	e.crosswalks = append(e.crosswalks, c)
+	return nil
+}
+
+// GetCrosswalks returns the crosswalks in the environment.
+func (e *Environment) GetCrosswalks() []Crosswalk {
+	return e.crosswalks
+}
+
+// GetCrosswalkBetween returns the crosswalk that runs between the two
+// positions. If there is no such crosswalk false is returned.
+func (e *Environment) GetCrosswalkBetween(from, to Vector) (c Crosswalk, found bool) {
+	for i := 0; i < len(e.crosswalks); i++ {
+		if e.crosswalks[i].connects(from, to, e.GetParameters().Margin) {
+			return e.crosswalks[i], true
+		}
+	}
+	return c, false
+}
+
+// UpdateCrosswalks counts the pedestrians on each of the crosswalks.
+func (e *Environment) UpdateCrosswalks(agents []Agent) {
+	for i := range e.crosswalks {
+		e.crosswalks[i].pedestrians = 0
+	}
+	for _, agent := range agents {
+		p, ok := agent.(Pedestrian)
+		if !ok {
+			continue
+		}
+		if id, crossing := p.GetCrossing(); crossing && id < len(e.crosswalks) {
+			e.crosswalks[id].pedestrians++
+		}
+	}
+}
+
+// crossingDistance returns the distance along the path from one position
+// to another to the nearest crosswalk with pedestrians on it. If there is
+// no such crosswalk math.MaxFloat64 is returned.
+func (e *Environment) crossingDistance(from, to Vector) float64 {
+	nearest := math.MaxFloat64
+	for i := range e.crosswalks {
+		if e.crosswalks[i].pedestrians == 0 {
+			continue
+		}
+		if distance, found := e.crosswalks[i].distanceAlong(from, to); found && distance < nearest {
+			nearest = distance
+		}
+	}
+	return nearest
+}
+
+// AddBusStop adds a bus stop that passengers arrive at with the given
+// rate each tick.
+func (e *Environment) AddBusStop(pos Vector, arrivalRate float64) error {
+	stop, err := NewBusStop(len(e.busStops), pos, arrivalRate)
+	if err != nil {
+		return err
+	}
+	e.busStops = append(e.busStops, stop)
+	return nil
+}
+
+// GetBusStops returns the bus stops in the environment.
+func (e *Environment) GetBusStops() []BusStop {
+	return e.busStops
+}
+
+// GetBusStop returns the bus stop with a given id.
+func (e *Environment) GetBusStop(id int) (stop BusStop, found bool) {
+	if id < 0 || id >= len(e.busStops) {
+		return stop, false
+	}
+	return e.busStops[id], true
+}
+
+// UpdateBusStops adds the passengers that arrive at each bus stop
+// during the tick.
+func (e *Environment) UpdateBusStops() {
+	for i := range e.busStops {
+		e.busStops[i].waiting += e.busStops[i].arrivalRate * e.GetTimeStep()
+	}
+}
+
+// boardBus boards the passengers waiting at the bus stop with the given
+// id and returns how many boarded.
+func (e *Environment) boardBus(id int) int {
+	if id < 0 || id >= len(e.busStops) {
+		return 0
+	}
+	return e.busStops[id].board()
+}
+
+// AddJunction adds priority rules to the node at the given position. The
+// major roads are given by the positions of the nodes they come from.
+func (e *Environment) AddJunction(pos Vector, control string, major []Vector, criticalGap float64) error {
+	if _, found := e.GetNodeAt(pos); !found {
+		return fmt.Errorf("no node found at: %v", pos)
+	}
+	for _, m := range major {
+		if _, found := e.GetLinkBetween(m, pos); !found {
+			return fmt.Errorf("no road found from %v to the junction", m)
+		}
+	}
+
+	junction, err := NewJunction(len(e.junctions), pos, control, major, criticalGap)
+	if err != nil {
+		return err
+	}
+	if _, found := e.junctionIndex[pos]; !found {
+		e.junctionIndex[pos] = len(e.junctions)
+	}
+	e.junctions = append(e.junctions, junction)
+	return nil
+}
+
+// GetJunctions returns the junctions in the environment.
+func (e *Environment) GetJunctions() []Junction {
+	return e.junctions
+}
+
+// SetDefaultJunction sets the control used at every node where more than
+// one road meets that has no signals or junction of its own. Only
+// AllWayStopControl, RightPriorityControl and LeftPriorityControl can be
+// used as they do not need major roads. An empty control removes the
+// default.
+func (e *Environment) SetDefaultJunction(control string) error {
+	switch control {
+	case "", AllWayStopControl, RightPriorityControl, LeftPriorityControl:
+		e.defaultJunction = control
+		return nil
+	default:
+		return fmt.Errorf("unable to use %v as the default junction control", control)
+	}
+}
+
+// GetDefaultJunction returns the control used at nodes without signals or
+// a junction of their own, empty if there is none.
+func (e *Environment) GetDefaultJunction() string {
+	return e.defaultJunction
+}
+
+// getJunction returns the junction at the position given. Nodes with
+// signals are never junctions, as the signals decide who goes.
+func (e *Environment) getJunction(pos Vector) (junction Junction, found bool) {
+	if _, found := e.GetLightAt(pos); found {
+		return junction, false
+	}
+	if i, found := e.junctionIndex[pos]; found {
+		return e.junctions[i], true
+	}
+
+	// Use the default control where roads meet
+	node, found := e.GetNodeAt(pos)
+	if e.defaultJunction == "" || !found || e.incoming[node.id] < 2 {
+		return junction, false
+	}
+	junction, err := NewJunction(-1, pos, e.defaultJunction, nil, 0)
+	return junction, err == nil
+}
+
 // GetLight returns the light with a given id.
 func (e *Environment) GetLight(id int) (l Light, found bool) {
 	for i := 0; i < len(e.lights); i++ {
@@ -0,0 +1,274 @@
+package simulation
+
+import (
+	"math"
+	"sort"
+)
+
+// trafficIndex keeps track of where the agents are so the agents around
+// a vehicle can be found without looking at every agent in the
+// simulation. Vehicles heading to the same waypoint are kept in a queue
+// ordered by their distance to it, and are placed in the square of a grid
+// covering the environment they are in. The index is updated each time
+// an agent acts.
+type trafficIndex struct {
+	// queues stores the agents heading to each waypoint, the closest
+	// to the waypoint first. Pedestrians are not included.
+	queues map[Vector][]queueEntry
+	// cells stores the agents in each square of the grid by id.
+	// Pedestrians are not included.
+	cells map[gridCell]map[int]Agent
+	// located stores where each agent is in the index by id.
+	located map[int]indexLocation
+	// waiting stores the number of pedestrians waiting for each light
+	// by light id.
+	waiting map[int]int
+	// longest is the length of the longest agent added.
+	longest float64
+}
+
+// queueEntry is an agent in the queue heading to a waypoint.
+type queueEntry struct {
+	agent Agent
+	// distance is the distance from the agent to the waypoint.
+	distance float64
+}
+
+// gridCell is the position of a square of the index's grid.
+type gridCell struct {
+	x int
+	y int
+}
+
+// indexLocation stores where an agent is in the index.
+type indexLocation struct {
+	// queued is true if the agent is in a queue and the grid.
+	queued bool
+	// waypoint is the waypoint of the queue the agent is in.
+	waypoint Vector
+	// distance is the agent's distance to the waypoint.
+	distance float64
+	// cell is the square of the grid the agent is in.
+	cell gridCell
+	// waitingFor is the light the pedestrian is waiting for,
+	// -1 if none.
+	waitingFor int
+}
+
+// newTrafficIndex creates an index of the agents given.
+func newTrafficIndex(agents []Agent) *trafficIndex {
+	t := &trafficIndex{
+		queues:  make(map[Vector][]queueEntry),
+		cells:   make(map[gridCell]map[int]Agent),
+		located: make(map[int]indexLocation),
+		waiting: make(map[int]int)}
+	for _, agent := range agents {
+		t.add(agent)
+	}
+	return t
+}
+
+// cellOf returns the square of the grid a position is in.
+func cellOf(pos Vector) gridCell {
+	return gridCell{
+		x: int(math.Floor(pos.x / gridCellSize)),
+		y: int(math.Floor(pos.y / gridCellSize))}
+}
+
+// add adds the agent to the index, replacing the agent with the same id.
+func (t *trafficIndex) add(agent Agent) {
+	id := agent.GetID()
+	if _, found := t.located[id]; found {
+		t.remove(id)
+	}
+
+	location := indexLocation{waitingFor: -1}
+	if p, walking := agent.(Pedestrian); walking {
+		if light, waiting := p.GetWaitingFor(); waiting {
+			location.waitingFor = light
+			t.waiting[light]++
+		}
+		t.located[id] = location
+		return
+	}
+
+	position := agent.GetPosition()
+	location.queued = true
+	location.waypoint = agent.GetCurrentWaypoint()
+	location.distance = position.DistanceTo(location.waypoint)
+	location.cell = cellOf(position)
+
+	// Agents at the same distance keep the order they were added in
+	queue := t.queues[location.waypoint]
+	i := sort.Search(len(queue), func(i int) bool { return queue[i].distance > location.distance })
+	queue = append(queue, queueEntry{})
+	copy(queue[i+1:], queue[i:])
+	queue[i] = queueEntry{agent: agent, distance: location.distance}
+	t.queues[location.waypoint] = queue
+
+	t.addToCell(location.cell, agent)
+	t.located[id] = location
+	t.longest = math.Max(t.longest, agentLength(agent))
+}
+
+// remove removes the agent with the given id from the index.
+func (t *trafficIndex) remove(id int) {
+	location, found := t.located[id]
+	if !found {
+		return
+	}
+	delete(t.located, id)
+
+	if location.waitingFor >= 0 {
+		t.waiting[location.waitingFor]--
+		if t.waiting[location.waitingFor] <= 0 {
+			delete(t.waiting, location.waitingFor)
+		}
+	}
+	if !location.queued {
+		return
+	}
+
+	queue := t.queues[location.waypoint]
+	if i := findEntry(queue, location.distance, id); i >= 0 {
+		queue = append(queue[:i], queue[i+1:]...)
+	}
+	if len(queue) == 0 {
+		delete(t.queues, location.waypoint)
+	} else {
+		t.queues[location.waypoint] = queue
+	}
+
+	t.removeFromCell(location.cell, id)
+}
+
+// update moves the agent to where it is now. Agents still heading to the
+// same waypoint are moved along their queue, which only passes the agents
+// they overtook.
+func (t *trafficIndex) update(agent Agent) {
+	id := agent.GetID()
+	location, found := t.located[id]
+	waypoint := agent.GetCurrentWaypoint()
+	if _, walking := agent.(Pedestrian); walking || !found || !location.queued || !location.waypoint.Equals(waypoint) {
+		t.add(agent)
+		return
+	}
+
+	queue := t.queues[waypoint]
+	i := findEntry(queue, location.distance, id)
+	if i < 0 {
+		t.add(agent)
+		return
+	}
+
+	position := agent.GetPosition()
+	distance := position.DistanceTo(waypoint)
+	queue[i] = queueEntry{agent: agent, distance: distance}
+	for i > 0 && queue[i-1].distance > distance {
+		queue[i-1], queue[i] = queue[i], queue[i-1]
+		i--
+	}
+	for i+1 < len(queue) && queue[i+1].distance <= distance {
+		queue[i+1], queue[i] = queue[i], queue[i+1]
+		i++
+	}
+
+	cell := cellOf(position)
+	if cell != location.cell {
+		t.removeFromCell(location.cell, id)
+	}
+	t.addToCell(cell, agent)
+
+	location.distance = distance
+	location.cell = cell
+	t.located[id] = location
+	t.longest = math.Max(t.longest, agentLength(agent))
+}
+
+// findEntry returns the index of the agent with the given id and
+// distance in the queue, or -1 if it is not there.
+func findEntry(queue []queueEntry, distance float64, id int) int {
+	i := sort.Search(len(queue), func(i int) bool { return queue[i].distance >= distance })
+	for ; i < len(queue) && queue[i].distance == distance; i++ {
+		if queue[i].agent.GetID() == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// addToCell stores the agent in a square of the grid.
+func (t *trafficIndex) addToCell(cell gridCell, agent Agent) {
+	agents, found := t.cells[cell]
+	if !found {
+		agents = make(map[int]Agent)
+		t.cells[cell] = agents
+	}
+	agents[agent.GetID()] = agent
+}
+
+// removeFromCell removes the agent with the given id from a square of
+// the grid.
+func (t *trafficIndex) removeFromCell(cell gridCell, id int) {
+	delete(t.cells[cell], id)
+	if len(t.cells[cell]) == 0 {
+		delete(t.cells, cell)
+	}
+}
+
+// queue returns the agents heading to the waypoint, the closest to the
+// waypoint first. The queue must not be changed.
+func (t *trafficIndex) queue(waypoint Vector) []queueEntry {
+	return t.queues[waypoint]
+}
+
+// eachAhead calls visit with each agent heading to the waypoint that is
+// closer to it than the distance given, starting with the furthest from
+// the waypoint, until visit returns false.
+func (t *trafficIndex) eachAhead(waypoint Vector, distance float64, visit func(agent Agent, distance float64) bool) {
+	queue := t.queues[waypoint]
+	i := sort.Search(len(queue), func(i int) bool { return queue[i].distance >= distance })
+	for i--; i >= 0; i-- {
+		if !visit(queue[i].agent, queue[i].distance) {
+			return
+		}
+	}
+}
+
+// eachBehind calls visit with each agent heading to the waypoint that is
+// not closer to it than the distance given, starting with the closest to
+// the waypoint, until visit returns false.
+func (t *trafficIndex) eachBehind(waypoint Vector, distance float64, visit func(agent Agent, distance float64) bool) {
+	queue := t.queues[waypoint]
+	i := sort.Search(len(queue), func(i int) bool { return queue[i].distance >= distance })
+	for ; i < len(queue); i++ {
+		if !visit(queue[i].agent, queue[i].distance) {
+			return
+		}
+	}
+}
+
+// near returns the agents within the radius of the position, sorted by
+// id. Pedestrians are not included.
+func (t *trafficIndex) near(pos Vector, radius float64) []Agent {
+	var agents []Agent
+	low := cellOf(Vector{x: pos.x - radius, y: pos.y - radius})
+	high := cellOf(Vector{x: pos.x + radius, y: pos.y + radius})
+	for x := low.x; x <= high.x; x++ {
+		for y := low.y; y <= high.y; y++ {
+			for _, agent := range t.cells[gridCell{x: x, y: y}] {
+				position := agent.GetPosition()
+				if position.DistanceTo(pos) <= radius {
+					agents = append(agents, agent)
+				}
+			}
+		}
+	}
+	sort.Slice(agents, func(i, j int) bool { return agents[i].GetID() < agents[j].GetID() })
+	return agents
+}
+
+// waitingFor returns the number of pedestrians waiting for the light.
+func (t *trafficIndex) waitingFor(light int) int {
+	return t.waiting[light]
+}
@@ -0,0 +1,224 @@
+package simulation
+
+import (
+	"fmt"
+	"math"
+)
+
+// PriorityControl is a junction where vehicles on the minor roads give way
+// to vehicles on the major roads, waiting for a big enough gap.
+const PriorityControl = "priority"
+
+// StopControl is a junction where vehicles on the minor roads must stop
+// before giving way to vehicles on the major roads.
+const StopControl = "stop"
+
+// AllWayStopControl is a junction where every vehicle must stop, then
+// vehicles go in the order they stopped.
+const AllWayStopControl = "allway"
+
+// RightPriorityControl is a junction where vehicles give way to vehicles
+// approaching from their right.
+const RightPriorityControl = "right"
+
+// LeftPriorityControl is a junction where vehicles give way to vehicles
+// approaching from their left.
+const LeftPriorityControl = "left"
+
+// Junction stores the priority rules vehicles follow at a node in the
+// road network without signals.
+type Junction struct {
+	// id is a unique integer used to identify the junction,
+	// -1 for junctions using the environment's default control.
+	id int
+	// position is the position of the junction's node.
+	position Vector
+	// control is the priority rule used at the junction.
+	control string
+	// major stores the positions of the nodes the major roads
+	// come from.
+	major []Vector
+	// criticalGap is the shortest time, in seconds, before a vehicle
+	// with priority reaches the junction that a vehicle giving
+	// way will accept to go.
+	criticalGap float64
+}
+
+// NewJunction returns a junction at the position given using the control
+// given. The major roads are given by the positions of the nodes they come
+// from, they are only used by PriorityControl and StopControl. If the
+// critical gap is 0 the default is used.
+func NewJunction(id int, pos Vector, control string, major []Vector, criticalGap float64) (Junction, error) {
+	switch control {
+	case PriorityControl, StopControl, AllWayStopControl, RightPriorityControl, LeftPriorityControl:
+	default:
+		return Junction{}, fmt.Errorf("unknown junction control: %v", control)
+	}
+	if criticalGap < 0 {
+		return Junction{}, fmt.Errorf("critical gap can not be negative: %v", criticalGap)
+	}
+	if criticalGap == 0 {
+		criticalGap = defaultCriticalGap
+	}
+
+	return Junction{
+		id:          id,
+		position:    pos,
+		control:     control,
+		major:       major,
+		criticalGap: criticalGap}, nil
+}
+
+// GetID returns the id of the junction.
+func (j *Junction) GetID() int {
+	return j.id
+}
+
+// GetPosition returns the position of the junction.
+func (j *Junction) GetPosition() Vector {
+	return j.position
+}
+
+// GetControl returns the priority rule used at the junction.
+func (j *Junction) GetControl() string {
+	return j.control
+}
+
+// GetMajor returns the positions of the nodes the major roads come from.
+func (j *Junction) GetMajor() []Vector {
+	return j.major
+}
+
+// GetCriticalGap returns the shortest gap, in seconds, vehicles giving way
+// at the junction will accept.
+func (j *Junction) GetCriticalGap() float64 {
+	return j.criticalGap
+}
+
+// isMajor returns true if a vehicle coming from the position given is on
+// one of the junction's major roads.
+func (j *Junction) isMajor(from Vector) bool {
+	for _, m := range j.major {
+		if m.Equals(from) {
+			return true
+		}
+	}
+	return false
+}
+
+// giveWay decides if the vehicle must wait at the stop line of the
+// junction it is approaching. If it must the distance to the stop line
+// and true are returned.
+func (v *Vehicle) giveWay(traffic *trafficIndex, env Environment) (float64, bool) {
+	junction, found := env.getJunction(v.currentWaypoint)
+	if !found {
+		return 0, false
+	}
+
+	parameters := env.GetParameters()
+	distance := v.position.DistanceTo(v.currentWaypoint)
+	stopLine := distance - parameters.JunctionStopLine
+	if distance > parameters.JunctionDistance || stopLine < 0 {
+		// Too far away to matter or already in the junction
+		return 0, false
+	}
+
+	// Count how long the vehicle has been waiting at the stop line
+	if v.speed < stoppedSpeed && stopLine <= parameters.StopLineRange {
+		v.junctionWait++
+	}
+
+	// The vehicles approaching the junction along other roads
+	var others []Vehicle
+	for _, entry := range traffic.queue(junction.position) {
+		other, ok := asVehicle(entry.agent)
+		if !ok || other.id == v.id {
+			continue
+		}
+		if other.lastWaypoint.Equals(v.lastWaypoint) {
+			continue
+		}
+		others = append(others, other)
+	}
+
+	switch junction.control {
+	case PriorityControl, StopControl:
+		if junction.isMajor(v.lastWaypoint) {
+			return 0, false
+		}
+		if junction.control == StopControl && v.junctionWait == 0 {
+			return stopLine, true
+		}
+		for _, other := range others {
+			if junction.isMajor(other.lastWaypoint) && other.arrivesWithin(junction.criticalGap) {
+				return stopLine, true
+			}
+		}
+
+	case AllWayStopControl:
+		if v.junctionWait == 0 || junctionOccupied(traffic, junction, parameters.JunctionClearance) {
+			return stopLine, true
+		}
+		for _, other := range others {
+			if other.junctionWait > 0 && other.goesBefore(*v) {
+				return stopLine, true
+			}
+		}
+
+	case RightPriorityControl, LeftPriorityControl:
+		direction := v.position.DirectionTo(v.currentWaypoint)
+		deadlocked := float64(v.junctionWait)*env.GetTimeStep() >= parameters.JunctionDeadlockWait
+		for _, other := range others {
+			if !other.arrivesWithin(junction.criticalGap) {
+				continue
+			}
+
+			// The side the other vehicle is approaching from
+			side := direction.x*(other.position.y-junction.position.y) - direction.y*(other.position.x-junction.position.x)
+			fromRight := side < 0
+			if fromRight != (junction.control == RightPriorityControl) {
+				continue
+			}
+
+			// When every approach is waiting the vehicle that has
+			// waited longest goes first
+			if deadlocked && other.junctionWait > 0 && !other.goesBefore(*v) {
+				continue
+			}
+			return stopLine, true
+		}
+	}
+
+	return 0, false
+}
+
+// arrivesWithin returns true if the vehicle will reach its current
+// waypoint within the given number of seconds at its current speed.
+func (v *Vehicle) arrivesWithin(seconds float64) bool {
+	distance := v.position.DistanceTo(v.currentWaypoint)
+	return distance <= seconds*math.Max(v.speed, stoppedSpeed)
+}
+
+// goesBefore returns true if the vehicle has waited at a junction longer
+// than the other vehicle, or as long with a lower id.
+func (v *Vehicle) goesBefore(other Vehicle) bool {
+	if v.junctionWait != other.junctionWait {
+		return v.junctionWait > other.junctionWait
+	}
+	return v.id < other.id
+}
+
+// junctionOccupied returns true if a vehicle that has passed through the
+// junction is not yet the clearance distance past it.
+func junctionOccupied(traffic *trafficIndex, junction Junction, clearance float64) bool {
+	for _, a := range traffic.near(junction.position, clearance) {
+		other, ok := asVehicle(a)
+		if !ok || !other.lastWaypoint.Equals(junction.position) {
+			continue
+		}
+		if other.position.DistanceTo(junction.position) < clearance {
+			return true
+		}
+	}
+	return false
+}
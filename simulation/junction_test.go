@@ -0,0 +1,154 @@
+package simulation
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// junctionScenario is a crossroads at the origin with a road from the west
+// and a shorter road from the south. %v is replaced with the junctions.
+const junctionScenario = `{
+  "environment": {"nodes": [[0,0],[-100,0],[100,0],[0,-60],[0,100]],
+    "links": [{"from":1,"to":0},{"from":0,"to":2},{"from":3,"to":0},{"from":0,"to":4}]},
+  "timeStep": 0.5,
+  "parameters": {"decelerationProbability": 0},
+  "junctions": [%v],
+  "agents": [
+    {"type":"vehicle","origin":[-100,0],"destination":[100,0],"maxSpeed":10,"acceleration":2,"deceleration":4},
+    {"type":"vehicle","origin":[0,-60],"destination":[0,100],"maxSpeed":10,"acceleration":2,"deceleration":4}
+  ]
+}`
+
+// West and south are the roads the vehicles come from.
+const (
+	west  = 0
+	south = 1
+)
+
+// junctionRun records, by road, the ticks each vehicle passed through the
+// junction and if it stopped before it.
+type junctionRun struct {
+	// passed is the tick each vehicle passed the junction's node.
+	passed map[int]int
+	// stopped is true for the vehicles that stopped before the node.
+	stopped map[int]bool
+	// slowed is true for the vehicles that slowed down from full
+	// speed away from the node.
+	slowed map[int]bool
+	// collisions is the collisions in the run.
+	collisions []Collision
+}
+
+// runJunction runs the junctionScenario with the junction given until both
+// vehicles have passed through the junction.
+func runJunction(t *testing.T, junction string) junctionRun {
+	sc, err := ReadScenario(strings.NewReader(fmt.Sprintf(junctionScenario, junction)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewSimulationFromScenario(sc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roads := make(map[int]int)
+	for road, a := range s.GetAgents() {
+		roads[a.GetID()] = road
+	}
+
+	run := junctionRun{passed: make(map[int]int), stopped: make(map[int]bool), slowed: make(map[int]bool)}
+	speeds := make(map[int]float64)
+	for tick := 1; len(run.passed) < 2; tick++ {
+		if tick > 200 {
+			t.Fatalf("vehicles passed at ticks %v after 200 ticks", run.passed)
+		}
+		s.RunSteps(1)
+
+		for _, a := range s.GetAgents() {
+			v := a.(Vehicle)
+			road := roads[v.id]
+			if _, found := run.passed[road]; found {
+				continue
+			}
+			// Distance before the node along the vehicle's road
+			before := -v.position.x
+			if road == south {
+				before = -v.position.y
+			}
+			if before <= 0 {
+				run.passed[road] = tick
+				continue
+			}
+			if v.speed < stoppedSpeed {
+				run.stopped[road] = true
+			}
+			if before > 10 && speeds[road] == v.maxSpeed && v.speed < v.maxSpeed {
+				run.slowed[road] = true
+			}
+			speeds[road] = v.speed
+		}
+	}
+	run.collisions = s.GetCollisions()
+	return run
+}
+
+// TestPriorityJunction checks the vehicle on the minor road waits for the
+// vehicle on the major road, which does not slow down for it.
+func TestPriorityJunction(t *testing.T) {
+	// Without a junction the vehicle from the south arrives first
+	free := runJunction(t, "")
+	if free.passed[south] >= free.passed[west] {
+		t.Fatalf("without a junction south passed at %v, west at %v", free.passed[south], free.passed[west])
+	}
+
+	run := runJunction(t, `{"position":[0,0],"control":"priority","major":[[-100,0]]}`)
+	if run.passed[south] <= run.passed[west] {
+		t.Fatalf("minor road passed at %v, before the major road at %v", run.passed[south], run.passed[west])
+	}
+	if run.slowed[west] || run.stopped[west] {
+		t.Fatal("vehicle on the major road slowed for the vehicle giving way")
+	}
+	if len(run.collisions) > 0 {
+		t.Fatalf("collisions %+v", run.collisions)
+	}
+
+	// On its own the minor road does not need to stop
+	alone := runJunction(t, `{"position":[0,0],"control":"priority","major":[[0,-60]]}`)
+	if alone.stopped[south] {
+		t.Fatal("vehicle on the major road stopped")
+	}
+}
+
+// TestStopJunction checks the vehicle on the minor road stops at the stop
+// line even with nothing to give way to.
+func TestStopJunction(t *testing.T) {
+	run := runJunction(t, `{"position":[0,0],"control":"stop","major":[[0,-60]]}`)
+	if !run.stopped[west] {
+		t.Fatal("vehicle on the minor road did not stop")
+	}
+	if run.stopped[south] || run.slowed[south] {
+		t.Fatal("vehicle on the major road slowed down")
+	}
+	if run.passed[south] >= run.passed[west] {
+		t.Fatalf("minor road passed at %v, before the major road at %v", run.passed[west], run.passed[south])
+	}
+	if len(run.collisions) > 0 {
+		t.Fatalf("collisions %+v", run.collisions)
+	}
+}
+
+// TestAllWayStopJunction checks every vehicle stops and they go in the
+// order they arrived.
+func TestAllWayStopJunction(t *testing.T) {
+	run := runJunction(t, `{"position":[0,0],"control":"allway"}`)
+	if !run.stopped[west] || !run.stopped[south] {
+		t.Fatalf("vehicles stopped %v, want both", run.stopped)
+	}
+	if run.passed[south] >= run.passed[west] {
+		t.Fatalf("south passed at %v, after west at %v", run.passed[south], run.passed[west])
+	}
+	if len(run.collisions) > 0 {
+		t.Fatalf("collisions %+v", run.collisions)
+	}
+}
@@ -0,0 +1,273 @@
+package simulation
+
+import "math"
+
+// laneOffset returns how far the centre of a lane is to the left of the
+// centreline of a link with the given number of lanes. Lane 0 is the
+// kerbside lane, on the left of the direction of travel. The lanes of a
+// one-way link are spread either side of the centreline, on a two-way link
+// each direction keeps to its own side so opposing lanes never overlap.
+func laneOffset(lanes, lane int, twoWay bool) float64 {
+	if twoWay {
+		return (float64(lanes-lane) - 0.5) * laneWidth
+	}
+	return (float64(lanes-1)/2 - float64(lane)) * laneWidth
+}
+
+// GetLane returns the lane the vehicle is in, 0 being the kerbside lane.
+func (v Vehicle) GetLane() int {
+	return v.lane
+}
+
+// SetLane changes the lane the vehicle is in.
+func (v Vehicle) SetLane(lane int) Vehicle {
+	if lane < 0 {
+		lane = 0
+	}
+	v.lane = lane
+	return v
+}
+
+// GetLanePosition returns the position of the vehicle moved sideways into
+// the centre of its lane.
+func (v Vehicle) GetLanePosition() Vector {
+	direction := v.lastWaypoint.DirectionTo(v.currentWaypoint)
+	offset := laneOffset(v.lanes, v.lane, v.twoWay)
+
+	// Rotate the direction of travel a quarter turn to the left
+	return NewVector(v.position.x-direction.y*offset, v.position.y+direction.x*offset)
+}
+
+// updateLanes finds the number of lanes on the link the vehicle is
+// travelling along, and if traffic travels the other way along it, and
+// keeps the vehicle within its lanes. Vehicles that are not on a link have
+// one lane.
+func (v *Vehicle) updateLanes(env Environment) {
+	v.lanes = 1
+	v.twoWay = false
+	if link, found := env.GetLinkBetween(v.lastWaypoint, v.currentWaypoint); found {
+		v.lanes = link.GetLanes()
+		_, v.twoWay = env.GetLinkBetween(v.currentWaypoint, v.lastWaypoint)
+	}
+	if v.lane >= v.lanes {
+		v.lane = v.lanes - 1
+	}
+}
+
+// changeLane uses the MOBIL lane change model to decide if the vehicle
+// should move into a neighbouring lane. A vehicle changes lane if it gains
+// more acceleration than it costs the vehicles around it, as long as the
+// vehicle behind in the new lane does not have to brake too hard. Vehicles
+// that need a lane to turn only move towards that lane.
+func (v *Vehicle) changeLane(agents []Agent, env Environment) {
+	v.updateLanes(env)
+
+	if v.laneChangeWait > 0 {
+		v.laneChangeWait--
+		return
+	}
+	if v.lanes < 2 {
+		return
+	}
+
+	traffic := env.trafficFor(agents)
+	parameters := env.GetParameters()
+	required := v.getTurnLane(parameters.TurnLaneDistance)
+	best := v.lane
+	bestIncentive := parameters.LaneChangeThreshold
+
+	for _, target := range []int{v.lane - 1, v.lane + 1} {
+		if target < 0 || target >= v.lanes {
+			continue
+		}
+
+		// When turning only move towards the lane needed
+		mandatory := false
+		if required >= 0 {
+			if abs(target-required) >= abs(v.lane-required) {
+				continue
+			}
+			mandatory = true
+		}
+
+		incentive, safe := v.laneChangeIncentive(traffic, parameters, target)
+		if !safe {
+			continue
+		}
+
+		// Vehicles keep to the kerbside lane unless overtaking
+		if target < v.lane {
+			incentive += parameters.KerbsideBias
+		} else {
+			incentive -= parameters.KerbsideBias
+		}
+
+		if mandatory || incentive > bestIncentive {
+			best = target
+			bestIncentive = incentive
+			if mandatory {
+				break
+			}
+		}
+	}
+
+	if best != v.lane {
+		v.Logger.Debugf("Changing lane %v -> %v", v.lane, best)
+		v.lane = best
+		v.laneChangeWait = ticks(parameters.LaneChangeCooldown, env.GetTimeStep())
+	}
+}
+
+// laneChangeIncentive returns the MOBIL incentive for the vehicle to move
+// into the target lane, and false if the move would not be safe.
+func (v *Vehicle) laneChangeIncentive(traffic *trafficIndex, parameters Parameters, target int) (incentive float64, safe bool) {
+	leader, leaderGap, follower, followerGap := v.getNeighbours(traffic, v.lane)
+	newLeader, newLeaderGap, newFollower, newFollowerGap := v.getNeighbours(traffic, target)
+
+	// There is no room beside the vehicle
+	if newFollowerGap <= 0 || newLeaderGap <= 0 {
+		return 0, false
+	}
+
+	// The vehicle's own gain
+	incentive = laneChangeAcceleration(*v, newLeader, newLeaderGap) -
+		laneChangeAcceleration(*v, leader, leaderGap)
+
+	// The vehicle that will be behind in the new lane
+	if f, ok := asVehicle(newFollower); ok {
+		after := laneChangeAcceleration(f, *v, newFollowerGap)
+		if after < -parameters.SafeDeceleration {
+			return 0, false
+		}
+		gap := math.MaxFloat64
+		if newLeader != nil {
+			gap = newFollowerGap + newLeaderGap
+		}
+		before := laneChangeAcceleration(f, newLeader, gap)
+		incentive += parameters.Politeness * (after - before)
+	}
+
+	// The vehicle left behind in the current lane
+	if f, ok := asVehicle(follower); ok {
+		gap := math.MaxFloat64
+		if leader != nil {
+			gap = followerGap + leaderGap
+		}
+		after := laneChangeAcceleration(f, leader, gap)
+		before := laneChangeAcceleration(f, *v, followerGap)
+		incentive += parameters.Politeness * (after - before)
+	}
+
+	return incentive, true
+}
+
+// laneChangeAcceleration estimates the acceleration of a vehicle behind
+// the leader given. Every model uses the Intelligent Driver Model for the
+// estimate, vehicles using it keep their own parameters.
+func laneChangeAcceleration(v Vehicle, leader Agent, gap float64) float64 {
+	model, ok := v.getModel().(IDM)
+	if !ok {
+		model = NewIDM(0, 0, 0, 0)
+	}
+
+	s := Surroundings{
+		Speed:         v.speed,
+		MaxSpeed:      v.maxSpeed,
+		Acceleration:  v.acceleration,
+		Deceleration:  v.deceleration,
+		Gap:           math.MaxFloat64,
+		LightDistance: math.MaxFloat64}
+	if leader != nil {
+		s.Gap = gap
+		s.LeaderSpeed = leader.GetSpeed()
+	}
+	return model.acceleration(s)
+}
+
+// getNeighbours finds the closest agents infront and behind the vehicle in
+// the given lane of the same link, and the gaps between their bumpers. If
+// there is no agent the agent is nil and the gap math.MaxFloat64.
+func (v *Vehicle) getNeighbours(traffic *trafficIndex, lane int) (leader Agent, leaderGap float64, follower Agent, followerGap float64) {
+	leaderGap = math.MaxFloat64
+	followerGap = math.MaxFloat64
+	vehicleToWaypoint := v.position.DistanceTo(v.currentWaypoint)
+
+	// Gaps are measured between bumpers, the agents closest to the
+	// vehicle along the queue are looked at first and the search stops
+	// once no agent further along could have a smaller gap. Agents with
+	// the same gap are taken in order of id.
+	traffic.eachAhead(v.currentWaypoint, vehicleToWaypoint, func(a Agent, agentToWaypoint float64) bool {
+		if vehicleToWaypoint-agentToWaypoint-traffic.longest > leaderGap {
+			return false
+		}
+		if a.GetID() == v.id || a.GetLane() != lane || !v.onSameLink(a) {
+			return true
+		}
+		aPosition := a.GetPosition()
+		gap := v.position.DistanceTo(aPosition) - agentLength(a)
+		if gap < leaderGap || (gap == leaderGap && a.GetID() < leader.GetID()) {
+			leader, leaderGap = a, gap
+		}
+		return true
+	})
+	traffic.eachBehind(v.currentWaypoint, vehicleToWaypoint, func(a Agent, agentToWaypoint float64) bool {
+		if agentToWaypoint-vehicleToWaypoint-v.length > followerGap {
+			return false
+		}
+		if a.GetID() == v.id || a.GetLane() != lane || !v.onSameLink(a) {
+			return true
+		}
+		aPosition := a.GetPosition()
+		gap := v.position.DistanceTo(aPosition) - v.length
+		if gap < followerGap || (gap == followerGap && a.GetID() < follower.GetID()) {
+			follower, followerGap = a, gap
+		}
+		return true
+	})
+	return
+}
+
+// getTurnLane returns the lane the vehicle needs to be in to turn at its
+// current waypoint, or -1 if the vehicle can use any lane. Vehicles start
+// moving into the lane within the distance given of the waypoint. Left
+// turns are made from the kerbside lane and right turns from the outside
+// lane.
+func (v *Vehicle) getTurnLane(turnLaneDistance float64) int {
+	if len(v.route) == 0 || v.position.DistanceTo(v.currentWaypoint) > turnLaneDistance {
+		return -1
+	}
+
+	in := v.lastWaypoint.DirectionTo(v.currentWaypoint)
+	out := v.currentWaypoint.DirectionTo(v.route[0])
+	turn := in.x*out.y - in.y*out.x
+
+	switch {
+	case turn > turnThreshold:
+		return 0
+	case turn < -turnThreshold:
+		return v.lanes - 1
+	default:
+		return -1
+	}
+}
+
+// asVehicle returns the vehicle an agent drives, including the vehicle
+// of a bus. If the agent is not a vehicle false is returned.
+func asVehicle(agent Agent) (Vehicle, bool) {
+	switch a := agent.(type) {
+	case Vehicle:
+		return a, true
+	case Bus:
+		return a.Vehicle, true
+	default:
+		return Vehicle{}, false
+	}
+}
+
+// abs returns the absolute value of an int.
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
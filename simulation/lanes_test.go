@@ -0,0 +1,82 @@
+package simulation
+
+import (
+	"testing"
+
+	shp "github.com/jonas-p/go-shp"
+)
+
+// TestLanePositions checks each direction of a two-way road keeps to its
+// own side of the centreline and the lanes of a one-way road are spread
+// either side of it.
+func TestLanePositions(t *testing.T) {
+	env := NewEnvironment()
+	env.addPolyLine([]int32{0}, []shp.Point{{X: 0, Y: 0}, {X: 1000, Y: 0}}, map[string]string{"lanes": "2"})
+	env.addPolyLine([]int32{0}, []shp.Point{{X: 0, Y: 100}, {X: 1000, Y: 100}}, map[string]string{"lanes": "2", "oneway": "yes"})
+
+	lanePosition := func(from, to Vector, lane int) Vector {
+		v := NewVehicle(-1, from, 0, 10, 2, 3, []Vector{to}, 0).SetLane(lane)
+		v.updateLanes(env)
+		return v.GetLanePosition()
+	}
+
+	// Travelling east the left is north, travelling west it is south
+	for lane, offset := range []float64{1.5 * laneWidth, 0.5 * laneWidth} {
+		if p := lanePosition(NewVector(0, 0), NewVector(1000, 0), lane); p.y != offset {
+			t.Fatalf("eastbound lane %v at y %v, want %v", lane, p.y, offset)
+		}
+		if p := lanePosition(NewVector(1000, 0), NewVector(0, 0), lane); p.y != -offset {
+			t.Fatalf("westbound lane %v at y %v, want %v", lane, p.y, -offset)
+		}
+	}
+
+	for lane, y := range []float64{100 + 0.5*laneWidth, 100 - 0.5*laneWidth} {
+		if p := lanePosition(NewVector(0, 100), NewVector(1000, 100), lane); p.y != y {
+			t.Fatalf("one-way lane %v at y %v, want %v", lane, p.y, y)
+		}
+	}
+}
+
+// TestOvertake checks a vehicle stuck behind a slower vehicle moves into
+// the outside lane to pass it and returns to the kerbside lane after.
+func TestOvertake(t *testing.T) {
+	env := NewEnvironment()
+	env.addPolyLine([]int32{0}, []shp.Point{{X: 0, Y: 0}, {X: 100, Y: 0}, {X: 3000, Y: 0}}, map[string]string{"lanes": "2", "oneway": "yes"})
+	s := NewSimulation(env)
+	s.SetSeed(1)
+
+	idm, err := NewCarFollowingModel(IDMModel, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slow := NewVehicle(-1, NewVector(100, 0), 5, 5, 2, 4, []Vector{NewVector(3000, 0)}, 0)
+	fast := NewVehicle(-1, NewVector(0, 0), 15, 20, 2, 4, []Vector{NewVector(100, 0), NewVector(3000, 0)}, 0)
+	s.AddAgent(slow.SetModel(idm))
+	s.AddAgent(fast.SetModel(idm))
+
+	overtook := false
+	for i := 0; i < 60; i++ {
+		s.RunSteps(1)
+		agents := s.GetAgents()
+		if agents[1].GetLane() == 1 {
+			overtook = true
+		}
+		if agents[0].GetLane() != 0 {
+			t.Fatalf("slow vehicle moved into lane %v at tick %v", agents[0].GetLane(), i)
+		}
+	}
+	if len(s.GetCollisions()) > 0 {
+		t.Fatalf("collisions %+v", s.GetCollisions())
+	}
+
+	agents := s.GetAgents()
+	if !overtook {
+		t.Fatal("fast vehicle never left the kerbside lane")
+	}
+	if agents[1].GetPosition().x <= agents[0].GetPosition().x {
+		t.Fatalf("fast vehicle at %v behind the slow vehicle at %v", agents[1].GetPosition(), agents[0].GetPosition())
+	}
+	if agents[1].GetLane() != 0 {
+		t.Fatalf("fast vehicle in lane %v after passing, want 0", agents[1].GetLane())
+	}
+}
@@ -10,6 +10,9 @@ type Light struct {
 	// stop is true if the traffic light is
 	// red and false if green.
 	stop bool
+	// state is the colour the light is showing, either GreenSignal,
+	// AmberSignal or RedSignal. Agents treat amber the same as red.
+	state string
 	// position is a vecotor storing the location of the traffic light.
 	// This location should be the same location as a target waypoint
 	// for the vehicle you want to stop.
@@ -24,13 +27,14 @@ func NewLight(id int, pos Vector, stop bool) Light {
 	var l Light
 	l.id = id
 	l.position = pos
-	l.stop = stop
 
 	// Setup the logger
 	l.Logger = log.WithFields(log.Fields{
 		"package": "simulation",
 		"section": "Light"})
 
+	l.SetStop(stop)
+
 	return l
 }
 
@@ -44,10 +48,29 @@ func (l *Light) GetStop() bool {
 	return l.stop
 }
 
-// SetStop updates the stop bool to the value specified.
+// SetStop updates the stop bool to the value specified. A light that
+// is told to stop shows red, otherwise it shows green.
 func (l *Light) SetStop(stop bool) {
-	l.Logger.Debugf("%v Light set to: %v", l.id, l.stop)
-	l.stop = stop
+	if stop {
+		l.SetState(RedSignal)
+	} else {
+		l.SetState(GreenSignal)
+	}
+}
+
+// GetState returns the colour the light is currently showing.
+func (l *Light) GetState() string {
+	return l.state
+}
+
+// SetState changes the colour the light is showing. Agents should stop
+// for any state other than GreenSignal.
+func (l *Light) SetState(state string) {
+	if state != l.state {
+		l.Logger.Debugf("%v Light set to: %v", l.id, state)
+	}
+	l.state = state
+	l.stop = state != GreenSignal
 }
 
 // GetPosition retuns the position of the traffic light.
@@ -0,0 +1,236 @@
+package simulation
+
+import (
+	"errors"
+	"math"
+	"sort"
+)
+
+// MetricsRecord stores the network wide measurements taken during one tick.
+type MetricsRecord struct {
+	// Tick is the tick the measurements were taken at.
+	Tick int `json:"tick"`
+	// Time is the number of seconds simulated by the end of the tick.
+	Time float64 `json:"time"`
+	// Vehicles is the number of vehicles in the network at the end
+	// of the tick.
+	Vehicles int `json:"vehicles"`
+	// Pedestrians is the number of pedestrians in the network at the
+	// end of the tick.
+	Pedestrians int `json:"pedestrians"`
+	// Spawned is the number of agents added to the network since
+	// the last tick.
+	Spawned int `json:"spawned"`
+	// Arrived is the number of agents that reached their destination.
+	Arrived int `json:"arrived"`
+	// MeanSpeed is the average speed of the vehicles in the network.
+	MeanSpeed float64 `json:"meanSpeed"`
+	// Speed50, Speed85 and Speed95 are the 50th, 85th and 95th
+	// percentile speeds of the vehicles in the network.
+	Speed50 float64 `json:"speed50"`
+	Speed85 float64 `json:"speed85"`
+	Speed95 float64 `json:"speed95"`
+	// Stopped is the number of vehicles with a speed below stoppedSpeed.
+	Stopped int `json:"stopped"`
+	// Queues is the number of stopped vehicles waiting for each light,
+	// indexed by the light's id.
+	Queues []int `json:"queues"`
+}
+
+// MetricsInterval stores the network wide measurements aggregated over
+// a number of ticks.
+type MetricsInterval struct {
+	// Start is the first tick in the interval.
+	Start int `json:"start"`
+	// End is the last tick in the interval.
+	End int `json:"end"`
+	// StartTime and EndTime are the number of seconds simulated by
+	// the end of the first and last ticks in the interval.
+	StartTime float64 `json:"startTime"`
+	EndTime   float64 `json:"endTime"`
+	// MeanVehicles is the average number of vehicles in the network.
+	MeanVehicles float64 `json:"meanVehicles"`
+	// MaxVehicles is the most vehicles in the network at once.
+	MaxVehicles int `json:"maxVehicles"`
+	// MeanPedestrians is the average number of pedestrians in the network.
+	MeanPedestrians float64 `json:"meanPedestrians"`
+	// Spawned is the number of agents added to the network.
+	Spawned int `json:"spawned"`
+	// Arrived is the number of agents that reached their destination.
+	Arrived int `json:"arrived"`
+	// MeanSpeed is the average speed of the vehicles over every tick.
+	MeanSpeed float64 `json:"meanSpeed"`
+	// Speed50, Speed85 and Speed95 are the percentile speeds of each tick
+	// averaged, weighted by the number of vehicles in the network.
+	Speed50 float64 `json:"speed50"`
+	Speed85 float64 `json:"speed85"`
+	Speed95 float64 `json:"speed95"`
+	// MeanStopped is the average number of stopped vehicles.
+	MeanStopped float64 `json:"meanStopped"`
+	// MeanQueues is the average queue at each light.
+	MeanQueues []float64 `json:"meanQueues"`
+	// MaxQueues is the longest queue at each light.
+	MaxQueues []int `json:"maxQueues"`
+}
+
+// measureNetwork takes the network wide measurements for a tick, which
+// ends the given number of seconds into the simulation. Pedestrians are
+// counted separately and left out of the vehicle measurements.
+func measureNetwork(tick int, time float64, agents []Agent, lights []Light, spawned, arrived int) MetricsRecord {
+	record := MetricsRecord{
+		Tick:    tick,
+		Time:    time,
+		Spawned: spawned,
+		Arrived: arrived,
+		Queues:  make([]int, len(lights))}
+
+	var speeds []float64
+	var sum float64
+	for _, agent := range agents {
+		if _, walking := agent.(Pedestrian); walking {
+			record.Pedestrians++
+			continue
+		}
+		record.Vehicles++
+
+		speed := agent.GetSpeed()
+		speeds = append(speeds, speed)
+		sum += speed
+
+		if speed >= stoppedSpeed {
+			continue
+		}
+		record.Stopped++
+
+		// A stopped vehicle heading for a light is queuing at it
+		waypoint := agent.GetCurrentWaypoint()
+		for i, light := range lights {
+			if waypoint.Equals(light.GetPosition()) {
+				record.Queues[i]++
+				break
+			}
+		}
+	}
+
+	if len(speeds) > 0 {
+		sort.Float64s(speeds)
+		record.MeanSpeed = sum / float64(len(speeds))
+		record.Speed50 = percentile(speeds, 50)
+		record.Speed85 = percentile(speeds, 85)
+		record.Speed95 = percentile(speeds, 95)
+	}
+	return record
+}
+
+// percentile returns the pth percentile of the sorted values, using
+// linear interpolation between the closest ranks.
+func percentile(sorted []float64, p float64) float64 {
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(rank-float64(lower))
+}
+
+// GetMetrics returns the records of the ticks between from and to
+// inclusive. If to is less than 1 the records up to the current tick
+// are returned.
+func (s *Simulation) GetMetrics(from, to int) []MetricsRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]MetricsRecord{}, s.metricsBetween(from, to)...)
+}
+
+// GetMetricsIntervals aggregates the records of the ticks between from and
+// to inclusive into intervals of the given number of ticks. If to is less
+// than 1 the records up to the current tick are used.
+func (s *Simulation) GetMetricsIntervals(from, to, interval int) ([]MetricsInterval, error) {
+	if interval < 1 {
+		return nil, errors.New("metrics interval must be at least 1 tick")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records := s.metricsBetween(from, to)
+	var intervals []MetricsInterval
+
+	for start := 0; start < len(records); start += interval {
+		end := start + interval
+		if end > len(records) {
+			end = len(records)
+		}
+
+		var i MetricsInterval
+		var vehicles, pedestrians, stopped int
+		var speedSum, speed50, speed85, speed95 float64
+		i.Start = records[start].Tick
+		i.End = records[end-1].Tick
+		i.StartTime = records[start].Time
+		i.EndTime = records[end-1].Time
+		i.MeanQueues = make([]float64, len(records[end-1].Queues))
+		i.MaxQueues = make([]int, len(records[end-1].Queues))
+
+		for _, r := range records[start:end] {
+			vehicles += r.Vehicles
+			pedestrians += r.Pedestrians
+			stopped += r.Stopped
+			i.Spawned += r.Spawned
+			i.Arrived += r.Arrived
+			if r.Vehicles > i.MaxVehicles {
+				i.MaxVehicles = r.Vehicles
+			}
+
+			weight := float64(r.Vehicles)
+			speedSum += r.MeanSpeed * weight
+			speed50 += r.Speed50 * weight
+			speed85 += r.Speed85 * weight
+			speed95 += r.Speed95 * weight
+
+			// Lights added part way through have no queue before then
+			for light, queue := range r.Queues {
+				i.MeanQueues[light] += float64(queue)
+				if queue > i.MaxQueues[light] {
+					i.MaxQueues[light] = queue
+				}
+			}
+		}
+
+		ticks := float64(end - start)
+		i.MeanVehicles = float64(vehicles) / ticks
+		i.MeanPedestrians = float64(pedestrians) / ticks
+		i.MeanStopped = float64(stopped) / ticks
+		for light := range i.MeanQueues {
+			i.MeanQueues[light] /= ticks
+		}
+		if vehicles > 0 {
+			i.MeanSpeed = speedSum / float64(vehicles)
+			i.Speed50 = speed50 / float64(vehicles)
+			i.Speed85 = speed85 / float64(vehicles)
+			i.Speed95 = speed95 / float64(vehicles)
+		}
+
+		intervals = append(intervals, i)
+	}
+	return intervals, nil
+}
+
+// metricsBetween returns the records of the ticks between from and to
+// inclusive, without locking the simulation.
+func (s *Simulation) metricsBetween(from, to int) []MetricsRecord {
+	if to < 1 {
+		to = s.currentTick
+	}
+
+	// The records are stored in tick order, one for every tick
+	start := sort.Search(len(s.metrics), func(i int) bool {
+		return s.metrics[i].Tick >= from
+	})
+	end := sort.Search(len(s.metrics), func(i int) bool {
+		return s.metrics[i].Tick > to
+	})
+	if start >= end {
+		return nil
+	}
+	return s.metrics[start:end]
+}
@@ -0,0 +1,118 @@
+package simulation
+
+// Node is a point in the road network where links start or end.
+type Node struct {
+	// id is a unique integer used to identify the node.
+	id int
+	// position stores the location of the node.
+	position Vector
+}
+
+// NewNode returns a Node with the specified paramaters.
+func NewNode(id int, pos Vector) Node {
+	var n Node
+	n.id = id
+	n.position = pos
+	return n
+}
+
+// GetID returns the id of the node.
+func (n *Node) GetID() int {
+	return n.id
+}
+
+// GetPosition returns the location of the node.
+func (n *Node) GetPosition() Vector {
+	return n.position
+}
+
+// Link is a directed section of road between two nodes. A two way road
+// is made up of two links, one for each direction of travel.
+type Link struct {
+	// id is a unique integer used to identify the link.
+	id int
+	// from is the id of the node the link starts at.
+	from int
+	// to is the id of the node the link ends at.
+	to int
+	// length is the distance between the start and end of the link.
+	length float64
+	// direction is a unit vector pointing from the start of the link
+	// towards the end of the link.
+	direction Vector
+	// oneway is true if the road the link was created from can
+	// only be travelled in one direction.
+	oneway bool
+	// lanes is the number of lanes in the link's direction of travel.
+	lanes int
+	// speedLimit is the maximum speed allowed on the link. A value of
+	// 0 means no limit has been given.
+	speedLimit float64
+	// attributes stores the raw attribute table values of the feature
+	// the link was created from.
+	attributes map[string]string
+}
+
+// NewLink returns a Link between the two nodes given. The length and
+// direction of the link are calculated from the node positions.
+func NewLink(id int, from, to Node, oneway bool, lanes int, speedLimit float64, attributes map[string]string) Link {
+	var l Link
+	l.id = id
+	l.from = from.id
+	l.to = to.id
+	l.length = from.position.DistanceTo(to.position)
+	l.direction = from.position.DirectionTo(to.position)
+	l.oneway = oneway
+	l.lanes = lanes
+	l.speedLimit = speedLimit
+	l.attributes = attributes
+	return l
+}
+
+// GetID returns the id of the link.
+func (l *Link) GetID() int {
+	return l.id
+}
+
+// GetFrom returns the id of the node the link starts at.
+func (l *Link) GetFrom() int {
+	return l.from
+}
+
+// GetTo returns the id of the node the link ends at.
+func (l *Link) GetTo() int {
+	return l.to
+}
+
+// GetLength returns the length of the link.
+func (l *Link) GetLength() float64 {
+	return l.length
+}
+
+// GetDirection returns the unit vector pointing along the link.
+func (l *Link) GetDirection() Vector {
+	return l.direction
+}
+
+// IsOneway returns true if the link's road can only be travelled in
+// one direction.
+func (l *Link) IsOneway() bool {
+	return l.oneway
+}
+
+// GetLanes returns the number of lanes on the link.
+func (l *Link) GetLanes() int {
+	return l.lanes
+}
+
+// GetSpeedLimit returns the speed limit of the link, 0 if not set.
+func (l *Link) GetSpeedLimit() float64 {
+	return l.speedLimit
+}
+
+// GetAttribute returns the value of the attribute with the given name.
+// If the attribute does not exist found is false.
+func (l *Link) GetAttribute(name string) (value string, found bool) {
+	value, found = l.attributes[name]
+	return
+}
@@ -0,0 +1,118 @@
+package simulation
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Parameters are the settings that control how the agents of a
+// simulation behave. Each simulation has its own, so simulations with
+// different parameters can be run side by side. The json names are used
+// to change some of the parameters without giving the rest.
+type Parameters struct {
+	// DecelerationProbability is the chance a vehicle following the
+	// Rules slows down even if there is nothing ahead, between 0 and 1.
+	DecelerationProbability float64 `json:"decelerationProbability"`
+	// Margin is the furthest, in metres, an agent can be from a point
+	// and count as having reached it.
+	Margin float64 `json:"margin"`
+	// MinimumGap is the smallest bumper to bumper gap, in metres, a
+	// vehicle leaves to the vehicle infront whatever its car-following
+	// model decides.
+	MinimumGap float64 `json:"minimumGap"`
+	// LaneChangeCooldown is the number of seconds a vehicle waits after
+	// changing lane before it can change lane again.
+	LaneChangeCooldown float64 `json:"laneChangeCooldown"`
+	// LaneChangeThreshold is the acceleration a vehicle must gain before
+	// it changes lane.
+	LaneChangeThreshold float64 `json:"laneChangeThreshold"`
+	// KerbsideBias is the extra acceleration a vehicle needs to move away
+	// from the kerbside lane, and is given for moving back towards it.
+	KerbsideBias float64 `json:"kerbsideBias"`
+	// Politeness is how much a vehicle cares about the vehicles it slows
+	// down when changing lane.
+	Politeness float64 `json:"politeness"`
+	// SafeDeceleration is the hardest a vehicle can make the vehicle
+	// behind it in a new lane brake.
+	SafeDeceleration float64 `json:"safeDeceleration"`
+	// TurnLaneDistance is how far from a junction vehicles start moving
+	// into the lane they need to turn.
+	TurnLaneDistance float64 `json:"turnLaneDistance"`
+	// JunctionDistance is how close to a junction vehicles start
+	// following its priority rules.
+	JunctionDistance float64 `json:"junctionDistance"`
+	// JunctionDeadlockWait is the number of seconds a vehicle waits when
+	// every approach to a junction is giving way before the longest
+	// waiting goes.
+	JunctionDeadlockWait float64 `json:"junctionDeadlockWait"`
+	// JunctionStopLine is how far before a junction's node vehicles
+	// giving way wait.
+	JunctionStopLine float64 `json:"junctionStopLine"`
+	// StopLineRange is how close to the stop line a stopped vehicle
+	// must be to count as waiting at it.
+	StopLineRange float64 `json:"stopLineRange"`
+	// JunctionClearance is how far past a junction's node a vehicle
+	// must be before the next vehicle at an all-way stop can go.
+	JunctionClearance float64 `json:"junctionClearance"`
+	// BusDeadTime is the number of seconds a bus spends at a stop opening
+	// and closing its doors, however many passengers board.
+	BusDeadTime float64 `json:"busDeadTime"`
+	// BoardingTime is the number of seconds each passenger takes to
+	// board a bus.
+	BoardingTime float64 `json:"boardingTime"`
+	// BunchingRatio is the fraction of the scheduled headway below
+	// which a bus arriving at a stop counts as bunched with the bus
+	// before.
+	BunchingRatio float64 `json:"bunchingRatio"`
+	// EarlyDeparture is the most seconds a bus can leave a stop before
+	// its scheduled time and still be on time.
+	EarlyDeparture float64 `json:"earlyDeparture"`
+	// LateDeparture is the most seconds a bus can leave a stop after
+	// its scheduled time and still be on time.
+	LateDeparture float64 `json:"lateDeparture"`
+}
+
+// DefaultParameters returns the parameters simulations are created with.
+func DefaultParameters() Parameters {
+	return Parameters{
+		DecelerationProbability: defaultDecelerationProbability,
+		Margin:                  defaultMargin,
+		MinimumGap:              defaultMinimumGap,
+		LaneChangeCooldown:      defaultLaneChangeCooldown,
+		LaneChangeThreshold:     defaultLaneChangeThreshold,
+		KerbsideBias:            defaultKerbsideBias,
+		Politeness:              defaultPoliteness,
+		SafeDeceleration:        defaultSafeDeceleration,
+		TurnLaneDistance:        defaultTurnLaneDistance,
+		JunctionDistance:        defaultJunctionDistance,
+		JunctionDeadlockWait:    defaultJunctionDeadlockWait,
+		JunctionStopLine:        defaultJunctionStopLine,
+		StopLineRange:           defaultStopLineRange,
+		JunctionClearance:       defaultJunctionClearance,
+		BusDeadTime:             defaultBusDeadTime,
+		BoardingTime:            defaultBoardingTime,
+		BunchingRatio:           defaultBunchingRatio,
+		EarlyDeparture:          defaultEarlyDeparture,
+		LateDeparture:           defaultLateDeparture}
+}
+
+// validate returns an error if any of the parameters can not be used.
+func (p Parameters) validate() error {
+	if p.DecelerationProbability < 0 || p.DecelerationProbability > 1 {
+		return fmt.Errorf("deceleration probability must be between 0 and 1: %v", p.DecelerationProbability)
+	}
+	if p.Margin <= 0 {
+		return fmt.Errorf("margin must be more than 0: %v", p.Margin)
+	}
+	// The kerbside bias can be negative to keep vehicles out of the
+	// kerbside lane
+	if p.MinimumGap < 0 || p.LaneChangeCooldown < 0 || p.LaneChangeThreshold < 0 ||
+		p.Politeness < 0 || p.SafeDeceleration < 0 || p.TurnLaneDistance < 0 ||
+		p.JunctionDistance < 0 || p.JunctionDeadlockWait < 0 ||
+		p.JunctionStopLine < 0 || p.StopLineRange < 0 || p.JunctionClearance < 0 ||
+		p.BusDeadTime < 0 || p.BoardingTime < 0 ||
+		p.BunchingRatio < 0 || p.EarlyDeparture < 0 || p.LateDeparture < 0 {
+		return errors.New("parameters other than the kerbside bias can not be negative")
+	}
+	return nil
+}
@@ -0,0 +1,283 @@
+package simulation
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Pedestrian implements the agent interface.
+// The agent represents a person walking along the footpaths, who waits
+// at crosswalks until they are allowed to cross.
+type Pedestrian struct {
+	id int
+	// position stores the current postion of the pedestrian
+	position Vector
+	// speed stores the current speed of the pedestrian
+	speed float64
+	// walkingSpeed stores the speed the pedestrian walks at.
+	walkingSpeed float64
+	// route stores a list of waypoints the pedestrian must visit to
+	// reach its final destination.
+	route []Vector
+	// plannedRoute stores the full route the pedestrian was given.
+	plannedRoute []Vector
+	// currentWaypoint stores the position of the
+	// pedestrian's current destination.
+	currentWaypoint Vector
+	// lastWaypoint stores the position the pedestrian started
+	// walking towards its current waypoint from.
+	lastWaypoint Vector
+	// crossing is the id of the crosswalk the pedestrian is
+	// on, -1 if they are not crossing.
+	crossing int
+	// waitingFor is the id of the light the pedestrian is
+	// waiting for, -1 if they are not waiting.
+	waitingFor int
+	// frequency is how often the pedestrian spawns in the
+	// simulation.
+	frequency int
+
+	// Logger is used to give a context based log to the stdout
+	Logger *log.Entry
+}
+
+// pedestrianParameters are the parameters a pedestrian is created with
+// when added by type name.
+type pedestrianParameters struct {
+	WalkingSpeed float64 `json:"walkingSpeed" description:"The speed the pedestrian walks at in metres per second. Defaults to 1.4."`
+}
+
+func init() {
+	mustRegisterAgentType(
+		"pedestrian",
+		"A person walking between waypoints, waiting at crosswalks until they can cross.",
+		func() interface{} { return &pedestrianParameters{} },
+		func(spec AgentSpec, parameters interface{}) (Agent, error) {
+			p := parameters.(*pedestrianParameters)
+			if p.WalkingSpeed < 0 {
+				return nil, fmt.Errorf("walking speed can not be negative: %v", p.WalkingSpeed)
+			}
+			return NewPedestrian(-1, spec.Start, p.WalkingSpeed, spec.Route, spec.Frequency), nil
+		},
+		AgentCodec{Encode: savePedestrian, Decode: loadPedestrian, Describe: describePedestrian})
+}
+
+// NewPedestrian creates a new pedestrian and intilises its values
+// using the paramaters provided. If the walking speed is 0 the
+// default is used.
+func NewPedestrian(
+	id int,
+	startPosition Vector,
+	walkingSpeed float64,
+	route []Vector,
+	freq int) Pedestrian {
+
+	if walkingSpeed == 0 {
+		walkingSpeed = defaultWalkingSpeed
+	}
+
+	p := Pedestrian{}
+	p.id = id
+	// Setup the logger
+	p.Logger = log.WithFields(log.Fields{
+		"package": "simulation",
+		"section": "pedestrian",
+		"id":      p.id})
+
+	p.position = startPosition
+	p.walkingSpeed = walkingSpeed
+	p.route = route
+	p.plannedRoute = route
+	p.frequency = freq
+	p.crossing = -1
+	p.waitingFor = -1
+	// Get the first waypoint
+	p.getNextWaypoint()
+	p.lastWaypoint = startPosition
+
+	return p
+}
+
+// Act simulates a pedestrian's behaviour for one tick in the simulation.
+// If true is returned the pedestrian has reached their final destination.
+func (p Pedestrian) Act(agents []Agent, env Environment, rng *rand.Rand) (Agent, bool) {
+	// Check if waypoint reached
+	if p.position.InRange(p.currentWaypoint, env.GetParameters().Margin) {
+		if !p.getNextWaypoint() {
+			p.Logger.Infof("Reached Destination: %v", p.position)
+			return p, true
+		}
+	}
+
+	// Wait at the kerb until the crosswalk can be used
+	p.waitingFor = -1
+	if p.crossing < 0 {
+		if crosswalk, found := env.GetCrosswalkBetween(p.lastWaypoint, p.currentWaypoint); found {
+			if !crosswalk.canCross(env.GetLights()) {
+				p.speed = 0
+				p.waitingFor = crosswalk.light
+				return p, false
+			}
+			p.crossing = crosswalk.id
+		}
+	}
+
+	// Walk towards the waypoint without passing it
+	timeStep := env.GetTimeStep()
+	step := math.Min(p.walkingSpeed*timeStep, p.position.DistanceTo(p.currentWaypoint))
+	p.speed = step / timeStep
+	direction := p.position.DirectionTo(p.currentWaypoint)
+	p.position = NewVector(p.position.x+direction.x*step, p.position.y+direction.y*step)
+
+	return p, false
+}
+
+// getNextWaypoint gets the pedestrian's next waypoint from the route.
+// If the pedestrian does not have another waypoint false is returned.
+func (p *Pedestrian) getNextWaypoint() bool {
+	if len(p.route) == 0 {
+		return false
+	}
+
+	// Any crosswalk being crossed ends at a waypoint
+	p.crossing = -1
+	p.lastWaypoint = p.currentWaypoint
+	p.currentWaypoint = p.route[0]
+	p.route = p.route[1:]
+	p.Logger.Debugf("CW: %v, Route: %v", p.currentWaypoint, p.route)
+
+	return true
+}
+
+// GetPosition retrives the pedestrian's current position.
+func (p Pedestrian) GetPosition() Vector {
+	return p.position
+}
+
+// GetID retrives the pedestrian's id.
+func (p Pedestrian) GetID() int {
+	return p.id
+}
+
+// GetLane returns 0 as pedestrians do not use lanes.
+func (p Pedestrian) GetLane() int {
+	return 0
+}
+
+// GetLanePosition returns the pedestrian's position.
+func (p Pedestrian) GetLanePosition() Vector {
+	return p.position
+}
+
+// GetCurrentWaypoint retrives the pedestrian's current target waypoint.
+func (p Pedestrian) GetCurrentWaypoint() Vector {
+	return p.currentWaypoint
+}
+
+// GetSpeed returns the current speed of the pedestrian.
+func (p Pedestrian) GetSpeed() float64 {
+	return p.speed
+}
+
+// GetRoute returns the current route of the pedestrian.
+func (p Pedestrian) GetRoute() []Vector {
+	return p.route
+}
+
+// GetPlannedRoute returns the full route the pedestrian was given.
+func (p Pedestrian) GetPlannedRoute() []Vector {
+	return p.plannedRoute
+}
+
+// GetCrossing returns the id of the crosswalk the pedestrian is on. If
+// they are not crossing false is returned.
+func (p Pedestrian) GetCrossing() (id int, crossing bool) {
+	return p.crossing, p.crossing >= 0
+}
+
+// GetWaitingFor returns the id of the light the pedestrian is waiting
+// for. If they are not waiting false is returned.
+func (p Pedestrian) GetWaitingFor() (id int, waiting bool) {
+	return p.waitingFor, p.waitingFor >= 0
+}
+
+// GetType returns the name of the type of agent, in this case "pedestrian"
+func (p Pedestrian) GetType() string {
+	return "pedestrian"
+}
+
+// GetInfo retuns information about the pedestrian in a json string.
+func (p Pedestrian) GetInfo() string {
+	type pedestrianInfo struct {
+		ID              int         `json:"id"`
+		Position        []float64   `json:"position"`
+		Speed           float64     `json:"speed"`
+		CurrentWaypoint []float64   `json:"currentWaypoint"`
+		Route           [][]float64 `json:"route"`
+		PlannedRoute    [][]float64 `json:"plannedRoute"`
+		Crossing        int         `json:"crossing"`
+		WaitingFor      int         `json:"waitingFor"`
+		Type            string      `json:"type"`
+	}
+
+	type response struct {
+		Success bool           `json:"success"`
+		Error   string         `json:"error"`
+		Info    pedestrianInfo `json:"info"`
+	}
+
+	pos := p.GetPosition()
+	cwp := p.GetCurrentWaypoint()
+	// Convert []Vector to [][]float64
+	var r [][]float64
+	for _, wp := range p.GetRoute() {
+		r = append(r, wp.ConvertToSlice())
+	}
+	var pr [][]float64
+	for _, wp := range p.GetPlannedRoute() {
+		pr = append(pr, wp.ConvertToSlice())
+	}
+
+	// Convert the infomation into a json string
+	var resp response
+	resp.Success = true
+	resp.Info = pedestrianInfo{
+		ID:              p.GetID(),
+		Position:        pos.ConvertToSlice(),
+		Speed:           p.GetSpeed(),
+		CurrentWaypoint: cwp.ConvertToSlice(),
+		Route:           r,
+		PlannedRoute:    pr,
+		Crossing:        p.crossing,
+		WaitingFor:      p.waitingFor,
+		Type:            p.GetType()}
+	jsonStr, _ := json.Marshal(resp)
+	return string(jsonStr)
+}
+
+// GetFrequency returns how often the pedestrian is spawned
+// in the simulation.
+func (p Pedestrian) GetFrequency() int {
+	return p.frequency
+}
+
+// SetID changes the value of the pedestrian's id.
+func (p Pedestrian) SetID(newID int) Agent {
+	p.id = newID
+	// Update Logger to display new ID
+	p.Logger = log.WithFields(log.Fields{
+		"package": "simulation",
+		"section": "pedestrian",
+		"id":      p.id})
+	return p
+}
+
+// SetFrequency changes the pedestrian's frequency.
+func (p Pedestrian) SetFrequency(freq int) Agent {
+	p.frequency = freq
+	return p
+}
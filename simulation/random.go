@@ -0,0 +1,49 @@
+package simulation
+
+import "math/rand"
+
+// randomSource is a splitmix64 random number generator used as the source
+// for a simulation's rand.Rand. Unlike the sources in math/rand its state
+// can be read and restored, so a simulation can be saved and continued.
+type randomSource struct {
+	state uint64
+}
+
+// newRandom returns a rand.Rand, and the source it draws from, seeded
+// with the given seed.
+func newRandom(seed int64) (*rand.Rand, *randomSource) {
+	src := &randomSource{}
+	src.Seed(seed)
+	return rand.New(src), src
+}
+
+// Seed resets the state of the source to the given seed.
+func (r *randomSource) Seed(seed int64) {
+	r.state = uint64(seed)
+}
+
+// agentRandom returns the random number generator an agent uses for a
+// tick. The generator is seeded from the tick's seed and the agent's id,
+// so the numbers an agent draws do not depend on when it acts.
+func agentRandom(tickSeed uint64, id int) *rand.Rand {
+	return rand.New(&randomSource{state: mix(tickSeed ^ mix(uint64(id)))})
+}
+
+// Uint64 returns the next pseudo-random 64-bit value.
+func (r *randomSource) Uint64() uint64 {
+	r.state += 0x9e3779b97f4a7c15
+	return mix(r.state)
+}
+
+// mix scrambles the bits of a value, so values close together give
+// values far apart.
+func mix(z uint64) uint64 {
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// Int63 returns the next pseudo-random non-negative 63-bit value.
+func (r *randomSource) Int63() int64 {
+	return int64(r.Uint64() >> 1)
+}
@@ -0,0 +1,194 @@
+package simulation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// AgentSpec is the information every type of agent is created with.
+type AgentSpec struct {
+	// Start is the position the agent starts at.
+	Start Vector
+	// Route stores the waypoints the agent must visit.
+	Route []Vector
+	// Frequency is how often the agent spawns in the simulation.
+	Frequency int
+}
+
+// AgentConstructor creates an agent from the spec and the parameters
+// decoded for its type. The parameters are a pointer to the value
+// returned by the type's NewParameters.
+type AgentConstructor func(spec AgentSpec, parameters interface{}) (Agent, error)
+
+// AgentCodec converts the agents of a registered type to and from the
+// forms used to save a simulation and to export it as a scenario. A type
+// without Encode and Decode can not be saved, and one without Describe
+// can not be exported.
+type AgentCodec struct {
+	// Encode converts an agent of the type into the json it is
+	// saved as.
+	Encode func(agent Agent) (json.RawMessage, error)
+	// Decode converts the json written by Encode back into the agent.
+	Decode func(data json.RawMessage) (Agent, error)
+	// Describe returns the spec and parameters that create the agent
+	// as it is now. The parameters are a pointer to the same type as
+	// returned by the type's NewParameters.
+	Describe func(agent Agent) (AgentSpec, interface{}, error)
+}
+
+// AgentParameter describes one of the parameters an agent type is
+// created with.
+type AgentParameter struct {
+	// Name is the json name of the parameter.
+	Name string `json:"name"`
+	// Type is the Go type of the parameter.
+	Type string `json:"type"`
+	// Description explains what the parameter does.
+	Description string `json:"description"`
+}
+
+// AgentType is a type of agent that can be created by name.
+type AgentType struct {
+	// Name is the name used to select the type.
+	Name string `json:"name"`
+	// Description explains what the agent does.
+	Description string `json:"description"`
+	// Parameters describes the parameters the type is created with.
+	Parameters []AgentParameter `json:"parameters"`
+
+	// newParameters returns a pointer to a struct the parameters
+	// are decoded into.
+	newParameters func() interface{}
+	// constructor creates the agent.
+	constructor AgentConstructor
+	// codec saves, loads and describes the agent.
+	codec AgentCodec
+}
+
+// agentTypes stores the registered agent types by name.
+var agentTypes = make(map[string]AgentType)
+
+// agentTypesMu guards agentTypes.
+var agentTypesMu sync.RWMutex
+
+// RegisterAgentType adds a type of agent that can be created with
+// NewAgent. newParameters must return a pointer to a struct, the json
+// tags of its fields naming the parameters and the description tags
+// explaining them. The codec lets agents of the type be saved and
+// exported. An error is returned if the name is already in use.
+func RegisterAgentType(name, description string, newParameters func() interface{}, constructor AgentConstructor, codec AgentCodec) error {
+	if name == "" {
+		return errors.New("an agent type needs a name")
+	}
+	if newParameters == nil || constructor == nil {
+		return fmt.Errorf("agent type %v needs parameters and a constructor", name)
+	}
+
+	parameters, err := describeParameters(newParameters())
+	if err != nil {
+		return fmt.Errorf("agent type %v: %v", name, err)
+	}
+
+	agentTypesMu.Lock()
+	defer agentTypesMu.Unlock()
+
+	if _, found := agentTypes[name]; found {
+		return fmt.Errorf("agent type already registered: %v", name)
+	}
+	agentTypes[name] = AgentType{
+		Name:          name,
+		Description:   description,
+		Parameters:    parameters,
+		newParameters: newParameters,
+		constructor:   constructor,
+		codec:         codec}
+	return nil
+}
+
+// mustRegisterAgentType registers one of the simulation's own agent
+// types, panicking if it can not be.
+func mustRegisterAgentType(name, description string, newParameters func() interface{}, constructor AgentConstructor, codec AgentCodec) {
+	if err := RegisterAgentType(name, description, newParameters, constructor, codec); err != nil {
+		panic(err)
+	}
+}
+
+// agentCodec returns the codec of the named agent type, false if no
+// type has the name.
+func agentCodec(name string) (AgentCodec, bool) {
+	agentTypesMu.RLock()
+	defer agentTypesMu.RUnlock()
+
+	agentType, found := agentTypes[name]
+	return agentType.codec, found
+}
+
+// describeParameters lists the exported fields of the struct pointed to
+// by parameters.
+func describeParameters(parameters interface{}) ([]AgentParameter, error) {
+	t := reflect.TypeOf(parameters)
+	if t == nil || t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
+		return nil, errors.New("parameters must be a pointer to a struct")
+	}
+	t = t.Elem()
+
+	var described []AgentParameter
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			// Unexported fields can not be decoded
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		described = append(described, AgentParameter{
+			Name:        name,
+			Type:        field.Type.String(),
+			Description: field.Tag.Get("description")})
+	}
+	return described, nil
+}
+
+// GetAgentTypes returns the registered agent types, sorted by name.
+func GetAgentTypes() []AgentType {
+	agentTypesMu.RLock()
+	defer agentTypesMu.RUnlock()
+
+	var types []AgentType
+	for _, t := range agentTypes {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i].Name < types[j].Name })
+	return types
+}
+
+// NewAgent creates an agent of the named type, decoding its parameters
+// from the json given. Fields in the json that are not parameters of the
+// type are ignored.
+func NewAgent(name string, spec AgentSpec, parameters json.RawMessage) (Agent, error) {
+	agentTypesMu.RLock()
+	agentType, found := agentTypes[name]
+	agentTypesMu.RUnlock()
+
+	if !found {
+		return nil, fmt.Errorf("no agent of that type found: %v", name)
+	}
+
+	decoded := agentType.newParameters()
+	if len(parameters) > 0 {
+		if err := json.Unmarshal(parameters, decoded); err != nil {
+			return nil, fmt.Errorf("invalid %v parameters: %v", name, err)
+		}
+	}
+	return agentType.constructor(spec, decoded)
+}
@@ -0,0 +1,165 @@
+package simulation
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// beacon is an agent that stays where it is added, used to check agent
+// types registered outside the simulation can be saved and exported.
+type beacon struct {
+	id        int
+	position  Vector
+	label     string
+	frequency int
+}
+
+// beaconParameters are the parameters a beacon is created with.
+type beaconParameters struct {
+	Label string `json:"label" description:"The name shown on the beacon."`
+}
+
+// beaconState is the saved form of a beacon.
+type beaconState struct {
+	ID       int    `json:"id"`
+	Position Vector `json:"position"`
+	Label    string `json:"label"`
+}
+
+func init() {
+	mustRegisterAgentType(
+		"beacon",
+		"A marker that stays where it is added.",
+		func() interface{} { return &beaconParameters{} },
+		func(spec AgentSpec, parameters interface{}) (Agent, error) {
+			return beacon{position: spec.Start, label: parameters.(*beaconParameters).Label}, nil
+		},
+		AgentCodec{
+			Encode: func(agent Agent) (json.RawMessage, error) {
+				b := agent.(beacon)
+				return json.Marshal(beaconState{ID: b.id, Position: b.position, Label: b.label})
+			},
+			Decode: func(data json.RawMessage) (Agent, error) {
+				var state beaconState
+				err := json.Unmarshal(data, &state)
+				return beacon{id: state.ID, position: state.Position, label: state.Label}, err
+			},
+			Describe: func(agent Agent) (AgentSpec, interface{}, error) {
+				b := agent.(beacon)
+				return AgentSpec{Start: b.position}, &beaconParameters{Label: b.label}, nil
+			}})
+}
+
+// The beacon does nothing each tick and never moves.
+
+func (b beacon) Act(agents []Agent, env Environment, rng *rand.Rand) (Agent, bool) {
+	return b, false
+}
+
+func (b beacon) GetPosition() Vector {
+	return b.position
+}
+
+func (b beacon) GetID() int {
+	return b.id
+}
+
+func (b beacon) GetLane() int {
+	return 0
+}
+
+func (b beacon) GetLanePosition() Vector {
+	return b.position
+}
+
+func (b beacon) GetCurrentWaypoint() Vector {
+	return b.position
+}
+
+func (b beacon) GetSpeed() float64 {
+	return 0
+}
+
+func (b beacon) GetRoute() []Vector {
+	return nil
+}
+
+func (b beacon) GetPlannedRoute() []Vector {
+	return nil
+}
+
+func (b beacon) GetType() string {
+	return "beacon"
+}
+
+func (b beacon) GetInfo() string {
+	return fmt.Sprintf(`{"label":%q}`, b.label)
+}
+
+func (b beacon) GetFrequency() int {
+	return b.frequency
+}
+
+func (b beacon) SetID(id int) Agent {
+	b.id = id
+	return b
+}
+
+func (b beacon) SetFrequency(freq int) Agent {
+	b.frequency = freq
+	return b
+}
+
+// TestRegisteredAgentCodec checks an agent of a registered type is saved,
+// loaded and exported as a scenario using its type's codec.
+func TestRegisteredAgentCodec(t *testing.T) {
+	sc, err := ReadScenario(strings.NewReader(`{
+	  "environment": {"nodes": [[0,0],[500,0]], "links": [{"from":0,"to":1}]},
+	  "agents": [
+	    {"type":"beacon","startLocation":[250,20],"label":"halfway"},
+	    {"type":"vehicle","origin":[0,0],"destination":[500,0],"maxSpeed":10,"acceleration":2,"deceleration":4}
+	  ]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewSimulationFromScenario(sc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.RunSteps(5)
+
+	want := beacon{id: s.GetAgents()[0].GetID(), position: NewVector(250, 20), label: "halfway"}
+	if got := s.GetAgents()[0]; got != want {
+		t.Fatalf("agent %+v, want %+v", got, want)
+	}
+
+	var buf bytes.Buffer
+	if err := s.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadSimulation(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := loaded.GetAgents()[0]; got != want {
+		t.Fatalf("loaded agent %+v, want %+v", got, want)
+	}
+
+	exported, err := s.GetScenario()
+	if err != nil {
+		t.Fatal(err)
+	}
+	recreated, err := NewSimulationFromScenario(exported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, ok := recreated.GetAgents()[0].(beacon)
+	if !ok || got.position != want.position || got.label != want.label {
+		t.Fatalf("exported agent %+v, want %+v", recreated.GetAgents()[0], want)
+	}
+}
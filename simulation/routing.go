@@ -0,0 +1,157 @@
+package simulation
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ShortestRoute is the route type that minimises the distance travelled.
+const ShortestRoute = "shortest"
+
+// FastestRoute is the route type that minimises the time taken to travel
+// the route, using the speed limit of each link.
+const FastestRoute = "fastest"
+
+// GetNearestNode returns the node closest to the given position. If the
+// environment has no nodes false is returned.
+func (e *Environment) GetNearestNode(pos Vector) (nearest Node, found bool) {
+	distance := math.MaxFloat64
+	for _, n := range e.nodes {
+		if d := pos.DistanceTo(n.position); d < distance {
+			distance = d
+			nearest = n
+			found = true
+		}
+	}
+	return
+}
+
+// FindRoute calculates a route from the origin to the destination passing
+// through each of the via points in order. Each point is matched to the
+// nearest node in the road network and the route between consecutive points
+// is found using A*. The routeType is either ShortestRoute or FastestRoute.
+// The positions of the nodes along the route are returned.
+func (e *Environment) FindRoute(origin, destination Vector, via []Vector, routeType string) ([]Vector, error) {
+	if routeType == "" {
+		routeType = ShortestRoute
+	}
+	if routeType != ShortestRoute && routeType != FastestRoute {
+		return nil, fmt.Errorf("unknown route type: %v", routeType)
+	}
+
+	// Match all the points to nodes in the network
+	var stops []Node
+	for _, pos := range append(append([]Vector{origin}, via...), destination) {
+		n, found := e.GetNearestNode(pos)
+		if !found {
+			return nil, errors.New("environment has no nodes to route between")
+		}
+		stops = append(stops, n)
+	}
+
+	// Join the routes between each of the stops
+	route := []Vector{stops[0].position}
+	for i := 0; i+1 < len(stops); i++ {
+		path, err := e.findPath(stops[i], stops[i+1], routeType)
+		if err != nil {
+			return nil, err
+		}
+		// The first node of the path is the last node of the route so far
+		route = append(route, path[1:]...)
+	}
+	return route, nil
+}
+
+// findPath uses A* to find the cheapest path between two nodes.
+func (e *Environment) findPath(start, goal Node, routeType string) ([]Vector, error) {
+	// The heuristic must never overestimate the cost, so for the fastest
+	// route the straight line distance is travelled at the highest speed
+	// found in the network.
+	heuristicSpeed := 1.0
+	if routeType == FastestRoute {
+		heuristicSpeed = e.linkSpeed(Link{})
+		for _, l := range e.links {
+			heuristicSpeed = math.Max(heuristicSpeed, e.linkSpeed(l))
+		}
+	}
+
+	costs := make([]float64, len(e.nodes))
+	previous := make([]int, len(e.nodes))
+	visited := make([]bool, len(e.nodes))
+	for i := range costs {
+		costs[i] = math.Inf(1)
+		previous[i] = -1
+	}
+	costs[start.id] = 0
+
+	open := &routeQueue{}
+	heap.Push(open, routeItem{node: start.id, priority: start.position.DistanceTo(goal.position) / heuristicSpeed})
+
+	for open.Len() > 0 {
+		current := heap.Pop(open).(routeItem).node
+		if current == goal.id {
+			break
+		}
+		if visited[current] {
+			continue
+		}
+		visited[current] = true
+
+		for _, id := range e.outgoing[current] {
+			link := e.links[id]
+			cost := costs[current] + link.length
+			if routeType == FastestRoute {
+				cost = costs[current] + link.length/e.linkSpeed(link)
+			}
+
+			if cost < costs[link.to] {
+				costs[link.to] = cost
+				previous[link.to] = current
+				estimate := e.nodes[link.to].position.DistanceTo(goal.position) / heuristicSpeed
+				heap.Push(open, routeItem{node: link.to, priority: cost + estimate})
+			}
+		}
+	}
+
+	if math.IsInf(costs[goal.id], 1) {
+		return nil, fmt.Errorf("no route found between %v and %v", start.position, goal.position)
+	}
+
+	// Walk back from the goal to create the path
+	var path []Vector
+	for n := goal.id; n != -1; n = previous[n] {
+		path = append([]Vector{e.nodes[n].position}, path...)
+	}
+	return path, nil
+}
+
+// linkSpeed returns the speed used to travel a link when finding the
+// fastest route.
+func (e *Environment) linkSpeed(l Link) float64 {
+	if l.speedLimit > 0 {
+		return l.speedLimit
+	}
+	return defaultSpeedLimit
+}
+
+// routeItem is a node waiting to be explored by the A* search.
+type routeItem struct {
+	node     int
+	priority float64
+}
+
+// routeQueue is a priority queue of routeItems, implementing heap.Interface.
+type routeQueue []routeItem
+
+func (q routeQueue) Len() int            { return len(q) }
+func (q routeQueue) Less(i, j int) bool  { return q[i].priority < q[j].priority }
+func (q routeQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *routeQueue) Push(x interface{}) { *q = append(*q, x.(routeItem)) }
+func (q *routeQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
@@ -0,0 +1,764 @@
+package simulation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ghodss/yaml"
+)
+
+// JSONFormat writes scenarios as indented JSON.
+const JSONFormat = "json"
+
+// YAMLFormat writes scenarios as YAML.
+const YAMLFormat = "yaml"
+
+// Scenario describes how a simulation is set up: the road network, the
+// lights and signals, the agents and the demand, and how long it runs
+// for. Scenarios are read from JSON or YAML, the fields using the same
+// names as the endpoints that add each part to a running simulation.
+type Scenario struct {
+	// Environment is the road network the simulation runs on.
+	Environment ScenarioEnvironment `json:"environment"`
+	// StartTime is the time of day, in seconds after midnight, the
+	// simulation starts at.
+	StartTime int `json:"startTime,omitempty"`
+	// Seed is used to seed the simulation's random number generator,
+	// if nil a seed is chosen.
+	Seed *int64 `json:"seed,omitempty"`
+	// TimeStep is the number of seconds each tick simulates, 0 uses
+	// one second.
+	TimeStep float64 `json:"timeStep,omitempty"`
+	// Duration is the number of seconds the scenario should be run
+	// for, 0 if it is not set.
+	Duration float64 `json:"duration,omitempty"`
+	// RecordTrajectories is true if the position of every agent should
+	// be recorded at each tick.
+	RecordTrajectories bool `json:"recordTrajectories,omitempty"`
+	// HaltOnCollision is true if the simulation should stop running
+	// when vehicles collide.
+	HaltOnCollision bool `json:"haltOnCollision,omitempty"`
+	// Parameters control how the agents behave, if nil the
+	// DefaultParameters are used.
+	Parameters *Parameters `json:"parameters,omitempty"`
+	// Lights are the traffic lights, their ids being their index.
+	Lights []ScenarioLight `json:"lights,omitempty"`
+	// Signals are the controllers changing the lights, their ids being
+	// their index.
+	Signals []ScenarioSignal `json:"signals,omitempty"`
+	// Detectors are the virtual sensors placed in the environment.
+	Detectors []ScenarioDetector `json:"detectors,omitempty"`
+	// Crosswalks are the places pedestrians cross the roads.
+	Crosswalks []ScenarioCrosswalk `json:"crosswalks,omitempty"`
+	// Junctions are the priority rules used at nodes without signals.
+	Junctions []ScenarioJunction `json:"junctions,omitempty"`
+	// DefaultJunction is the control used where roads meet that have
+	// no signals or junction of their own.
+	DefaultJunction string `json:"defaultJunction,omitempty"`
+	// BusStops are the places buses stop, their ids being their index.
+	BusStops []ScenarioBusStop `json:"busStops,omitempty"`
+	// BusLines are the routes buses are sent along.
+	BusLines []ScenarioBusLine `json:"busLines,omitempty"`
+	// Demands are the origin-destination matrices vehicles are
+	// generated from.
+	Demands []ScenarioDemand `json:"demands,omitempty"`
+	// Agents are the agents added when the simulation is created, in
+	// the form read by AddScenarioAgent. Agents with a frequency are
+	// spawn templates, added again every frequency ticks.
+	Agents []json.RawMessage `json:"agents,omitempty"`
+}
+
+// ScenarioEnvironment is the road network of a scenario, either read
+// from a shape file or given as nodes and the links between them.
+type ScenarioEnvironment struct {
+	// Shapefile is the path to the shape file storing the network.
+	Shapefile string `json:"shapefile,omitempty"`
+	// Nodes are the points where the links meet.
+	Nodes []Vector `json:"nodes,omitempty"`
+	// Links are the directed sections of road between the nodes.
+	Links []ScenarioLink `json:"links,omitempty"`
+}
+
+// ScenarioLink is a directed section of road between two of a
+// scenario's nodes.
+type ScenarioLink struct {
+	// From and To are the indexes of the nodes the link joins.
+	From int `json:"from"`
+	To   int `json:"to"`
+	// Oneway is true if traffic can only travel from From to To.
+	Oneway bool `json:"oneway,omitempty"`
+	// Lanes is the number of lanes in the direction of the link.
+	Lanes int `json:"lanes,omitempty"`
+	// SpeedLimit is the speed limit in metres per second, 0 for none.
+	SpeedLimit float64 `json:"speedLimit,omitempty"`
+	// Attributes are the attributes the link was read with.
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// ScenarioLight is a traffic light in a scenario.
+type ScenarioLight struct {
+	Position Vector `json:"position"`
+	// Stop is true if the light starts on red.
+	Stop bool `json:"stop,omitempty"`
+}
+
+// ScenarioSignal is a signal controller in a scenario.
+type ScenarioSignal struct {
+	Plans    []ScenarioPlan  `json:"plans"`
+	Schedule []ScheduleEntry `json:"schedule,omitempty"`
+	// Mode is how the controller chooses its phases, empty for
+	// FixedTimeControl. The settings after it are only used by the
+	// responsive modes, 0 using the defaults.
+	Mode              string  `json:"mode,omitempty"`
+	MinGreen          int     `json:"minGreen,omitempty"`
+	MaxGreen          int     `json:"maxGreen,omitempty"`
+	Gap               float64 `json:"gap,omitempty"`
+	DetectionDistance float64 `json:"detectionDistance,omitempty"`
+}
+
+// ScenarioPlan is a signal plan in a scenario.
+type ScenarioPlan struct {
+	Name        string          `json:"name"`
+	CycleLength int             `json:"cycleLength,omitempty"`
+	Offset      int             `json:"offset,omitempty"`
+	Phases      []ScenarioPhase `json:"phases"`
+}
+
+// ScenarioPhase is a phase of a signal plan in a scenario.
+type ScenarioPhase struct {
+	Lights []int `json:"lights"`
+	Green  int   `json:"green"`
+	Amber  int   `json:"amber,omitempty"`
+	AllRed int   `json:"allRed,omitempty"`
+}
+
+// ScenarioDetector is a detector in a scenario. Point detectors are
+// placed at Position, area detectors cover the rectangle between Corner
+// and Opposite.
+type ScenarioDetector struct {
+	Type     string  `json:"type"`
+	Position *Vector `json:"position,omitempty"`
+	Length   float64 `json:"length,omitempty"`
+	Corner   *Vector `json:"corner,omitempty"`
+	Opposite *Vector `json:"opposite,omitempty"`
+	Interval int     `json:"interval,omitempty"`
+}
+
+// ScenarioCrosswalk is a crosswalk in a scenario.
+type ScenarioCrosswalk struct {
+	Start Vector  `json:"start"`
+	End   Vector  `json:"end"`
+	Width float64 `json:"width,omitempty"`
+	// Light is the id of the light controlling the crosswalk, nil if
+	// it has none.
+	Light *int `json:"light,omitempty"`
+}
+
+// ScenarioJunction is a junction in a scenario.
+type ScenarioJunction struct {
+	Position    Vector   `json:"position"`
+	Control     string   `json:"control"`
+	Major       []Vector `json:"major,omitempty"`
+	CriticalGap float64  `json:"criticalGap,omitempty"`
+}
+
+// ScenarioBusStop is a bus stop in a scenario.
+type ScenarioBusStop struct {
+	Position    Vector  `json:"position"`
+	ArrivalRate float64 `json:"arrivalRate,omitempty"`
+}
+
+// ScenarioVehicle describes the vehicles created by a bus line or a
+// demand. Sizes of 0 use the defaults.
+type ScenarioVehicle struct {
+	MaxSpeed        float64            `json:"maxSpeed,omitempty"`
+	Acceleration    float64            `json:"acceleration,omitempty"`
+	Deceleration    float64            `json:"deceleration,omitempty"`
+	Model           string             `json:"model,omitempty"`
+	ModelParameters map[string]float64 `json:"modelParameters,omitempty"`
+	Length          float64            `json:"length,omitempty"`
+	Width           float64            `json:"width,omitempty"`
+}
+
+// ScenarioBusLine is a bus line in a scenario. The buses are
+// defaultBusLength by defaultBusWidth unless a size is given.
+type ScenarioBusLine struct {
+	Name     string      `json:"name"`
+	Route    []Vector    `json:"route"`
+	Stops    []int       `json:"stops,omitempty"`
+	Times    []int       `json:"times,omitempty"`
+	Schedule BusSchedule `json:"schedule"`
+	ScenarioVehicle
+}
+
+// ScenarioDemand is an origin-destination matrix in a scenario.
+type ScenarioDemand struct {
+	Zones     map[string][]Vector `json:"zones"`
+	Flows     []ODFlow            `json:"flows"`
+	Process   string              `json:"process,omitempty"`
+	Profile   DemandProfile       `json:"profile,omitempty"`
+	RouteType string              `json:"routeType,omitempty"`
+	ScenarioVehicle
+}
+
+// ScenarioAgent is the part of an agent in a scenario common to every
+// type of agent. The parameters of the agent's type are read from the
+// same object.
+type ScenarioAgent struct {
+	// Type is the registered type of the agent.
+	Type string `json:"type"`
+	// StartLocation is where the agent starts, the origin if nil.
+	StartLocation *Vector `json:"startLocation,omitempty"`
+	// Route stores the waypoints the agent must visit. If empty a route
+	// is found from the origin to the destination, through the via
+	// points, using the route type.
+	Route       []Vector `json:"route,omitempty"`
+	Origin      *Vector  `json:"origin,omitempty"`
+	Destination *Vector  `json:"destination,omitempty"`
+	Via         []Vector `json:"via,omitempty"`
+	RouteType   string   `json:"routeType,omitempty"`
+	// Frequency is how often, in ticks, the agent spawns.
+	Frequency int `json:"frequency,omitempty"`
+}
+
+// ReadScenario decodes a scenario written in JSON or YAML. Parameters
+// missing from the scenario use the DefaultParameters.
+func ReadScenario(r io.Reader) (Scenario, error) {
+	parameters := DefaultParameters()
+	scenario := Scenario{Parameters: &parameters}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return scenario, err
+	}
+	// JSON is also YAML so both are read the same way
+	err = yaml.Unmarshal(data, &scenario)
+	return scenario, err
+}
+
+// ReadScenarioFile decodes a scenario from a JSON or YAML file.
+func ReadScenarioFile(fileName string) (Scenario, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return Scenario{}, err
+	}
+	defer f.Close()
+
+	return ReadScenario(f)
+}
+
+// Write encodes the scenario to w in the given format, either
+// JSONFormat or YAMLFormat.
+func (sc Scenario) Write(w io.Writer, format string) error {
+	var data []byte
+	var err error
+	switch format {
+	case JSONFormat:
+		data, err = json.MarshalIndent(sc, "", "  ")
+		data = append(data, '\n')
+	case YAMLFormat:
+		data, err = yaml.Marshal(sc)
+	default:
+		return fmt.Errorf("unknown scenario format: %v", format)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+// WriteFile writes the scenario to a file in the given format. If no
+// format is given YAMLFormat is used for files ending in .yaml or .yml,
+// and JSONFormat otherwise.
+func (sc Scenario) WriteFile(fileName, format string) error {
+	if format == "" {
+		format = JSONFormat
+		switch strings.ToLower(filepath.Ext(fileName)) {
+		case ".yaml", ".yml":
+			format = YAMLFormat
+		}
+	}
+
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+
+	err = sc.Write(f, format)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// NewSimulationFromScenario creates a simulation set up as the scenario
+// describes. The scenario's duration is not used, it is up to the caller
+// how long the simulation runs for.
+func NewSimulationFromScenario(sc Scenario) (*Simulation, error) {
+	env := NewEnvironment()
+	if err := sc.Environment.build(&env); err != nil {
+		return nil, err
+	}
+	for _, l := range sc.Lights {
+		env.AddLight(l.Position, l.Stop)
+	}
+
+	sim := NewSimulation(env)
+	sim.SetStartTime(sc.StartTime)
+	if sc.Seed != nil {
+		sim.SetSeed(*sc.Seed)
+	}
+	sim.SetRecordTrajectories(sc.RecordTrajectories)
+	sim.SetHaltOnCollision(sc.HaltOnCollision)
+	if sc.Parameters != nil {
+		if err := sim.SetParameters(*sc.Parameters); err != nil {
+			return nil, err
+		}
+	}
+	if sc.TimeStep != 0 {
+		if err := sim.SetTimeStep(sc.TimeStep); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, signal := range sc.Signals {
+		var plans []SignalPlan
+		for _, p := range signal.Plans {
+			var phases []Phase
+			for _, phase := range p.Phases {
+				phases = append(phases, NewPhase(phase.Lights, phase.Green, phase.Amber, phase.AllRed))
+			}
+			plan, err := NewSignalPlan(p.Name, phases, p.CycleLength, p.Offset)
+			if err != nil {
+				return nil, fmt.Errorf("signal %v: %v", i, err)
+			}
+			plans = append(plans, plan)
+		}
+		if err := sim.AddSignalController(plans, signal.Schedule); err != nil {
+			return nil, fmt.Errorf("signal %v: %v", i, err)
+		}
+		if signal.Mode != "" {
+			err := sim.SetSignalMode(i, signal.Mode, signal.MinGreen, signal.MaxGreen, signal.Gap, signal.DetectionDistance)
+			if err != nil {
+				return nil, fmt.Errorf("signal %v: %v", i, err)
+			}
+		}
+	}
+
+	for i, d := range sc.Detectors {
+		var err error
+		switch {
+		case d.Type == PointDetector && d.Position != nil:
+			err = sim.AddPointDetector(*d.Position, d.Length, d.Interval)
+		case d.Type == PointDetector:
+			err = errors.New("point detectors need a position")
+		case d.Type == AreaDetector && d.Corner != nil && d.Opposite != nil:
+			err = sim.AddAreaDetector(*d.Corner, *d.Opposite, d.Interval)
+		case d.Type == AreaDetector:
+			err = errors.New("area detectors need a corner and its opposite")
+		default:
+			err = fmt.Errorf("unknown detector type: %v", d.Type)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("detector %v: %v", i, err)
+		}
+	}
+
+	for i, c := range sc.Crosswalks {
+		light := -1
+		if c.Light != nil {
+			light = *c.Light
+		}
+		if err := sim.AddCrosswalk(c.Start, c.End, c.Width, light); err != nil {
+			return nil, fmt.Errorf("crosswalk %v: %v", i, err)
+		}
+	}
+
+	for i, j := range sc.Junctions {
+		if err := sim.AddJunction(j.Position, j.Control, j.Major, j.CriticalGap); err != nil {
+			return nil, fmt.Errorf("junction %v: %v", i, err)
+		}
+	}
+	if err := sim.SetDefaultJunction(sc.DefaultJunction); err != nil {
+		return nil, err
+	}
+
+	for i, b := range sc.BusStops {
+		if err := sim.AddBusStop(b.Position, b.ArrivalRate); err != nil {
+			return nil, fmt.Errorf("bus stop %v: %v", i, err)
+		}
+	}
+	for i, line := range sc.BusLines {
+		if err := sim.AddScenarioBusLine(line); err != nil {
+			return nil, fmt.Errorf("bus line %v: %v", i, err)
+		}
+	}
+	for i, demand := range sc.Demands {
+		if err := sim.AddScenarioDemand(demand); err != nil {
+			return nil, fmt.Errorf("demand %v: %v", i, err)
+		}
+	}
+	for i, agent := range sc.Agents {
+		if err := sim.AddScenarioAgent(agent); err != nil {
+			return nil, fmt.Errorf("agent %v: %v", i, err)
+		}
+	}
+
+	return sim, nil
+}
+
+// build adds the road network to the environment.
+func (se ScenarioEnvironment) build(env *Environment) error {
+	if se.Shapefile != "" {
+		if len(se.Nodes) > 0 || len(se.Links) > 0 {
+			return errors.New("an environment is either a shape file or nodes and links, not both")
+		}
+		return env.ReadShapefile(se.Shapefile)
+	}
+
+	var nodes []Node
+	for _, pos := range se.Nodes {
+		nodes = append(nodes, env.addNode(pos))
+	}
+	for _, l := range se.Links {
+		if l.From < 0 || l.From >= len(nodes) || l.To < 0 || l.To >= len(nodes) {
+			return fmt.Errorf("link between unknown nodes: %v - %v", l.From, l.To)
+		}
+		lanes := l.Lanes
+		if lanes < 1 {
+			lanes = 1
+		}
+		env.addLink(nodes[l.From], nodes[l.To], l.Oneway, lanes, l.SpeedLimit, l.Attributes)
+	}
+	return nil
+}
+
+// newVehicle creates the vehicle the buses or demand copy. Vehicles
+// without a size are the size given.
+func (sv ScenarioVehicle) newVehicle(length, width float64) (Vehicle, error) {
+	if sv.Length < 0 || sv.Width < 0 {
+		return Vehicle{}, errors.New("vehicle size can not be negative")
+	}
+	if sv.Acceleration <= 0 {
+		return Vehicle{}, fmt.Errorf("vehicle acceleration must be more than 0: %v", sv.Acceleration)
+	}
+	model, err := NewCarFollowingModel(sv.Model, sv.ModelParameters)
+	if err != nil {
+		return Vehicle{}, err
+	}
+
+	vehicle := NewVehicle(-1, NewVector(0, 0), 0, sv.MaxSpeed, sv.Acceleration, sv.Deceleration, nil, 0)
+	return vehicle.SetModel(model).SetSize(length, width).SetSize(sv.Length, sv.Width), nil
+}
+
+// scenarioVehicle converts the vehicle copied by a bus line or demand
+// into its scenario form.
+func scenarioVehicle(v Vehicle) ScenarioVehicle {
+	model := v.getModel()
+	return ScenarioVehicle{
+		MaxSpeed:        v.maxSpeed,
+		Acceleration:    v.acceleration,
+		Deceleration:    v.deceleration,
+		Model:           model.GetName(),
+		ModelParameters: model.GetParameters(),
+		Length:          v.length,
+		Width:           v.width}
+}
+
+// AddScenarioBusLine adds a bus line in its scenario form to the
+// simulation.
+func (s *Simulation) AddScenarioBusLine(line ScenarioBusLine) error {
+	vehicle, err := line.newVehicle(defaultBusLength, defaultBusWidth)
+	if err != nil {
+		return err
+	}
+	// The buses copy the vehicle's speeds, model and size
+	return s.AddBusLine(line.Name, line.Route, line.Stops, line.Times, line.Schedule, vehicle)
+}
+
+// AddScenarioDemand adds a demand in its scenario form to the simulation.
+func (s *Simulation) AddScenarioDemand(demand ScenarioDemand) error {
+	vehicle, err := demand.newVehicle(defaultVehicleLength, defaultVehicleWidth)
+	if err != nil {
+		return err
+	}
+	// The vehicles copy the vehicle's speeds, model and size
+	return s.AddDemand(demand.Zones, demand.Flows, demand.Process, demand.Profile, demand.RouteType, vehicle)
+}
+
+// AddScenarioAgent creates an agent from its scenario form and adds it to
+// the simulation. The object is read as a ScenarioAgent and as the
+// parameters of the agent's type.
+func (s *Simulation) AddScenarioAgent(raw json.RawMessage) error {
+	var agent ScenarioAgent
+	if err := json.Unmarshal(raw, &agent); err != nil {
+		return fmt.Errorf("unable to read agent: %v", err)
+	}
+
+	route := agent.Route
+	// If no route is given find one from the origin to the destination
+	if len(route) == 0 && agent.Origin != nil && agent.Destination != nil {
+		var err error
+		route, err = s.FindRoute(*agent.Origin, *agent.Destination, agent.Via, agent.RouteType)
+		if err != nil {
+			return fmt.Errorf("unable to find route: %v", err)
+		}
+
+		// Start at the origin if no start location is given
+		if agent.StartLocation == nil {
+			agent.StartLocation = agent.Origin
+		}
+	}
+	if agent.StartLocation == nil {
+		return errors.New("start location or origin required")
+	}
+
+	spec := AgentSpec{
+		Start:     *agent.StartLocation,
+		Route:     route,
+		Frequency: agent.Frequency}
+	newAgent, err := NewAgent(agent.Type, spec, raw)
+	if err != nil {
+		return err
+	}
+
+	s.AddAgent(newAgent)
+	return nil
+}
+
+// GetScenario returns a scenario that sets up a simulation like this
+// one. The network is given as nodes and links, and the agents are
+// where they are now. Buses are left to their lines, and agents of
+// types the simulation does not know how to describe cause an error.
+func (s *Simulation) GetScenario() (Scenario, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seed := s.seed
+	parameters := s.environment.GetParameters()
+	sc := Scenario{
+		StartTime:          s.startTime,
+		Seed:               &seed,
+		TimeStep:           s.environment.GetTimeStep(),
+		RecordTrajectories: s.recordTrajectories,
+		HaltOnCollision:    s.haltOnCollision,
+		Parameters:         &parameters}
+	s.environment.scenario(&sc)
+
+	for _, l := range s.lines {
+		line := ScenarioBusLine{
+			Name:            l.name,
+			Route:           l.route,
+			Schedule:        l.schedule,
+			ScenarioVehicle: scenarioVehicle(l.vehicle)}
+		timetabled := false
+		for _, stop := range l.stops {
+			line.Stops = append(line.Stops, stop.stop)
+			line.Times = append(line.Times, stop.time)
+			timetabled = timetabled || stop.time >= 0
+		}
+		if !timetabled {
+			line.Times = nil
+		}
+		sc.BusLines = append(sc.BusLines, line)
+	}
+
+	for _, d := range s.demands {
+		sc.Demands = append(sc.Demands, ScenarioDemand{
+			Zones:           d.zones,
+			Flows:           d.flows,
+			Process:         d.process,
+			Profile:         d.profile,
+			RouteType:       d.routeType,
+			ScenarioVehicle: scenarioVehicle(d.vehicle)})
+	}
+
+	// The first of each spawned agent is added with its template, so
+	// the templates are described in place of the agents that spawn.
+	// Templates whose first agent has left are described at the end.
+	templates := s.agentsToSpawn
+	for _, agent := range s.agents {
+		if _, bus := agent.(Bus); bus {
+			continue
+		}
+		if agent.GetFrequency() > 0 {
+			if len(templates) == 0 {
+				continue
+			}
+			agent, templates = templates[0], templates[1:]
+		}
+		raw, err := scenarioAgent(agent)
+		if err != nil {
+			return sc, err
+		}
+		sc.Agents = append(sc.Agents, raw)
+	}
+	for _, agent := range templates {
+		raw, err := scenarioAgent(agent)
+		if err != nil {
+			return sc, err
+		}
+		sc.Agents = append(sc.Agents, raw)
+	}
+
+	return sc, nil
+}
+
+// scenarioAgent converts an agent into its scenario form, using the
+// codec of its type to describe it.
+func scenarioAgent(agent Agent) (json.RawMessage, error) {
+	codec, found := agentCodec(agent.GetType())
+	if !found || codec.Describe == nil {
+		return nil, fmt.Errorf("unable to describe agent of type: %v", agent.GetType())
+	}
+	spec, parameters, err := codec.Describe(agent)
+	if err != nil {
+		return nil, err
+	}
+
+	// The parameters are read first so the spec is kept if a
+	// parameter has the same name as one of its fields
+	described := make(map[string]json.RawMessage)
+	for _, part := range []interface{}{
+		parameters,
+		ScenarioAgent{
+			Type:          agent.GetType(),
+			StartLocation: &spec.Start,
+			Route:         spec.Route,
+			Frequency:     spec.Frequency}} {
+		data, err := json.Marshal(part)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &described); err != nil {
+			return nil, fmt.Errorf("unable to describe agent of type %v: %v", agent.GetType(), err)
+		}
+	}
+	return json.Marshal(described)
+}
+
+// describeVehicle returns the spec and parameters that create the vehicle
+// as it is now.
+func describeVehicle(agent Agent) (AgentSpec, interface{}, error) {
+	a, ok := agent.(Vehicle)
+	if !ok {
+		return AgentSpec{}, nil, fmt.Errorf("expected a vehicle not: %v", agent.GetType())
+	}
+	model := a.getModel()
+	return remainingSpec(a.position, a.currentWaypoint, a.route, a.frequency), &vehicleParameters{
+		StartSpeed:      a.speed,
+		MaxSpeed:        a.maxSpeed,
+		Acceleration:    a.acceleration,
+		Deceleration:    a.deceleration,
+		Lane:            a.lane,
+		Model:           model.GetName(),
+		ModelParameters: model.GetParameters(),
+		Length:          a.length,
+		Width:           a.width}, nil
+}
+
+// describePedestrian returns the spec and parameters that create the
+// pedestrian as it is now.
+func describePedestrian(agent Agent) (AgentSpec, interface{}, error) {
+	a, ok := agent.(Pedestrian)
+	if !ok {
+		return AgentSpec{}, nil, fmt.Errorf("expected a pedestrian not: %v", agent.GetType())
+	}
+	return remainingSpec(a.position, a.currentWaypoint, a.route, a.frequency),
+		&pedestrianParameters{WalkingSpeed: a.walkingSpeed}, nil
+}
+
+// remainingSpec returns the spec of an agent starting where it is now and
+// visiting the waypoints it still has to visit.
+func remainingSpec(position, currentWaypoint Vector, route []Vector, frequency int) AgentSpec {
+	return AgentSpec{
+		Start:     position,
+		Route:     append([]Vector{currentWaypoint}, route...),
+		Frequency: frequency}
+}
+
+// scenario adds the environment's network, lights, signals, detectors,
+// crosswalks, junctions and bus stops to the scenario.
+func (e *Environment) scenario(sc *Scenario) {
+	for _, n := range e.nodes {
+		sc.Environment.Nodes = append(sc.Environment.Nodes, n.position)
+	}
+	for _, l := range e.links {
+		sc.Environment.Links = append(sc.Environment.Links, ScenarioLink{
+			From:       l.from,
+			To:         l.to,
+			Oneway:     l.oneway,
+			Lanes:      l.lanes,
+			SpeedLimit: l.speedLimit,
+			Attributes: l.attributes})
+	}
+	for _, l := range e.lights {
+		sc.Lights = append(sc.Lights, ScenarioLight{Position: l.position, Stop: l.GetStop()})
+	}
+
+	for _, c := range e.signals {
+		signal := ScenarioSignal{Schedule: c.schedule}
+		if c.mode != FixedTimeControl {
+			signal.Mode = c.mode
+			signal.MinGreen = c.minGreen
+			signal.MaxGreen = c.maxGreen
+			signal.Gap = c.gap
+			signal.DetectionDistance = c.detectionDistance
+		}
+		for _, p := range c.plans {
+			plan := ScenarioPlan{Name: p.name, CycleLength: p.cycleLength, Offset: p.offset}
+			for _, phase := range p.phases {
+				plan.Phases = append(plan.Phases, ScenarioPhase{
+					Lights: phase.lights,
+					Green:  phase.green,
+					Amber:  phase.amber,
+					AllRed: phase.allRed})
+			}
+			signal.Plans = append(signal.Plans, plan)
+		}
+		sc.Signals = append(sc.Signals, signal)
+	}
+
+	for _, d := range e.detectors {
+		detector := ScenarioDetector{Type: d.kind, Interval: d.interval}
+		if d.kind == AreaDetector {
+			corner, opposite := d.min, d.max
+			detector.Corner = &corner
+			detector.Opposite = &opposite
+		} else {
+			position := d.position
+			detector.Position = &position
+			detector.Length = d.length
+		}
+		sc.Detectors = append(sc.Detectors, detector)
+	}
+
+	for _, c := range e.crosswalks {
+		crosswalk := ScenarioCrosswalk{Start: c.start, End: c.end, Width: c.width}
+		if c.light >= 0 {
+			light := c.light
+			crosswalk.Light = &light
+		}
+		sc.Crosswalks = append(sc.Crosswalks, crosswalk)
+	}
+
+	for _, j := range e.junctions {
+		sc.Junctions = append(sc.Junctions, ScenarioJunction{
+			Position:    j.position,
+			Control:     j.control,
+			Major:       j.major,
+			CriticalGap: j.criticalGap})
+	}
+	sc.DefaultJunction = e.defaultJunction
+
+	for _, b := range e.busStops {
+		sc.BusStops = append(sc.BusStops, ScenarioBusStop{Position: b.position, ArrivalRate: b.arrivalRate})
+	}
+}
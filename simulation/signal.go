@@ -0,0 +1,558 @@
+package simulation
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// GreenSignal is the state of a light that allows agents to pass.
+const GreenSignal = "green"
+
+// AmberSignal is the state of a light that is about to turn red.
+const AmberSignal = "amber"
+
+// RedSignal is the state of a light that agents must stop at.
+const RedSignal = "red"
+
+// FixedTimeControl is the signal control mode that runs each plan's
+// phases for the durations given.
+const FixedTimeControl = "fixed"
+
+// ActuatedControl is the signal control mode that extends green while
+// vehicles keep arriving, ending it when the gap between vehicles is
+// too long (gap out) or the maximum green is reached (max out). Phases
+// with no vehicles waiting are skipped.
+const ActuatedControl = "actuated"
+
+// MaxPressureControl is the adaptive signal control mode that gives green
+// to the phase with the most vehicles queued at its lights.
+const MaxPressureControl = "maxpressure"
+
+// secondsPerDay is used to wrap the simulation time into a time of day.
+const secondsPerDay = 24 * 60 * 60
+
+// Phase is a stage of a signal plan in which a group of lights are
+// given right of way.
+type Phase struct {
+	// lights stores the ids of the lights that show green
+	// during the phase.
+	lights []int
+	// green is the number of seconds the lights show green.
+	green int
+	// amber is the number of seconds the lights show amber after green.
+	amber int
+	// allRed is the number of seconds every light shows red after amber,
+	// before the next phase starts.
+	allRed int
+}
+
+// NewPhase returns a Phase with the specified paramaters.
+func NewPhase(lights []int, green, amber, allRed int) Phase {
+	var p Phase
+	p.lights = lights
+	p.green = green
+	p.amber = amber
+	p.allRed = allRed
+	return p
+}
+
+// GetLights returns the ids of the lights that show green during the phase.
+func (p *Phase) GetLights() []int {
+	return p.lights
+}
+
+// GetDuration returns the total length of the phase in seconds.
+func (p *Phase) GetDuration() int {
+	return p.green + p.amber + p.allRed
+}
+
+// hasLight returns true if the light is given right of way by the phase.
+func (p *Phase) hasLight(id int) bool {
+	for _, l := range p.lights {
+		if l == id {
+			return true
+		}
+	}
+	return false
+}
+
+// stateAt returns the state of the phase's lights a number of seconds
+// after the phase started.
+func (p *Phase) stateAt(elapsed int) string {
+	if elapsed < p.green {
+		return GreenSignal
+	}
+	if elapsed < p.green+p.amber {
+		return AmberSignal
+	}
+	return RedSignal
+}
+
+// SignalPlan is a fixed time plan that cycles through a list of phases.
+type SignalPlan struct {
+	// name is used to refer to the plan in a schedule.
+	name string
+	// phases are the stages of the plan in the order they run.
+	phases []Phase
+	// cycleLength is the time in seconds it takes to run all the
+	// phases. Any time left after the last phase is all red.
+	cycleLength int
+	// offset is the number of seconds after midnight the first
+	// phase of a cycle starts. This is used to coordinate plans.
+	offset int
+}
+
+// NewSignalPlan returns a SignalPlan with the specified paramaters. If the
+// cycle length is 0 it is set to the total length of the phases.
+func NewSignalPlan(name string, phases []Phase, cycleLength, offset int) (SignalPlan, error) {
+	var p SignalPlan
+
+	if len(phases) == 0 {
+		return p, errors.New("a signal plan needs at least one phase")
+	}
+
+	total := 0
+	for _, phase := range phases {
+		if phase.green < 0 || phase.amber < 0 || phase.allRed < 0 {
+			return p, errors.New("phase durations can not be negative")
+		}
+		total += phase.GetDuration()
+	}
+	if total == 0 {
+		return p, errors.New("a signal plan can not have a length of 0")
+	}
+	if cycleLength == 0 {
+		cycleLength = total
+	}
+	if cycleLength < total {
+		return p, fmt.Errorf("cycle length %v is shorter than the phases %v", cycleLength, total)
+	}
+
+	p.name = name
+	p.phases = phases
+	p.cycleLength = cycleLength
+	p.offset = offset
+	return p, nil
+}
+
+// GetName returns the name of the plan.
+func (p *SignalPlan) GetName() string {
+	return p.name
+}
+
+// GetPhases returns the phases of the plan.
+func (p *SignalPlan) GetPhases() []Phase {
+	return p.phases
+}
+
+// GetCycleLength returns the cycle length of the plan in seconds.
+func (p *SignalPlan) GetCycleLength() int {
+	return p.cycleLength
+}
+
+// GetOffset returns the offset of the plan in seconds.
+func (p *SignalPlan) GetOffset() int {
+	return p.offset
+}
+
+// phaseAt returns the index of the phase running at the given time
+// and how many seconds ago it started. If the time falls after the last
+// phase -1 is returned.
+func (p *SignalPlan) phaseAt(time int) (phase int, elapsed int) {
+	cycleTime := (time - p.offset) % p.cycleLength
+	if cycleTime < 0 {
+		cycleTime += p.cycleLength
+	}
+
+	for i := range p.phases {
+		if cycleTime < p.phases[i].GetDuration() {
+			return i, cycleTime
+		}
+		cycleTime -= p.phases[i].GetDuration()
+	}
+	return -1, cycleTime
+}
+
+// ScheduleEntry switches a signal controller to a plan at a time of day.
+type ScheduleEntry struct {
+	// Start is the number of seconds after midnight the plan starts.
+	Start int `json:"start"`
+	// Plan is the name of the plan to run.
+	Plan string `json:"plan"`
+}
+
+// SignalController changes the state of a group of traffic lights
+// following a set of signal plans.
+type SignalController struct {
+	// id is a unique integer used to identify the controller.
+	id int
+	// plans stores the plans the controller can run.
+	plans []SignalPlan
+	// schedule stores which plan runs at each time of day,
+	// sorted by start time.
+	schedule []ScheduleEntry
+	// currentPlan is the index of the plan that is running.
+	currentPlan int
+	// currentPhase is the index of the phase that is running. A value
+	// of -1 means no phase is running and all the lights are red.
+	currentPhase int
+	// mode is how the controller decides the length of each phase,
+	// either FixedTimeControl, ActuatedControl or MaxPressureControl.
+	mode string
+	// minGreen is the shortest time in seconds a phase can show green
+	// when the controller is not fixed time.
+	minGreen int
+	// maxGreen is the longest time in seconds a phase can show green
+	// when the controller is not fixed time. If 0 the phase's green
+	// time from the plan is used, or the min green if that is longer.
+	maxGreen int
+	// gap is the longest time in seconds between an approaching vehicle
+	// and the light for the vehicle to extend the green.
+	gap float64
+	// detectionDistance is how far from a light vehicles are counted
+	// as approaching the light.
+	detectionDistance float64
+	// phaseStart is the time the current phase started when the
+	// controller is not fixed time.
+	phaseStart int
+	// greenTime is the length of the current phase's green once it has
+	// ended, -1 while the phase is still green.
+	greenTime int
+
+	// Logger is used to give a context based log to the stdout
+	Logger *log.Entry
+}
+
+// NewSignalController returns a SignalController with the specified
+// paramaters. If the schedule is empty the first plan is always ran.
+func NewSignalController(id int, plans []SignalPlan, schedule []ScheduleEntry) (SignalController, error) {
+	var c SignalController
+
+	if len(plans) == 0 {
+		return c, errors.New("a signal controller needs at least one plan")
+	}
+
+	// Check the schedule only refers to plans that exist
+	for _, entry := range schedule {
+		if c.findPlan(plans, entry.Plan) < 0 {
+			return c, fmt.Errorf("no plan found with the name: %v", entry.Plan)
+		}
+		if entry.Start < 0 || entry.Start >= secondsPerDay {
+			return c, fmt.Errorf("schedule start must be within a day: %v", entry.Start)
+		}
+	}
+
+	c.id = id
+	c.plans = plans
+	c.schedule = append([]ScheduleEntry{}, schedule...)
+	sort.SliceStable(c.schedule, func(i, j int) bool {
+		return c.schedule[i].Start < c.schedule[j].Start
+	})
+	c.currentPhase = -1
+	c.mode = FixedTimeControl
+	c.minGreen = defaultMinGreen
+	c.gap = defaultGap
+	c.detectionDistance = defaultDetectionDistance
+
+	// Setup the logger
+	c.Logger = log.WithFields(log.Fields{
+		"package": "simulation",
+		"section": "SignalController",
+		"id":      c.id})
+
+	return c, nil
+}
+
+// GetID returns the id of the signal controller.
+func (c *SignalController) GetID() int {
+	return c.id
+}
+
+// GetPlans returns the plans the controller can run.
+func (c *SignalController) GetPlans() []SignalPlan {
+	return c.plans
+}
+
+// GetSchedule returns the controller's time of day schedule.
+func (c *SignalController) GetSchedule() []ScheduleEntry {
+	return c.schedule
+}
+
+// GetCurrentPlan returns the plan that is currently running.
+func (c *SignalController) GetCurrentPlan() SignalPlan {
+	return c.plans[c.currentPlan]
+}
+
+// GetCurrentPhase returns the index of the phase that is running, -1 if
+// all the lights are red between cycles.
+func (c *SignalController) GetCurrentPhase() int {
+	return c.currentPhase
+}
+
+// GetMode returns how the controller decides the length of each phase.
+func (c *SignalController) GetMode() string {
+	return c.mode
+}
+
+// SetMode changes how the controller decides the length of each phase.
+// Values of 0 keep the controller's current setting.
+func (c *SignalController) SetMode(mode string, minGreen, maxGreen int, gap, detectionDistance float64) error {
+	if mode != FixedTimeControl && mode != ActuatedControl && mode != MaxPressureControl {
+		return fmt.Errorf("unknown signal control mode: %v", mode)
+	}
+	if minGreen < 0 || maxGreen < 0 || gap < 0 || detectionDistance < 0 {
+		return errors.New("signal control settings can not be negative")
+	}
+
+	// Check the settings the controller will be left with, as a setting
+	// of 0 keeps the current one
+	if minGreen == 0 {
+		minGreen = c.minGreen
+	}
+	if maxGreen == 0 {
+		maxGreen = c.maxGreen
+	}
+	if maxGreen > 0 && maxGreen < minGreen {
+		return fmt.Errorf("max green %v is shorter than min green %v", maxGreen, minGreen)
+	}
+
+	c.minGreen = minGreen
+	c.maxGreen = maxGreen
+	if gap > 0 {
+		c.gap = gap
+	}
+	if detectionDistance > 0 {
+		c.detectionDistance = detectionDistance
+	}
+
+	c.Logger.Infof("Mode set to: %v", mode)
+	c.mode = mode
+	// Start the new mode from the first phase
+	c.currentPhase = -1
+	return nil
+}
+
+// GetLights returns the ids of all the lights used by the controller's plans.
+func (c *SignalController) GetLights() []int {
+	var lights []int
+	seen := make(map[int]bool)
+	for _, plan := range c.plans {
+		for _, phase := range plan.phases {
+			for _, l := range phase.lights {
+				if !seen[l] {
+					seen[l] = true
+					lights = append(lights, l)
+				}
+			}
+		}
+	}
+	return lights
+}
+
+// findPlan returns the index of the plan with the given name, -1 if
+// no plan is found.
+func (c *SignalController) findPlan(plans []SignalPlan, name string) int {
+	for i := range plans {
+		if plans[i].name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// scheduledPlan returns the index of the plan that should be running at
+// the given time of day.
+func (c *SignalController) scheduledPlan(timeOfDay int) int {
+	if len(c.schedule) == 0 {
+		return 0
+	}
+
+	// Before the first entry the last entry of the previous day is used
+	plan := c.schedule[len(c.schedule)-1].Plan
+	for _, entry := range c.schedule {
+		if entry.Start > timeOfDay {
+			break
+		}
+		plan = entry.Plan
+	}
+	return c.findPlan(c.plans, plan)
+}
+
+// Update sets the states of the controller's lights for the given time,
+// in seconds after midnight on the first day of the simulation. The agents
+// approaching the lights are used by the actuated and adaptive modes.
+func (c *SignalController) Update(time int, lights []Light, agents []Agent) {
+	c.update(time, lights, newTrafficIndex(agents))
+}
+
+// update sets the states of the controller's lights, finding the agents
+// approaching the lights with the index given.
+func (c *SignalController) update(time int, lights []Light, traffic *trafficIndex) {
+	timeOfDay := time % secondsPerDay
+
+	if plan := c.scheduledPlan(timeOfDay); plan != c.currentPlan {
+		c.Logger.Infof("Switching plan %v -> %v", c.plans[c.currentPlan].name, c.plans[plan].name)
+		c.currentPlan = plan
+		c.currentPhase = -1
+	}
+
+	plan := c.plans[c.currentPlan]
+	var state string
+	if c.mode == FixedTimeControl {
+		var elapsed int
+		c.currentPhase, elapsed = plan.phaseAt(time)
+		if c.currentPhase >= 0 {
+			state = plan.phases[c.currentPhase].stateAt(elapsed)
+		}
+	} else {
+		state = c.updateResponsive(time, plan, lights, traffic)
+	}
+
+	// Lights in the running phase take the phase's state,
+	// all the other lights used by the controller are red
+	controlled := c.controlled()
+	for i := range lights {
+		if !controlled[lights[i].id] {
+			continue
+		}
+
+		lightState := RedSignal
+		if c.currentPhase >= 0 && plan.phases[c.currentPhase].hasLight(lights[i].id) {
+			lightState = state
+		}
+		lights[i].SetState(lightState)
+	}
+}
+
+// updateResponsive moves the controller through the plan's phases using
+// the vehicles approaching the lights to decide when a green should end
+// and which phase should run next. The state of the current phase's lights
+// is returned.
+func (c *SignalController) updateResponsive(time int, plan SignalPlan, lights []Light, traffic *trafficIndex) string {
+	if c.currentPhase < 0 {
+		c.startPhase(time, c.nextPhase(plan, -1, lights, traffic))
+	}
+
+	phase := plan.phases[c.currentPhase]
+	elapsed := time - c.phaseStart
+
+	// Decide if the green should end
+	if c.greenTime < 0 {
+		// Without a max green the phase's green from the plan is used,
+		// but never less than the min green
+		maxGreen := c.maxGreen
+		if maxGreen == 0 {
+			maxGreen = phase.green
+			if maxGreen < c.minGreen {
+				maxGreen = c.minGreen
+			}
+		}
+
+		endGreen := elapsed >= maxGreen
+		if elapsed >= c.minGreen {
+			switch c.mode {
+			case ActuatedControl:
+				// Gap out when no vehicle will reach the light in time
+				endGreen = endGreen || c.approaching(phase, lights, traffic, c.gap) == 0
+			case MaxPressureControl:
+				// Give way when another phase has more vehicles queued
+				best := c.nextPhase(plan, c.currentPhase, lights, traffic)
+				current := c.approaching(phase, lights, traffic, -1)
+				endGreen = endGreen || c.approaching(plan.phases[best], lights, traffic, -1) > current
+			}
+		}
+
+		if !endGreen {
+			return GreenSignal
+		}
+		c.greenTime = elapsed
+		c.Logger.Debugf("Phase %v green ended after %v", c.currentPhase, elapsed)
+	}
+
+	// Run the amber and all red of the phase before the next phase
+	if elapsed < c.greenTime+phase.amber {
+		return AmberSignal
+	}
+	if elapsed < c.greenTime+phase.amber+phase.allRed {
+		return RedSignal
+	}
+
+	c.startPhase(time, c.nextPhase(plan, c.currentPhase, lights, traffic))
+	return GreenSignal
+}
+
+// startPhase makes the given phase the current phase.
+func (c *SignalController) startPhase(time int, phase int) {
+	c.currentPhase = phase
+	c.phaseStart = time
+	c.greenTime = -1
+}
+
+// nextPhase chooses the phase to run after the current phase. Actuated
+// controllers take the next phase in order with vehicles waiting, max
+// pressure controllers take the phase with the most vehicles queued. If
+// there is no demand the next phase in order is chosen.
+func (c *SignalController) nextPhase(plan SignalPlan, current int, lights []Light, traffic *trafficIndex) int {
+	next := (current + 1) % len(plan.phases)
+	best := next
+	bestDemand := 0
+
+	for i := 0; i < len(plan.phases); i++ {
+		candidate := (next + i) % len(plan.phases)
+		if candidate == current && len(plan.phases) > 1 {
+			continue
+		}
+
+		demand := c.approaching(plan.phases[candidate], lights, traffic, -1)
+		if c.mode == ActuatedControl && demand > 0 {
+			return candidate
+		}
+		if demand > bestDemand {
+			best = candidate
+			bestDemand = demand
+		}
+	}
+	return best
+}
+
+// approaching counts the vehicles travelling towards any of the phase's
+// lights within the controller's detection distance. If gap is not
+// negative only vehicles that are stopped or will reach the light within
+// gap seconds are counted. Pedestrians waiting at a crosswalk for one of
+// the lights are always counted.
+func (c *SignalController) approaching(phase Phase, lights []Light, traffic *trafficIndex, gap float64) int {
+	count := 0
+	for i := range lights {
+		if !phase.hasLight(lights[i].id) {
+			continue
+		}
+
+		count += traffic.waitingFor(lights[i].id)
+		for _, entry := range traffic.queue(lights[i].position) {
+			// The queue is ordered by distance so the rest are
+			// further away
+			if entry.distance > c.detectionDistance {
+				break
+			}
+			speed := entry.agent.GetSpeed()
+			if gap >= 0 && speed > 0 && entry.distance/speed > gap {
+				continue
+			}
+			count++
+		}
+	}
+	return count
+}
+
+// controlled returns the ids of the lights used by any of the
+// controller's plans.
+func (c *SignalController) controlled() map[int]bool {
+	controlled := make(map[int]bool)
+	for _, l := range c.GetLights() {
+		controlled[l] = true
+	}
+	return controlled
+}
@@ -2,15 +2,58 @@ package simulation
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
+// IdleStatus is the status of a simulation that has not been ran.
+const IdleStatus = "idle"
+
+// RunningStatus is the status of a simulation that is being ran.
+const RunningStatus = "running"
+
+// PausedStatus is the status of a simulation that has been paused
+// while running in the background.
+const PausedStatus = "paused"
+
+// FinishedStatus is the status of a simulation that has ran all the
+// steps it was asked to.
+const FinishedStatus = "finished"
+
+// StoppedStatus is the status of a simulation that was stopped
+// before it finished running.
+const StoppedStatus = "stopped"
+
 // Simulation stores all the details of a traffic simulation.
 type Simulation struct {
+	// mu guards the state of the simulation. Ticks and every exported
+	// method hold it, so commands from different goroutines are
+	// applied one at a time.
+	mu sync.Mutex
+	// control guards the values used to run the simulation
+	// in the background.
+	control sync.Mutex
+	// resume is used to wake a paused simulation.
+	resume *sync.Cond
+	// interrupt is sent to when the simulation is stopped, paused or
+	// resumed, waking a simulation waiting for its next tick.
+	interrupt chan struct{}
 	// shouldStop is true if the simualtion should
 	// stop and no longer run the simulation
 	shouldStop bool
+	// paused is true if the simulation should wait
+	// before running the next tick.
+	paused bool
+	// status is the current run status of the simulation.
+	status string
 	// agents is a list of all the agents in the
 	// simulation
 	agents []Agent
@@ -20,12 +63,51 @@ type Simulation struct {
 	// currentTick is the time that the simulation
 	// is currently at
 	currentTick int
+	// startTime is the time of day, in seconds after midnight,
+	// that the first tick of the simulation represents.
+	startTime int
 	// agentsToSpawn is a list of Agents (key) that need to
 	// be spawned thorughout the simualtion.
 	agentsToSpawn []Agent
 	// currentAgentID stores the current ID for an agent.
 	// This is used to assing new agents IDs.
 	currentAgentID int
+	// seed is the value the simulation's random number
+	// generator was seeded with.
+	seed int64
+	// rng is the random number generator used by everything in the
+	// simulation, so the same seed always gives the same run.
+	rng *rand.Rand
+	// source is the source rng draws from, kept so its
+	// state can be saved.
+	source *randomSource
+	// metrics stores the network wide measurements taken
+	// at each tick.
+	metrics []MetricsRecord
+	// spawned is the number of agents added since the
+	// metrics were last recorded.
+	spawned int
+	// recordTrajectories is true if the position of every
+	// agent should be stored at each tick.
+	recordTrajectories bool
+	// trajectories stores the recorded positions of the agents
+	// in tick order.
+	trajectories []TrajectoryPoint
+	// lines stores the bus lines that send buses into the
+	// simulation, indexed by id.
+	lines []BusLine
+	// collisions stores the vehicles that have collided, in
+	// the order they collided.
+	collisions []Collision
+	// overlapping stores the pairs of vehicles overlapping at
+	// the end of the last tick.
+	overlapping map[agentPair]bool
+	// haltOnCollision is true if the simulation should stop
+	// at the end of a tick where vehicles collide.
+	haltOnCollision bool
+	// demands stores the origin-destination matrices that
+	// generate vehicles, indexed by id.
+	demands []Demand
 
 	// Logger is used to print messages to the stdout
 	Logger *log.Entry
@@ -33,8 +115,8 @@ type Simulation struct {
 
 // NewSimulation creates a new Simulation struct
 // and initlises some of the values.
-func NewSimulation(env Environment) Simulation {
-	var sim Simulation
+func NewSimulation(env Environment) *Simulation {
+	sim := &Simulation{}
 
 	// Setup Logger
 	sim.Logger = log.WithFields(log.Fields{
@@ -44,43 +126,186 @@ func NewSimulation(env Environment) Simulation {
 	// Init shouldStop to false.
 	// If set to true the simulation will stop running.
 	sim.shouldStop = false
+	sim.status = IdleStatus
+	sim.resume = sync.NewCond(&sim.control)
+	sim.interrupt = make(chan struct{}, 1)
 
 	sim.environment = env
+	sim.environment.traffic = newTrafficIndex(nil)
+
+	// Seed the random number generator from the clock, this can be
+	// changed with SetSeed to repeat a run
+	sim.SetSeed(time.Now().UnixNano())
 
 	return sim
 }
 
 // Run loops until the simulation's shouldStop variable is set to true.
-func (s *Simulation) Run() {
-	for {
-		s.runOneStep()
+func (s *Simulation) Run() error {
+	return s.RunSteps(-1)
+}
 
-		if s.shouldStop {
-			break
-		}
+// RunSteps runs the simulation a specified number or until the simulation's
+// shouldStop variable is set to true. A negative number of steps runs the
+// simulation until it is stopped.
+func (s *Simulation) RunSteps(noOfSteps int) error {
+	if err := s.begin(); err != nil {
+		return err
 	}
+	s.run(noOfSteps, 0)
+	return nil
 }
 
-// RunSteps runs the simulation a specified number or until the simulation's
-// shouldStop variable is set to true.
-func (s *Simulation) RunSteps(noOfSteps int) {
-	for i := 0; i < noOfSteps; i++ {
-		s.runOneStep()
+// Start runs the simulation in the background for a specified number of
+// steps, or until it is stopped if the number is negative. If
+// ticksPerSecond is more than 0 the simulation runs no faster than that
+// many ticks every second of real time.
+func (s *Simulation) Start(noOfSteps int, ticksPerSecond float64) error {
+	if ticksPerSecond < 0 {
+		return errors.New("ticks per second can not be negative")
+	}
+	if err := s.begin(); err != nil {
+		return err
+	}
+	go s.run(noOfSteps, ticksPerSecond)
+	return nil
+}
+
+// Pause stops a simulation running in the background at the end of the
+// current tick, until Resume is called.
+func (s *Simulation) Pause() error {
+	s.control.Lock()
+	defer s.control.Unlock()
 
-		if s.shouldStop {
+	if s.status != RunningStatus {
+		return errors.New("simulation is not running")
+	}
+	s.paused = true
+	s.status = PausedStatus
+	s.wake()
+	return nil
+}
+
+// Resume continues running a paused simulation.
+func (s *Simulation) Resume() error {
+	s.control.Lock()
+	defer s.control.Unlock()
+
+	if s.status != PausedStatus {
+		return errors.New("simulation is not paused")
+	}
+	s.paused = false
+	s.status = RunningStatus
+	s.resume.Broadcast()
+	s.wake()
+	return nil
+}
+
+// GetStatus returns the run status of the simulation and the tick
+// it is currently at.
+func (s *Simulation) GetStatus() (status string, tick int) {
+	s.control.Lock()
+	status = s.status
+	s.control.Unlock()
+
+	s.mu.Lock()
+	tick = s.currentTick
+	s.mu.Unlock()
+	return
+}
+
+// begin marks the simulation as running. If it is already running
+// an error is returned.
+func (s *Simulation) begin() error {
+	s.control.Lock()
+	defer s.control.Unlock()
+
+	if s.status == RunningStatus || s.status == PausedStatus {
+		return errors.New("simulation is already running")
+	}
+	s.status = RunningStatus
+	s.shouldStop = false
+	s.paused = false
+	return nil
+}
+
+// run runs the simulation for a number of steps, waiting while it is
+// paused and finishing early if it is stopped.
+func (s *Simulation) run(noOfSteps int, ticksPerSecond float64) {
+	var ticker *time.Ticker
+	var period time.Duration
+	if ticksPerSecond > 0 {
+		period = time.Duration(float64(time.Second) / ticksPerSecond)
+		ticker = time.NewTicker(period)
+		defer ticker.Stop()
+	}
+
+	for i := 0; noOfSteps < 0 || i < noOfSteps; {
+		ok, waited := s.waitToStep()
+		if !ok {
 			break
 		}
+
+		if ticker != nil {
+			// After a pause wait a whole tick from when the
+			// simulation was resumed
+			if waited {
+				ticker.Reset(period)
+				select {
+				case <-ticker.C:
+				default:
+				}
+			}
+
+			select {
+			case <-ticker.C:
+			case <-s.interrupt:
+				// Check if the simulation was stopped or paused
+				// before running the tick
+				continue
+			}
+		}
+
+		s.mu.Lock()
+		s.runOneStep()
+		s.mu.Unlock()
+		i++
+	}
+
+	s.control.Lock()
+	if s.shouldStop {
+		s.status = StoppedStatus
+	} else {
+		s.status = FinishedStatus
 	}
+	s.control.Unlock()
 }
 
-// runOneStep simulates a single second in the simulation.
-// At each second each fo the agent's act functions are called.
-func (s *Simulation) runOneStep() {
-	var toRemove []int
+// waitToStep blocks while the simulation is paused. False is returned
+// if the simulation should stop, waited is true if the simulation was
+// paused.
+func (s *Simulation) waitToStep() (ok bool, waited bool) {
+	s.control.Lock()
+	defer s.control.Unlock()
+
+	for s.paused && !s.shouldStop {
+		waited = true
+		s.resume.Wait()
+	}
+	return !s.shouldStop, waited
+}
 
+// runOneStep simulates a single tick in the simulation, lasting the
+// simulation's time step. At each tick each fo the agent's act functions
+// are called.
+func (s *Simulation) runOneStep() {
 	s.currentTick++
 	s.Logger.Infof("Current Tick: %v", s.currentTick)
 
+	// Change the traffic lights controlled by signal plans, which
+	// work in whole seconds
+	s.environment.UpdateSignals(s.startTime+int(math.Floor(s.seconds(s.currentTick)+1e-9)), s.agents)
+
 	// Spawn agents that have a frequency
 	for _, agent := range s.agentsToSpawn {
 		if s.currentTick%agent.GetFrequency() == 0 {
@@ -88,37 +313,140 @@ func (s *Simulation) runOneStep() {
 
 			// Add the new vehicle, however change the frequency
 			// so the new agent doesn't get added to the agentsToSpawn.
-			s.AddAgent(agent.SetFrequency(0))
+			s.addAgent(agent.SetFrequency(0))
 		}
 	}
 
-	// Loop over each agent and execute act function
-	for i := 0; i < len(s.agents); i++ {
-		removeAgent := false
+	// Add the vehicles arriving from the origin-destination matrices
+	s.generateDemand()
 
-		s.agents[i], removeAgent = s.agents[i].Act(s.agents, s.environment)
+	// Send out the buses due to leave and add the passengers
+	// arriving at the stops
+	s.dispatchBuses()
+	s.environment.UpdateBusStops()
 
-		if removeAgent {
-			toRemove = append(toRemove, i)
-			continue
-		}
+	// Store where the agents start the tick for the detectors and
+	// collision checks
+	previous := make(map[int]Vector, len(s.agents))
+	for _, agent := range s.agents {
+		previous[agent.GetID()] = agent.GetPosition()
 	}
 
+	// Count the pedestrians vehicles must give way to
+	s.environment.UpdateCrosswalks(s.agents)
+
+	// Every agent acts on the state at the start of the tick
+	toRemove := s.actAgents()
+
+	// Board the passengers onto buses that reached a stop
+	s.serveBusStops()
+
 	// Remove agents that have reached their destination
-	for i := 0; i < len(toRemove); i++ {
-		s.removeAgent(toRemove[i])
+	for _, i := range toRemove {
+		s.demandArrived(s.agents[i].GetID())
+	}
+	s.removeAgents(toRemove)
+
+	// Record the agents that passed the detectors
+	s.environment.UpdateDetectors(s.currentTick, previous, s.agents)
+
+	// Check the vehicles have not run into each other
+	if s.detectCollisions(previous) && s.haltOnCollision {
+		s.Logger.Warnf("Halting at tick %v after a collision", s.currentTick)
+		s.Stop()
+	}
+
+	// Record the network wide metrics
+	s.metrics = append(s.metrics, measureNetwork(
+		s.currentTick, s.seconds(s.currentTick), s.agents, s.environment.GetLights(), s.spawned, len(toRemove)))
+	s.spawned = 0
+
+	if s.recordTrajectories {
+		s.trajectories = recordTrajectories(s.trajectories, s.currentTick, s.seconds(s.currentTick), s.agents)
 	}
 }
 
+// actAgents calls every agent's act function with the agents as they were
+// at the start of the tick, spreading the agents across goroutines. Each
+// agent draws from its own random number generator, so the results are
+// the same however many goroutines are used. The indexes of the agents
+// that reached their destination are returned in increasing order.
+func (s *Simulation) actAgents() (toRemove []int) {
+	agents := s.agents
+	next := make([]Agent, len(agents))
+	finished := make([]bool, len(agents))
+	tickSeed := s.rng.Uint64()
+
+	// Hand out the agents in batches to as many goroutines as can run
+	// at once
+	batches := (len(agents) + actBatchSize - 1) / actBatchSize
+	workers := runtime.GOMAXPROCS(0)
+	if workers > batches {
+		workers = batches
+	}
+	var batch int64 = -1
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for b := int(atomic.AddInt64(&batch, 1)); b < batches; b = int(atomic.AddInt64(&batch, 1)) {
+				end := (b + 1) * actBatchSize
+				if end > len(agents) {
+					end = len(agents)
+				}
+				for i := b * actBatchSize; i < end; i++ {
+					rng := agentRandom(tickSeed, agents[i].GetID())
+					next[i], finished[i] = agents[i].Act(agents, s.environment, rng)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Store the new states in order
+	s.agents = next
+	for i, agent := range next {
+		s.environment.traffic.update(agent)
+		if finished[i] {
+			toRemove = append(toRemove, i)
+		}
+	}
+	return toRemove
+}
+
 // Stop sets the simulation's shouldStop variable to true.
 // If a simulation is currntly running this function should notify the
 // simulation to stop at the end of the current tick.
 func (s *Simulation) Stop() {
+	s.control.Lock()
+	defer s.control.Unlock()
+
 	s.shouldStop = true
+	s.resume.Broadcast()
+	s.wake()
+}
+
+// wake interrupts a simulation waiting for its next tick so it can check
+// if it has been stopped or paused.
+func (s *Simulation) wake() {
+	select {
+	case s.interrupt <- struct{}{}:
+	default:
+		// The simulation has already been woken
+	}
 }
 
 // AddAgent adds an agent to the simulation.
 func (s *Simulation) AddAgent(newAgent Agent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.addAgent(newAgent)
+}
+
+// addAgent adds an agent to the simulation without locking it.
+func (s *Simulation) addAgent(newAgent Agent) {
 	// if the frequency is more than 0 the agent
 	// needs to be spawned more than once
 	if newAgent.GetFrequency() > 0 {
@@ -134,41 +462,143 @@ func (s *Simulation) AddAgent(newAgent Agent) {
 
 	s.Logger.Infof("Adding an Agent: %v", newAgent.GetID())
 	s.agents = append(s.agents, newAgent)
+	s.environment.traffic.add(newAgent)
+	s.spawned++
 }
 
-// removeAgent removes the agent at a specified index from the simulation's
-// list of agents.
-func (s *Simulation) removeAgent(index int) {
-	s.Logger.Infof("Removing Agent: %v", s.agents[index].GetID())
-	s.agents = append(s.agents[:index], s.agents[index+1:]...)
+// removeAgents removes the agents at the specified indexes, in increasing
+// order, from the simulation's list of agents.
+func (s *Simulation) removeAgents(indexes []int) {
+	if len(indexes) == 0 {
+		return
+	}
+
+	kept := s.agents[:indexes[0]]
+	next := 0
+	for i := indexes[0]; i < len(s.agents); i++ {
+		if next < len(indexes) && indexes[next] == i {
+			s.Logger.Infof("Removing Agent: %v", s.agents[i].GetID())
+			s.environment.traffic.remove(s.agents[i].GetID())
+			next++
+			continue
+		}
+		kept = append(kept, s.agents[i])
+	}
+
+	// Clear the end of the list so the removed agents can be freed
+	for i := len(kept); i < len(s.agents); i++ {
+		s.agents[i] = nil
+	}
+	s.agents = kept
 }
 
 // GetInfo returns a json string containing the current information
 // of the simulation.
 func (s *Simulation) GetInfo() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	type lightInfo struct {
 		Stop     bool      `json:"stop"`
+		State    string    `json:"state"`
 		Position []float64 `json:"position"`
 		ID       int       `json:"id"`
 	}
+	type signalInfo struct {
+		ID     int    `json:"id"`
+		Plan   string `json:"plan"`
+		Mode   string `json:"mode"`
+		Phase  int    `json:"phase"`
+		Lights []int  `json:"lights"`
+	}
+	type linkInfo struct {
+		ID         int       `json:"id"`
+		From       int       `json:"from"`
+		To         int       `json:"to"`
+		Length     float64   `json:"length"`
+		Direction  []float64 `json:"direction"`
+		Oneway     bool      `json:"oneway"`
+		Lanes      int       `json:"lanes"`
+		SpeedLimit float64   `json:"speedLimit"`
+	}
+	type detectorInfo struct {
+		ID       int       `json:"id"`
+		Type     string    `json:"type"`
+		Position []float64 `json:"position"`
+		Length   float64   `json:"length"`
+		Min      []float64 `json:"min"`
+		Max      []float64 `json:"max"`
+		Interval int       `json:"interval"`
+	}
+	type crosswalkInfo struct {
+		ID          int       `json:"id"`
+		Start       []float64 `json:"start"`
+		End         []float64 `json:"end"`
+		Width       float64   `json:"width"`
+		Light       int       `json:"light"`
+		Pedestrians int       `json:"pedestrians"`
+	}
+	type busStopInfo struct {
+		ID          int       `json:"id"`
+		Position    []float64 `json:"position"`
+		ArrivalRate float64   `json:"arrivalRate"`
+		Waiting     int       `json:"waiting"`
+	}
+	type junctionInfo struct {
+		ID          int         `json:"id"`
+		Position    []float64   `json:"position"`
+		Control     string      `json:"control"`
+		Major       [][]float64 `json:"major"`
+		CriticalGap float64     `json:"criticalGap"`
+	}
 	type envInfo struct {
-		Waypoints [][]float64 `json:"waypoints"`
-		Lights    []lightInfo `json:"lights"`
+		Waypoints       [][]float64     `json:"waypoints"`
+		Links           []linkInfo      `json:"links"`
+		Lights          []lightInfo     `json:"lights"`
+		Signals         []signalInfo    `json:"signals"`
+		Detectors       []detectorInfo  `json:"detectors"`
+		Crosswalks      []crosswalkInfo `json:"crosswalks"`
+		BusStops        []busStopInfo   `json:"busStops"`
+		Junctions       []junctionInfo  `json:"junctions"`
+		DefaultJunction string          `json:"defaultJunction"`
 	}
 
 	type agentInfo struct {
 		ID              int         `json:"id"`
 		Position        []float64   `json:"position"`
+		Lane            int         `json:"lane"`
+		LanePosition    []float64   `json:"lanePosition"`
 		Speed           float64     `json:"speed"`
 		CurrentWaypoint []float64   `json:"currentWaypoint"`
 		Route           [][]float64 `json:"route"`
+		PlannedRoute    [][]float64 `json:"plannedRoute"`
 		Type            string      `json:"type"`
 	}
 
+	type busLineInfo struct {
+		ID       int         `json:"id"`
+		Name     string      `json:"name"`
+		Stops    []int       `json:"stops"`
+		Schedule BusSchedule `json:"schedule"`
+	}
+
+	type demandInfo struct {
+		ID      int      `json:"id"`
+		Process string   `json:"process"`
+		Flows   []ODFlow `json:"flows"`
+	}
+
 	type simInfo struct {
-		Agents      []agentInfo `json:"agents"`
-		Environment envInfo     `json:"environment"`
-		Tick        int         `json:"tick"`
+		Agents      []agentInfo   `json:"agents"`
+		BusLines    []busLineInfo `json:"busLines"`
+		Demands     []demandInfo  `json:"demands"`
+		Environment envInfo       `json:"environment"`
+		Tick        int           `json:"tick"`
+		Time        float64       `json:"time"`
+		TimeStep    float64       `json:"timeStep"`
+		StartTime   int           `json:"startTime"`
+		Seed        int64         `json:"seed"`
+		Parameters  Parameters    `json:"parameters"`
 	}
 
 	type response struct {
@@ -181,6 +611,11 @@ func (s *Simulation) GetInfo() string {
 	var sim simInfo
 
 	sim.Tick = s.currentTick
+	sim.Time = s.seconds(s.currentTick)
+	sim.TimeStep = s.environment.GetTimeStep()
+	sim.StartTime = s.startTime
+	sim.Seed = s.seed
+	sim.Parameters = s.environment.GetParameters()
 
 	// Set the environment info
 	var env envInfo
@@ -189,6 +624,21 @@ func (s *Simulation) GetInfo() string {
 		env.Waypoints = append(env.Waypoints, waypoint.ConvertToSlice())
 	}
 
+	// Convert links to []linkInfo, the from and to values are indexes
+	// into the waypoints
+	for _, link := range s.environment.GetLinks() {
+		dir := link.GetDirection()
+		env.Links = append(env.Links, linkInfo{
+			ID:         link.GetID(),
+			From:       link.GetFrom(),
+			To:         link.GetTo(),
+			Length:     link.GetLength(),
+			Direction:  dir.ConvertToSlice(),
+			Oneway:     link.IsOneway(),
+			Lanes:      link.GetLanes(),
+			SpeedLimit: link.GetSpeedLimit()})
+	}
+
 	// Convert lights to []lightInfo
 	lights := s.environment.GetLights()
 	var lightsInfo []lightInfo
@@ -199,6 +649,7 @@ func (s *Simulation) GetInfo() string {
 
 		cli.ID = light.GetID()
 		cli.Stop = light.GetStop()
+		cli.State = light.GetState()
 
 		// Convert the position from vector to []float64
 		lightPos := light.GetPosition()
@@ -209,24 +660,114 @@ func (s *Simulation) GetInfo() string {
 
 	env.Lights = lightsInfo
 
+	// Convert the signal controllers to []signalInfo
+	for _, signal := range s.environment.GetSignalControllers() {
+		plan := signal.GetCurrentPlan()
+		env.Signals = append(env.Signals, signalInfo{
+			ID:     signal.GetID(),
+			Plan:   plan.GetName(),
+			Mode:   signal.GetMode(),
+			Phase:  signal.GetCurrentPhase(),
+			Lights: signal.GetLights()})
+	}
+
+	// Convert the detectors to []detectorInfo
+	for _, detector := range s.environment.GetDetectors() {
+		pos := detector.GetPosition()
+		min, max := detector.GetArea()
+		env.Detectors = append(env.Detectors, detectorInfo{
+			ID:       detector.GetID(),
+			Type:     detector.GetType(),
+			Position: pos.ConvertToSlice(),
+			Length:   detector.GetLength(),
+			Min:      min.ConvertToSlice(),
+			Max:      max.ConvertToSlice(),
+			Interval: detector.GetInterval()})
+	}
+
+	// Convert the crosswalks to []crosswalkInfo
+	for _, crosswalk := range s.environment.GetCrosswalks() {
+		start := crosswalk.GetStart()
+		end := crosswalk.GetEnd()
+		light, _ := crosswalk.GetLight()
+		env.Crosswalks = append(env.Crosswalks, crosswalkInfo{
+			ID:          crosswalk.GetID(),
+			Start:       start.ConvertToSlice(),
+			End:         end.ConvertToSlice(),
+			Width:       crosswalk.GetWidth(),
+			Light:       light,
+			Pedestrians: crosswalk.GetPedestrians()})
+	}
+
+	// Convert the bus stops to []busStopInfo
+	for _, stop := range s.environment.GetBusStops() {
+		pos := stop.GetPosition()
+		env.BusStops = append(env.BusStops, busStopInfo{
+			ID:          stop.GetID(),
+			Position:    pos.ConvertToSlice(),
+			ArrivalRate: stop.GetArrivalRate(),
+			Waiting:     stop.GetWaiting()})
+	}
+
+	// Convert the junctions to []junctionInfo
+	for _, junction := range s.environment.GetJunctions() {
+		pos := junction.GetPosition()
+		var major [][]float64
+		for _, m := range junction.GetMajor() {
+			major = append(major, m.ConvertToSlice())
+		}
+		env.Junctions = append(env.Junctions, junctionInfo{
+			ID:          junction.GetID(),
+			Position:    pos.ConvertToSlice(),
+			Control:     junction.GetControl(),
+			Major:       major,
+			CriticalGap: junction.GetCriticalGap()})
+	}
+	env.DefaultJunction = s.environment.GetDefaultJunction()
+
 	sim.Environment = env
 
+	// Sets the bus line information
+	for _, line := range s.lines {
+		sim.BusLines = append(sim.BusLines, busLineInfo{
+			ID:       line.GetID(),
+			Name:     line.GetName(),
+			Stops:    line.GetStops(),
+			Schedule: line.GetSchedule()})
+	}
+
+	// Sets the demand information
+	for _, demand := range s.demands {
+		sim.Demands = append(sim.Demands, demandInfo{
+			ID:      demand.GetID(),
+			Process: demand.GetProcess(),
+			Flows:   demand.GetFlows()})
+	}
+
 	// Sets the agent information
 	for _, agent := range s.agents {
 		p := agent.GetPosition()
+		lp := agent.GetLanePosition()
 		cwp := agent.GetCurrentWaypoint()
 		// Convert []Vector to [][]float64
 		var r [][]float64
 		for _, wp := range agent.GetRoute() {
 			r = append(r, wp.ConvertToSlice())
 		}
+		var pr [][]float64
+		for _, wp := range agent.GetPlannedRoute() {
+			pr = append(pr, wp.ConvertToSlice())
+		}
 
 		currentAgent := agentInfo{
 			ID:              agent.GetID(),
 			Position:        p.ConvertToSlice(),
+			Lane:            agent.GetLane(),
+			LanePosition:    lp.ConvertToSlice(),
 			Speed:           agent.GetSpeed(),
 			CurrentWaypoint: cwp.ConvertToSlice(),
 			Route:           r,
+			PlannedRoute:    pr,
 			Type:            agent.GetType()}
 
 		sim.Agents = append(sim.Agents, currentAgent)
@@ -243,6 +784,9 @@ func (s *Simulation) GetInfo() string {
 // GetAgent retuns the specified agent by id.
 // A nil response means no agent was found.
 func (s *Simulation) GetAgent(id int) Agent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	for _, agent := range s.agents {
 		if agent.GetID() == id {
 			return agent
@@ -251,16 +795,21 @@ func (s *Simulation) GetAgent(id int) Agent {
 	return nil
 }
 
-// GetAgentPositions retuns the positions of all the agents in the simulation.
-// It also retuns the current waypoint for each agent.
-func (s *Simulation) GetAgentPositions() (positions [][]float64, goals [][]float64) {
+// GetAgentPositions retuns the positions of all the agents in the simulation,
+// moved into the centre of their lanes. It also retuns the current waypoint
+// and lane for each agent.
+func (s *Simulation) GetAgentPositions() (positions [][]float64, goals [][]float64, lanes []int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	// Loop through all the agents and get their positions in a []float64
 	// format and append them to the results
 	for _, agent := range s.agents {
-		p := agent.GetPosition()
+		p := agent.GetLanePosition()
 		positions = append(positions, p.ConvertToSlice())
 		wp := agent.GetCurrentWaypoint()
 		goals = append(goals, wp.ConvertToSlice())
+		lanes = append(lanes, agent.GetLane())
 	}
 
 	return
@@ -269,6 +818,9 @@ func (s *Simulation) GetAgentPositions() (positions [][]float64, goals [][]float
 // GetWaypoints returns the simulation's waypoints defined in the
 // environment in a [][]float64 format.
 func (s *Simulation) GetWaypoints() (waypoints [][]float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	for _, w := range s.environment.GetWaypoints() {
 		waypoints = append(waypoints, w.ConvertToSlice())
 	}
@@ -277,29 +829,276 @@ func (s *Simulation) GetWaypoints() (waypoints [][]float64) {
 
 // GetTick retuns the current tick the simulation is on.
 func (s *Simulation) GetTick() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	return s.currentTick
 }
 
-// GetAgents retuns the list of agents in the simulation.
+// GetAgents retuns a copy of the list of agents in the simulation.
 func (s *Simulation) GetAgents() []Agent {
-	return s.agents
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Agent{}, s.agents...)
+}
+
+// FindRoute calculates a route through the simulation's road network from
+// the origin to the destination, visiting each of the via points in order.
+func (s *Simulation) FindRoute(origin, destination Vector, via []Vector, routeType string) ([]Vector, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.environment.FindRoute(origin, destination, via, routeType)
 }
 
 // AddLight adds a traffic light at the given position with
 // the stop state specified.
 func (s *Simulation) AddLight(pos Vector, stop bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	s.environment.AddLight(pos, stop)
 }
 
 // UpdateLight updates the state of a given light in the
 // simulation.
 func (s *Simulation) UpdateLight(id int, stop bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	s.environment.UpdateLight(id, stop)
 }
 
+// AddSignalController adds a controller that runs the given signal plans
+// to the simulation.
+func (s *Simulation) AddSignalController(plans []SignalPlan, schedule []ScheduleEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.environment.AddSignalController(plans, schedule)
+}
+
+// SetSignalMode changes how a signal controller in the simulation decides
+// the length of its phases.
+func (s *Simulation) SetSignalMode(id int, mode string, minGreen, maxGreen int, gap, detectionDistance float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.environment.SetSignalMode(id, mode, minGreen, maxGreen, gap, detectionDistance)
+}
+
+// AddPointDetector adds a point detector to the simulation covering a
+// length of road centred on the given position.
+func (s *Simulation) AddPointDetector(pos Vector, length float64, interval int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.environment.AddPointDetector(pos, length, interval)
+}
+
+// AddAreaDetector adds an area detector to the simulation covering the
+// rectangle between the two corners given.
+func (s *Simulation) AddAreaDetector(corner, opposite Vector, interval int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.environment.AddAreaDetector(corner, opposite, interval)
+}
+
+// AddCrosswalk adds a crosswalk to the simulation between the two kerbs
+// given. A light id of -1 adds a crosswalk without signals.
+func (s *Simulation) AddCrosswalk(start, end Vector, width float64, light int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.environment.AddCrosswalk(start, end, width, light)
+}
+
+// AddJunction adds priority rules to the node at the given position. The
+// major roads are given by the positions of the nodes they come from. A
+// critical gap of 0 uses the default.
+func (s *Simulation) AddJunction(pos Vector, control string, major []Vector, criticalGap float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.environment.AddJunction(pos, control, major, criticalGap)
+}
+
+// SetDefaultJunction sets the control used at nodes where roads meet that
+// have no signals or junction of their own. An empty control removes the
+// default.
+func (s *Simulation) SetDefaultJunction(control string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.environment.SetDefaultJunction(control)
+}
+
+// GetDetector returns a copy of the detector with a given id.
+func (s *Simulation) GetDetector(id int) (Detector, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d, found := s.environment.GetDetector(id)
+	// Copy the records so they are not changed by the next tick
+	d.records = append([]DetectorRecord{}, d.records...)
+	return d, found
+}
+
+// GetDetectors returns a copy of each of the detectors.
+func (s *Simulation) GetDetectors() []Detector {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var detectors []Detector
+	for _, d := range s.environment.GetDetectors() {
+		// Copy the records so they are not changed by the next tick
+		d.records = append([]DetectorRecord{}, d.records...)
+		detectors = append(detectors, d)
+	}
+	return detectors
+}
+
+// SetSeed resets the simulation's random number generator using the given
+// seed. Two simulations with the same seed and the same inputs produce
+// the same results.
+func (s *Simulation) SetSeed(seed int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seed = seed
+	s.rng, s.source = newRandom(seed)
+}
+
+// GetSeed returns the seed of the simulation's random number generator.
+func (s *Simulation) GetSeed() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.seed
+}
+
+// SetStartTime sets the time of day, in seconds after midnight, that
+// the simulation starts at.
+func (s *Simulation) SetStartTime(startTime int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.startTime = startTime
+}
+
+// SetTimeStep sets the number of seconds each tick simulates. The time
+// step can only be changed before the first tick.
+func (s *Simulation) SetTimeStep(timeStep float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if timeStep <= 0 {
+		return fmt.Errorf("time step must be more than 0 seconds: %v", timeStep)
+	}
+	if s.currentTick > 0 {
+		return errors.New("time step can not be changed once the simulation has started")
+	}
+	s.environment.timeStep = timeStep
+	return nil
+}
+
+// GetTimeStep returns the number of seconds each tick simulates.
+func (s *Simulation) GetTimeStep() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.environment.GetTimeStep()
+}
+
+// SetParameters replaces the parameters that control how the agents
+// behave. The parameters can be changed between any two ticks.
+func (s *Simulation) SetParameters(parameters Parameters) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := parameters.validate(); err != nil {
+		return err
+	}
+	s.environment.parameters = &parameters
+	s.Logger.Infof("Parameters set to: %+v", parameters)
+	return nil
+}
+
+// UpdateParameters changes the parameters named in the json object given,
+// keeping the others as they are. The parameters are read, changed and
+// checked while the simulation is locked, so updates made at the same
+// time are not lost. The parameters in use afterwards are returned.
+func (s *Simulation) UpdateParameters(raw json.RawMessage) (Parameters, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	parameters := s.environment.GetParameters()
+	if err := json.Unmarshal(raw, &parameters); err != nil {
+		return s.environment.GetParameters(), fmt.Errorf("unable to read parameters: %v", err)
+	}
+	if err := parameters.validate(); err != nil {
+		return s.environment.GetParameters(), err
+	}
+	s.environment.parameters = &parameters
+	s.Logger.Infof("Parameters set to: %+v", parameters)
+	return parameters, nil
+}
+
+// GetParameters returns the parameters that control how the agents
+// behave.
+func (s *Simulation) GetParameters() Parameters {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.environment.GetParameters()
+}
+
+// GetTime returns the number of seconds simulated so far.
+func (s *Simulation) GetTime() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.seconds(s.currentTick)
+}
+
+// seconds returns the number of seconds simulated by the end of the
+// given tick.
+func (s *Simulation) seconds(tick int) float64 {
+	return float64(tick) * s.environment.GetTimeStep()
+}
+
+// ticks returns the number of ticks, each lasting timeStep seconds, it
+// takes for the given number of seconds to pass.
+func ticks(seconds, timeStep float64) int {
+	// Allow for rounding errors in the time step
+	return int(math.Ceil(seconds/timeStep - 1e-9))
+}
+
+// StepsFor returns the number of ticks the simulation must run for the
+// given number of seconds to pass.
+func (s *Simulation) StepsFor(seconds float64) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return ticks(seconds, s.environment.GetTimeStep())
+}
+
+// GetStartTime returns the time of day the simulation starts at.
+func (s *Simulation) GetStartTime() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.startTime
+}
+
 // GetLights returns the positions and current states of all the lights in
 // the environment in the form of [][]flaot64 and []bool.
 func (s *Simulation) GetLights() (positions [][]float64, states []bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	for _, light := range s.environment.GetLights() {
 		currentPos := light.GetPosition()
 		positions = append(positions, currentPos.ConvertToSlice())
@@ -0,0 +1,114 @@
+package simulation
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+
+	shp "github.com/jonas-p/go-shp"
+)
+
+// runWithProcs runs a seeded simulation with enough vehicles to be spread
+// over several goroutines, using the given GOMAXPROCS, and returns the
+// positions of the agents at the end.
+func runWithProcs(t *testing.T, procs int) map[int]Vector {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(procs))
+
+	env := NewEnvironment()
+	for road := 0; road < 4; road++ {
+		y := float64(road * 100)
+		env.addPolyLine([]int32{0}, []shp.Point{{X: 0, Y: y}, {X: 3000, Y: y}}, map[string]string{"lanes": "2", "oneway": "yes"})
+	}
+	s := NewSimulation(env)
+	s.SetSeed(9)
+
+	models := []string{RulesModel, IDMModel, GippsModel}
+	for road := 0; road < 4; road++ {
+		y := float64(road * 100)
+		for i, x := 0, 0.0; x < 2500; i, x = i+1, x+8 {
+			model, err := NewCarFollowingModel(models[i%len(models)], nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			v := NewVehicle(-1, NewVector(x, y), 5, 15, 2, 3, []Vector{NewVector(3000, y)}, 0)
+			s.AddAgent(v.SetModel(model).SetLane(i % 2))
+		}
+	}
+	if len(s.GetAgents()) <= actBatchSize {
+		t.Fatalf("only %v agents, need more than %v to use several goroutines", len(s.GetAgents()), actBatchSize)
+	}
+
+	s.RunSteps(40)
+	positions := make(map[int]Vector)
+	for _, a := range s.GetAgents() {
+		positions[a.GetID()] = a.GetPosition()
+	}
+	return positions
+}
+
+// TestActDeterminism checks the agents end up in the same place however
+// many goroutines they are updated across.
+func TestActDeterminism(t *testing.T) {
+	want := runWithProcs(t, 1)
+	got := runWithProcs(t, 8)
+
+	if len(got) != len(want) {
+		t.Fatalf("%v agents with GOMAXPROCS 8, want %v", len(got), len(want))
+	}
+	for id, pos := range want {
+		if p, found := got[id]; !found || p != pos {
+			t.Fatalf("agent %v at %v with GOMAXPROCS 8, want %v", id, got[id], pos)
+		}
+	}
+}
+
+// TestStepsFor checks the number of ticks run for a number of seconds is
+// not thrown off by rounding errors in the time step.
+func TestStepsFor(t *testing.T) {
+	s := NewSimulation(NewEnvironment())
+	if err := s.SetTimeStep(0.1); err != nil {
+		t.Fatal(err)
+	}
+	for seconds, want := range map[float64]int{0.3: 3, 0.7: 7, 60: 600, 0.25: 3} {
+		if got := s.StepsFor(seconds); got != want {
+			t.Fatalf("%v ticks for %v seconds, want %v", got, seconds, want)
+		}
+	}
+}
+
+// TestUpdateParameters checks updates only change the parameters they
+// name, invalid updates change nothing and updates made at the same time
+// are all kept.
+func TestUpdateParameters(t *testing.T) {
+	s := NewSimulation(NewEnvironment())
+	want := DefaultParameters()
+
+	want.Politeness = 0.5
+	if got, err := s.UpdateParameters([]byte(`{"politeness": 0.5}`)); err != nil || got != want {
+		t.Fatalf("parameters %+v, %v, want %+v", got, err, want)
+	}
+	for _, invalid := range []string{`{"decelerationProbability": 2, "margin": 3}`, `{"margin": "x"}`} {
+		if got, err := s.UpdateParameters([]byte(invalid)); err == nil || got != want {
+			t.Fatalf("parameters %+v, %v after %v, want %+v and an error", got, err, invalid, want)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			s.UpdateParameters([]byte(`{"kerbsideBias": -1}`))
+		}()
+		go func() {
+			defer wg.Done()
+			s.UpdateParameters([]byte(`{"bunchingRatio": 0.8}`))
+		}()
+	}
+	wg.Wait()
+	want.KerbsideBias = -1
+	want.BunchingRatio = 0.8
+	if got := s.GetParameters(); got != want {
+		t.Fatalf("parameters %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,849 @@
+package simulation
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// stateVersion is the version of the saved simulation format. It must be
+// increased whenever the format changes in a way older files can not be
+// read.
+const stateVersion = 1
+
+// simulationState is the saved form of a Simulation.
+type simulationState struct {
+	Version            int               `json:"version"`
+	Tick               int               `json:"tick"`
+	TimeStep           float64           `json:"timeStep"`
+	StartTime          int               `json:"startTime"`
+	Seed               int64             `json:"seed"`
+	RandomState        uint64            `json:"randomState"`
+	CurrentAgentID     int               `json:"currentAgentId"`
+	Agents             []agentState      `json:"agents"`
+	AgentsToSpawn      []agentState      `json:"agentsToSpawn"`
+	Environment        environmentState  `json:"environment"`
+	Metrics            []MetricsRecord   `json:"metrics"`
+	Spawned            int               `json:"spawned"`
+	RecordTrajectories bool              `json:"recordTrajectories"`
+	Trajectories       []TrajectoryPoint `json:"trajectories"`
+	Lines              []busLineState    `json:"lines"`
+	Collisions         []Collision       `json:"collisions"`
+	Overlapping        [][]int           `json:"overlapping"`
+	HaltOnCollision    bool              `json:"haltOnCollision"`
+	Demands            []demandState     `json:"demands"`
+	Parameters         *Parameters       `json:"parameters"`
+}
+
+// agentState is the saved form of an Agent. The state is decoded based
+// on the agent's type.
+type agentState struct {
+	Type  string          `json:"type"`
+	State json.RawMessage `json:"state"`
+}
+
+// vehicleState is the saved form of a Vehicle.
+type vehicleState struct {
+	ID              int                `json:"id"`
+	Position        Vector             `json:"position"`
+	Speed           float64            `json:"speed"`
+	MaxSpeed        float64            `json:"maxSpeed"`
+	Route           []Vector           `json:"route"`
+	PlannedRoute    []Vector           `json:"plannedRoute"`
+	CurrentWaypoint Vector             `json:"currentWaypoint"`
+	LastWaypoint    Vector             `json:"lastWaypoint"`
+	Lane            int                `json:"lane"`
+	Lanes           int                `json:"lanes"`
+	TwoWay          bool               `json:"twoWay,omitempty"`
+	LaneChangeWait  int                `json:"laneChangeWait"`
+	JunctionWait    int                `json:"junctionWait"`
+	Length          float64            `json:"length"`
+	Width           float64            `json:"width"`
+	Acceleration    float64            `json:"acceleration"`
+	Deceleration    float64            `json:"deceleration"`
+	Frequency       int                `json:"frequency"`
+	Model           string             `json:"model"`
+	ModelParameters map[string]float64 `json:"modelParameters"`
+}
+
+// busState is the saved form of a Bus.
+type busState struct {
+	Vehicle  agentState      `json:"vehicle"`
+	Line     int             `json:"line"`
+	Trip     int             `json:"trip"`
+	Stops    []lineStopState `json:"stops"`
+	Serving  bool            `json:"serving"`
+	Dwell    int             `json:"dwell"`
+	Departed int             `json:"departed"`
+}
+
+// lineStopState is the saved form of a stop on a bus line.
+type lineStopState struct {
+	Stop     int    `json:"stop"`
+	Position Vector `json:"position"`
+	Segment  int    `json:"segment"`
+	Time     int    `json:"time"`
+}
+
+// busLineState is the saved form of a BusLine.
+type busLineState struct {
+	Name     string          `json:"name"`
+	Route    []Vector        `json:"route"`
+	Stops    []lineStopState `json:"stops"`
+	Schedule BusSchedule     `json:"schedule"`
+	Trips    int             `json:"trips"`
+	Vehicle  agentState      `json:"vehicle"`
+	Visits   []StopVisit     `json:"visits"`
+}
+
+// demandState is the saved form of a Demand.
+type demandState struct {
+	Zones     map[string][]Vector `json:"zones"`
+	Flows     []ODFlow            `json:"flows"`
+	Process   string              `json:"process"`
+	Profile   DemandProfile       `json:"profile"`
+	RouteType string              `json:"routeType"`
+	Vehicle   agentState          `json:"vehicle"`
+	Start     int                 `json:"start"`
+	Pending   []float64           `json:"pending"`
+	Expected  []float64           `json:"expected"`
+	Generated []int               `json:"generated"`
+	Unrouted  []int               `json:"unrouted"`
+	Arrived   []int               `json:"arrived"`
+	Trips     map[int]int         `json:"trips"`
+}
+
+// busStopState is the saved form of a BusStop.
+type busStopState struct {
+	Position    Vector  `json:"position"`
+	ArrivalRate float64 `json:"arrivalRate"`
+	Waiting     float64 `json:"waiting"`
+	Boarded     int     `json:"boarded"`
+}
+
+// pedestrianState is the saved form of a Pedestrian.
+type pedestrianState struct {
+	ID              int      `json:"id"`
+	Position        Vector   `json:"position"`
+	Speed           float64  `json:"speed"`
+	WalkingSpeed    float64  `json:"walkingSpeed"`
+	Route           []Vector `json:"route"`
+	PlannedRoute    []Vector `json:"plannedRoute"`
+	CurrentWaypoint Vector   `json:"currentWaypoint"`
+	LastWaypoint    Vector   `json:"lastWaypoint"`
+	Crossing        int      `json:"crossing"`
+	WaitingFor      int      `json:"waitingFor"`
+	Frequency       int      `json:"frequency"`
+}
+
+// environmentState is the saved form of an Environment. The nodes are
+// stored in id order.
+type environmentState struct {
+	Nodes           []Vector         `json:"nodes"`
+	Links           []linkState      `json:"links"`
+	Lights          []lightState     `json:"lights"`
+	Signals         []signalState    `json:"signals"`
+	Detectors       []detectorState  `json:"detectors"`
+	Crosswalks      []crosswalkState `json:"crosswalks"`
+	BusStops        []busStopState   `json:"busStops"`
+	Junctions       []junctionState  `json:"junctions"`
+	DefaultJunction string           `json:"defaultJunction"`
+}
+
+// linkState is the saved form of a Link.
+type linkState struct {
+	From       int               `json:"from"`
+	To         int               `json:"to"`
+	Oneway     bool              `json:"oneway"`
+	Lanes      int               `json:"lanes"`
+	SpeedLimit float64           `json:"speedLimit"`
+	Attributes map[string]string `json:"attributes"`
+}
+
+// lightState is the saved form of a Light.
+type lightState struct {
+	ID       int    `json:"id"`
+	Position Vector `json:"position"`
+	State    string `json:"state"`
+}
+
+// signalState is the saved form of a SignalController.
+type signalState struct {
+	ID                int             `json:"id"`
+	Plans             []planState     `json:"plans"`
+	Schedule          []ScheduleEntry `json:"schedule"`
+	CurrentPlan       int             `json:"currentPlan"`
+	CurrentPhase      int             `json:"currentPhase"`
+	Mode              string          `json:"mode"`
+	MinGreen          int             `json:"minGreen"`
+	MaxGreen          int             `json:"maxGreen"`
+	Gap               float64         `json:"gap"`
+	DetectionDistance float64         `json:"detectionDistance"`
+	PhaseStart        int             `json:"phaseStart"`
+	GreenTime         int             `json:"greenTime"`
+}
+
+// planState is the saved form of a SignalPlan.
+type planState struct {
+	Name        string       `json:"name"`
+	CycleLength int          `json:"cycleLength"`
+	Offset      int          `json:"offset"`
+	Phases      []phaseState `json:"phases"`
+}
+
+// phaseState is the saved form of a Phase.
+type phaseState struct {
+	Lights []int `json:"lights"`
+	Green  int   `json:"green"`
+	Amber  int   `json:"amber"`
+	AllRed int   `json:"allRed"`
+}
+
+// detectorState is the saved form of a Detector.
+type detectorState struct {
+	ID        int              `json:"id"`
+	Type      string           `json:"type"`
+	Position  Vector           `json:"position"`
+	Length    float64          `json:"length"`
+	Min       Vector           `json:"min"`
+	Max       Vector           `json:"max"`
+	Interval  int              `json:"interval"`
+	TimeStep  float64          `json:"timeStep"`
+	Occupying []int            `json:"occupying"`
+	LastEntry int              `json:"lastEntry"`
+	Records   []DetectorRecord `json:"records"`
+}
+
+// crosswalkState is the saved form of a Crosswalk.
+type crosswalkState struct {
+	ID          int     `json:"id"`
+	Start       Vector  `json:"start"`
+	End         Vector  `json:"end"`
+	Width       float64 `json:"width"`
+	Light       int     `json:"light"`
+	Pedestrians int     `json:"pedestrians"`
+}
+
+// junctionState is the saved form of a Junction.
+type junctionState struct {
+	Position    Vector   `json:"position"`
+	Control     string   `json:"control"`
+	Major       []Vector `json:"major"`
+	CriticalGap float64  `json:"criticalGap"`
+}
+
+// Save writes the complete state of the simulation to w, so it can be
+// continued later using LoadSimulation.
+func (s *Simulation) Save(w io.Writer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	parameters := s.environment.GetParameters()
+	state := simulationState{
+		Version:            stateVersion,
+		Tick:               s.currentTick,
+		TimeStep:           s.environment.GetTimeStep(),
+		StartTime:          s.startTime,
+		Seed:               s.seed,
+		RandomState:        s.source.state,
+		CurrentAgentID:     s.currentAgentID,
+		Environment:        s.environment.state(),
+		Metrics:            s.metrics,
+		Spawned:            s.spawned,
+		RecordTrajectories: s.recordTrajectories,
+		Trajectories:       s.trajectories,
+		Collisions:         s.collisions,
+		HaltOnCollision:    s.haltOnCollision,
+		Parameters:         &parameters}
+
+	for pair := range s.overlapping {
+		state.Overlapping = append(state.Overlapping, []int{pair.first, pair.second})
+	}
+	sort.Slice(state.Overlapping, func(i, j int) bool {
+		if state.Overlapping[i][0] != state.Overlapping[j][0] {
+			return state.Overlapping[i][0] < state.Overlapping[j][0]
+		}
+		return state.Overlapping[i][1] < state.Overlapping[j][1]
+	})
+
+	for _, agent := range s.agents {
+		a, err := encodeAgent(agent)
+		if err != nil {
+			return err
+		}
+		state.Agents = append(state.Agents, a)
+	}
+	for _, l := range s.lines {
+		vehicle, err := encodeAgent(l.vehicle)
+		if err != nil {
+			return err
+		}
+		state.Lines = append(state.Lines, busLineState{
+			Name:     l.name,
+			Route:    l.route,
+			Stops:    lineStopsState(l.stops),
+			Schedule: l.schedule,
+			Trips:    l.trips,
+			Vehicle:  vehicle,
+			Visits:   l.visits})
+	}
+	for _, d := range s.demands {
+		vehicle, err := encodeAgent(d.vehicle)
+		if err != nil {
+			return err
+		}
+		state.Demands = append(state.Demands, demandState{
+			Zones:     d.zones,
+			Flows:     d.flows,
+			Process:   d.process,
+			Profile:   d.profile,
+			RouteType: d.routeType,
+			Vehicle:   vehicle,
+			Start:     d.start,
+			Pending:   d.pending,
+			Expected:  d.expected,
+			Generated: d.generated,
+			Unrouted:  d.unrouted,
+			Arrived:   d.arrived,
+			Trips:     d.trips})
+	}
+	for _, agent := range s.agentsToSpawn {
+		a, err := encodeAgent(agent)
+		if err != nil {
+			return err
+		}
+		state.AgentsToSpawn = append(state.AgentsToSpawn, a)
+	}
+
+	return json.NewEncoder(w).Encode(state)
+}
+
+// SaveFile writes the complete state of the simulation to a file.
+func (s *Simulation) SaveFile(fileName string) error {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+
+	err = s.Save(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// LoadSimulation reads a simulation written by Save. The simulation
+// continues from the tick it was saved at.
+func LoadSimulation(r io.Reader) (*Simulation, error) {
+	// Parameters missing from the save, as in saves made before they
+	// were added, use the defaults
+	parameters := DefaultParameters()
+	state := simulationState{Parameters: &parameters}
+	if err := json.NewDecoder(r).Decode(&state); err != nil {
+		return nil, err
+	}
+	if state.Version != stateVersion {
+		return nil, fmt.Errorf("unsupported simulation version %v, expected %v", state.Version, stateVersion)
+	}
+
+	env, err := newEnvironmentFromState(state.Environment)
+	if err != nil {
+		return nil, err
+	}
+
+	sim := NewSimulation(env)
+	sim.currentTick = state.Tick
+	// Saves without a time step ran at one second a tick
+	sim.environment.timeStep = defaultTimeStep
+	if state.TimeStep > 0 {
+		sim.environment.timeStep = state.TimeStep
+	}
+	sim.startTime = state.StartTime
+	sim.SetSeed(state.Seed)
+	sim.source.state = state.RandomState
+	sim.currentAgentID = state.CurrentAgentID
+	sim.metrics = state.Metrics
+	sim.spawned = state.Spawned
+	sim.recordTrajectories = state.RecordTrajectories
+	sim.trajectories = state.Trajectories
+	sim.collisions = state.Collisions
+	sim.haltOnCollision = state.HaltOnCollision
+	// Saves without parameters used the defaults
+	if state.Parameters != nil {
+		if err := state.Parameters.validate(); err != nil {
+			return nil, err
+		}
+		sim.environment.parameters = state.Parameters
+	}
+	sim.overlapping = make(map[agentPair]bool)
+	for _, pair := range state.Overlapping {
+		if len(pair) != 2 {
+			return nil, fmt.Errorf("overlapping vehicles must be a pair: %v", pair)
+		}
+		sim.overlapping[agentPair{first: pair[0], second: pair[1]}] = true
+	}
+
+	for _, a := range state.Agents {
+		agent, err := decodeAgent(a)
+		if err != nil {
+			return nil, err
+		}
+		sim.agents = append(sim.agents, agent)
+		sim.environment.traffic.add(agent)
+	}
+	for id, l := range state.Lines {
+		vehicle, err := decodeVehicle(l.Vehicle)
+		if err != nil {
+			return nil, err
+		}
+		sim.lines = append(sim.lines, BusLine{
+			id:       id,
+			name:     l.Name,
+			route:    l.Route,
+			stops:    newLineStops(l.Stops),
+			schedule: l.Schedule,
+			trips:    l.Trips,
+			vehicle:  vehicle,
+			visits:   l.Visits})
+	}
+	for id, d := range state.Demands {
+		vehicle, err := decodeVehicle(d.Vehicle)
+		if err != nil {
+			return nil, err
+		}
+		demand, err := NewDemand(id, d.Zones, d.Flows, d.Process, d.Profile, d.RouteType, vehicle, d.Start)
+		if err != nil {
+			return nil, err
+		}
+		n := len(d.Flows)
+		if len(d.Pending) != n || len(d.Expected) != n || len(d.Generated) != n || len(d.Unrouted) != n || len(d.Arrived) != n {
+			return nil, fmt.Errorf("demand %v needs a count for each of its flows", id)
+		}
+		demand.pending = d.Pending
+		demand.expected = d.Expected
+		demand.generated = d.Generated
+		demand.unrouted = d.Unrouted
+		demand.arrived = d.Arrived
+		if d.Trips != nil {
+			demand.trips = d.Trips
+		}
+		sim.demands = append(sim.demands, demand)
+	}
+	for _, a := range state.AgentsToSpawn {
+		agent, err := decodeAgent(a)
+		if err != nil {
+			return nil, err
+		}
+		sim.agentsToSpawn = append(sim.agentsToSpawn, agent)
+	}
+
+	return sim, nil
+}
+
+// LoadSimulationFile reads a simulation written by SaveFile.
+func LoadSimulationFile(fileName string) (*Simulation, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return LoadSimulation(f)
+}
+
+// encodeAgent converts an agent into its saved form. Buses are saved with
+// their vehicle, other agents by the codec of their type.
+func encodeAgent(agent Agent) (agentState, error) {
+	if a, ok := agent.(Bus); ok {
+		vehicle, err := encodeAgent(a.Vehicle)
+		if err != nil {
+			return agentState{}, err
+		}
+		data, err := json.Marshal(busState{
+			Vehicle:  vehicle,
+			Line:     a.line,
+			Trip:     a.trip,
+			Stops:    lineStopsState(a.stops),
+			Serving:  a.serving,
+			Dwell:    a.dwell,
+			Departed: a.departed})
+		return agentState{Type: agent.GetType(), State: data}, err
+	}
+
+	codec, found := agentCodec(agent.GetType())
+	if !found || codec.Encode == nil {
+		return agentState{}, fmt.Errorf("unable to save agent of type: %v", agent.GetType())
+	}
+	data, err := codec.Encode(agent)
+	return agentState{Type: agent.GetType(), State: data}, err
+}
+
+// decodeAgent converts an agent's saved form back into an agent.
+func decodeAgent(state agentState) (Agent, error) {
+	if state.Type == "bus" {
+		var bs busState
+		if err := json.Unmarshal(state.State, &bs); err != nil {
+			return nil, err
+		}
+
+		vehicle, err := decodeVehicle(bs.Vehicle)
+		if err != nil {
+			return nil, err
+		}
+		b := Bus{
+			Vehicle:  vehicle,
+			line:     bs.Line,
+			trip:     bs.Trip,
+			stops:    newLineStops(bs.Stops),
+			serving:  bs.Serving,
+			dwell:    bs.Dwell,
+			departed: bs.Departed}
+		// SetID also sets up the bus's logger
+		return b.SetID(vehicle.id), nil
+	}
+
+	codec, found := agentCodec(state.Type)
+	if !found || codec.Decode == nil {
+		return nil, fmt.Errorf("unable to load agent of type: %v", state.Type)
+	}
+	return codec.Decode(state.State)
+}
+
+// saveVehicle converts a vehicle into its saved form.
+func saveVehicle(agent Agent) (json.RawMessage, error) {
+	a, ok := agent.(Vehicle)
+	if !ok {
+		return nil, fmt.Errorf("expected a vehicle not: %v", agent.GetType())
+	}
+	return json.Marshal(vehicleState{
+		ID:              a.id,
+		Position:        a.position,
+		Speed:           a.speed,
+		MaxSpeed:        a.maxSpeed,
+		Route:           a.route,
+		PlannedRoute:    a.plannedRoute,
+		CurrentWaypoint: a.currentWaypoint,
+		LastWaypoint:    a.lastWaypoint,
+		Lane:            a.lane,
+		Lanes:           a.lanes,
+		TwoWay:          a.twoWay,
+		LaneChangeWait:  a.laneChangeWait,
+		JunctionWait:    a.junctionWait,
+		Length:          a.length,
+		Width:           a.width,
+		Acceleration:    a.acceleration,
+		Deceleration:    a.deceleration,
+		Frequency:       a.frequency,
+		Model:           a.getModel().GetName(),
+		ModelParameters: a.getModel().GetParameters()})
+}
+
+// loadVehicle converts a vehicle's saved form back into a vehicle.
+func loadVehicle(data json.RawMessage) (Agent, error) {
+	var vs vehicleState
+	if err := json.Unmarshal(data, &vs); err != nil {
+		return nil, err
+	}
+
+	var v Vehicle
+	v.position = vs.Position
+	v.speed = vs.Speed
+	v.maxSpeed = vs.MaxSpeed
+	v.route = vs.Route
+	v.plannedRoute = vs.PlannedRoute
+	v.currentWaypoint = vs.CurrentWaypoint
+	v.lastWaypoint = vs.LastWaypoint
+	v.lane = vs.Lane
+	v.lanes = vs.Lanes
+	if v.lanes < 1 {
+		v.lanes = 1
+	}
+	v.twoWay = vs.TwoWay
+	v.laneChangeWait = vs.LaneChangeWait
+	v.junctionWait = vs.JunctionWait
+	// Vehicles saved before they had a size use the defaults
+	v.length = defaultVehicleLength
+	v.width = defaultVehicleWidth
+	v = v.SetSize(vs.Length, vs.Width)
+	v.acceleration = vs.Acceleration
+	v.deceleration = vs.Deceleration
+	v.frequency = vs.Frequency
+	model, err := NewCarFollowingModel(vs.Model, vs.ModelParameters)
+	if err != nil {
+		return nil, err
+	}
+	v.model = model
+	// SetID also sets up the vehicle's logger
+	return v.SetID(vs.ID), nil
+}
+
+// savePedestrian converts a pedestrian into its saved form.
+func savePedestrian(agent Agent) (json.RawMessage, error) {
+	a, ok := agent.(Pedestrian)
+	if !ok {
+		return nil, fmt.Errorf("expected a pedestrian not: %v", agent.GetType())
+	}
+	return json.Marshal(pedestrianState{
+		ID:              a.id,
+		Position:        a.position,
+		Speed:           a.speed,
+		WalkingSpeed:    a.walkingSpeed,
+		Route:           a.route,
+		PlannedRoute:    a.plannedRoute,
+		CurrentWaypoint: a.currentWaypoint,
+		LastWaypoint:    a.lastWaypoint,
+		Crossing:        a.crossing,
+		WaitingFor:      a.waitingFor,
+		Frequency:       a.frequency})
+}
+
+// loadPedestrian converts a pedestrian's saved form back into a
+// pedestrian.
+func loadPedestrian(data json.RawMessage) (Agent, error) {
+	var ps pedestrianState
+	if err := json.Unmarshal(data, &ps); err != nil {
+		return nil, err
+	}
+
+	var p Pedestrian
+	p.position = ps.Position
+	p.speed = ps.Speed
+	p.walkingSpeed = ps.WalkingSpeed
+	p.route = ps.Route
+	p.plannedRoute = ps.PlannedRoute
+	p.currentWaypoint = ps.CurrentWaypoint
+	p.lastWaypoint = ps.LastWaypoint
+	p.crossing = ps.Crossing
+	p.waitingFor = ps.WaitingFor
+	p.frequency = ps.Frequency
+	// SetID also sets up the pedestrian's logger
+	return p.SetID(ps.ID), nil
+}
+
+// decodeVehicle converts a vehicle's saved form back into a vehicle.
+func decodeVehicle(state agentState) (Vehicle, error) {
+	agent, err := decodeAgent(state)
+	if err != nil {
+		return Vehicle{}, err
+	}
+	vehicle, ok := agent.(Vehicle)
+	if !ok {
+		return Vehicle{}, fmt.Errorf("expected a vehicle not: %v", state.Type)
+	}
+	return vehicle, nil
+}
+
+// lineStopsState converts the stops on a bus line into their saved form.
+func lineStopsState(stops []lineStop) []lineStopState {
+	var state []lineStopState
+	for _, s := range stops {
+		state = append(state, lineStopState{Stop: s.stop, Position: s.position, Segment: s.segment, Time: s.time})
+	}
+	return state
+}
+
+// newLineStops converts the saved form of the stops on a bus line back
+// into the stops.
+func newLineStops(state []lineStopState) []lineStop {
+	var stops []lineStop
+	for _, s := range state {
+		stops = append(stops, lineStop{stop: s.Stop, position: s.Position, segment: s.Segment, time: s.Time})
+	}
+	return stops
+}
+
+// state converts the environment into its saved form.
+func (e *Environment) state() environmentState {
+	var state environmentState
+
+	for _, n := range e.nodes {
+		state.Nodes = append(state.Nodes, n.position)
+	}
+	for _, l := range e.links {
+		state.Links = append(state.Links, linkState{
+			From:       l.from,
+			To:         l.to,
+			Oneway:     l.oneway,
+			Lanes:      l.lanes,
+			SpeedLimit: l.speedLimit,
+			Attributes: l.attributes})
+	}
+	for _, l := range e.lights {
+		state.Lights = append(state.Lights, lightState{ID: l.id, Position: l.position, State: l.state})
+	}
+
+	for _, c := range e.signals {
+		signal := signalState{
+			ID:                c.id,
+			Schedule:          c.schedule,
+			CurrentPlan:       c.currentPlan,
+			CurrentPhase:      c.currentPhase,
+			Mode:              c.mode,
+			MinGreen:          c.minGreen,
+			MaxGreen:          c.maxGreen,
+			Gap:               c.gap,
+			DetectionDistance: c.detectionDistance,
+			PhaseStart:        c.phaseStart,
+			GreenTime:         c.greenTime}
+
+		for _, p := range c.plans {
+			plan := planState{Name: p.name, CycleLength: p.cycleLength, Offset: p.offset}
+			for _, phase := range p.phases {
+				plan.Phases = append(plan.Phases, phaseState{
+					Lights: phase.lights,
+					Green:  phase.green,
+					Amber:  phase.amber,
+					AllRed: phase.allRed})
+			}
+			signal.Plans = append(signal.Plans, plan)
+		}
+		state.Signals = append(state.Signals, signal)
+	}
+
+	for _, d := range e.detectors {
+		detector := detectorState{
+			ID:        d.id,
+			Type:      d.kind,
+			Position:  d.position,
+			Length:    d.length,
+			Min:       d.min,
+			Max:       d.max,
+			Interval:  d.interval,
+			TimeStep:  d.timeStep,
+			LastEntry: d.lastEntry,
+			Records:   d.records}
+		for id := range d.occupying {
+			detector.Occupying = append(detector.Occupying, id)
+		}
+		sort.Ints(detector.Occupying)
+		state.Detectors = append(state.Detectors, detector)
+	}
+
+	for _, c := range e.crosswalks {
+		state.Crosswalks = append(state.Crosswalks, crosswalkState{
+			ID:          c.id,
+			Start:       c.start,
+			End:         c.end,
+			Width:       c.width,
+			Light:       c.light,
+			Pedestrians: c.pedestrians})
+	}
+
+	for _, b := range e.busStops {
+		state.BusStops = append(state.BusStops, busStopState{
+			Position:    b.position,
+			ArrivalRate: b.arrivalRate,
+			Waiting:     b.waiting,
+			Boarded:     b.boarded})
+	}
+
+	for _, j := range e.junctions {
+		state.Junctions = append(state.Junctions, junctionState{
+			Position:    j.position,
+			Control:     j.control,
+			Major:       j.major,
+			CriticalGap: j.criticalGap})
+	}
+	state.DefaultJunction = e.defaultJunction
+
+	return state
+}
+
+// newEnvironmentFromState converts an environment's saved form back into
+// an environment.
+func newEnvironmentFromState(state environmentState) (Environment, error) {
+	env := NewEnvironment()
+
+	for _, pos := range state.Nodes {
+		env.addNode(pos)
+	}
+	for _, l := range state.Links {
+		if l.From < 0 || l.From >= len(env.nodes) || l.To < 0 || l.To >= len(env.nodes) {
+			return env, fmt.Errorf("link between unknown nodes: %v - %v", l.From, l.To)
+		}
+		env.addLink(env.nodes[l.From], env.nodes[l.To], l.Oneway, l.Lanes, l.SpeedLimit, l.Attributes)
+	}
+	for _, l := range state.Lights {
+		light := NewLight(l.ID, l.Position, true)
+		light.SetState(l.State)
+		env.addLight(light)
+	}
+
+	for _, s := range state.Signals {
+		var plans []SignalPlan
+		for _, p := range s.Plans {
+			var phases []Phase
+			for _, phase := range p.Phases {
+				phases = append(phases, NewPhase(phase.Lights, phase.Green, phase.Amber, phase.AllRed))
+			}
+			plan, err := NewSignalPlan(p.Name, phases, p.CycleLength, p.Offset)
+			if err != nil {
+				return env, err
+			}
+			plans = append(plans, plan)
+		}
+
+		c, err := NewSignalController(s.ID, plans, s.Schedule)
+		if err != nil {
+			return env, err
+		}
+		c.currentPlan = s.CurrentPlan
+		c.currentPhase = s.CurrentPhase
+		c.mode = s.Mode
+		c.minGreen = s.MinGreen
+		c.maxGreen = s.MaxGreen
+		c.gap = s.Gap
+		c.detectionDistance = s.DetectionDistance
+		c.phaseStart = s.PhaseStart
+		c.greenTime = s.GreenTime
+		env.signals = append(env.signals, c)
+	}
+
+	for _, d := range state.Detectors {
+		detector, err := newDetector(d.ID, d.Type, d.Interval)
+		if err != nil {
+			return env, err
+		}
+		detector.position = d.Position
+		detector.length = d.Length
+		detector.min = d.Min
+		detector.max = d.Max
+		detector.timeStep = d.TimeStep
+		detector.lastEntry = d.LastEntry
+		detector.records = d.Records
+		for _, id := range d.Occupying {
+			detector.occupying[id] = true
+		}
+		env.detectors = append(env.detectors, detector)
+	}
+
+	for _, c := range state.Crosswalks {
+		crosswalk, err := NewCrosswalk(c.ID, c.Start, c.End, c.Width, c.Light)
+		if err != nil {
+			return env, err
+		}
+		crosswalk.pedestrians = c.Pedestrians
+		env.crosswalks = append(env.crosswalks, crosswalk)
+	}
+
+	for _, b := range state.BusStops {
+		if err := env.AddBusStop(b.Position, b.ArrivalRate); err != nil {
+			return env, err
+		}
+		env.busStops[len(env.busStops)-1].waiting = b.Waiting
+		env.busStops[len(env.busStops)-1].boarded = b.Boarded
+	}
+
+	for _, j := range state.Junctions {
+		if err := env.AddJunction(j.Position, j.Control, j.Major, j.CriticalGap); err != nil {
+			return env, err
+		}
+	}
+	if err := env.SetDefaultJunction(state.DefaultJunction); err != nil {
+		return env, err
+	}
+
+	return env, nil
+}
@@ -0,0 +1,77 @@
+package simulation
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// stateScenario is a small scenario using lights, a signal, a detector, a
+// bus line, demand and spawning agents, with a time step and parameters
+// that are not the defaults.
+const stateScenario = `{
+  "environment": {"nodes": [[0,0],[500,0],[1000,0],[500,500]],
+    "links": [{"from":0,"to":1},{"from":1,"to":0},{"from":1,"to":2},{"from":2,"to":1},{"from":1,"to":3,"lanes":2},{"from":3,"to":1}]},
+  "seed": 7, "timeStep": 0.5,
+  "parameters": {"decelerationProbability": 0.3, "politeness": 0.5},
+  "lights": [{"position":[490,0]},{"position":[500,490],"stop":true}],
+  "signals": [{"plans":[{"name":"day","phases":[{"lights":[0],"green":20,"amber":3},{"lights":[1],"green":15,"amber":3,"allRed":2}]}],
+    "schedule":[{"start":0,"plan":"day"}], "mode":"actuated"}],
+  "detectors": [{"type":"point","position":[250,0],"interval":30}],
+  "busStops": [{"position":[700,0],"arrivalRate":0.05}],
+  "busLines": [{"name":"1","route":[[0,0],[500,0],[1000,0]],"stops":[0],"maxSpeed":10,"acceleration":1,"deceleration":3,"schedule":{"headway":120}}],
+  "demands": [{"zones":{"w":[[0,0]],"e":[[1000,0],[500,500]]},"flows":[{"origin":"w","destination":"e","flow":300}],"process":"poisson","maxSpeed":13,"acceleration":2,"deceleration":4}],
+  "agents": [
+    {"type":"vehicle","origin":[1000,0],"destination":[500,500],"maxSpeed":10,"acceleration":2,"deceleration":4,"model":"idm","frequency":20},
+    {"type":"pedestrian","startLocation":[480,-20],"route":[[480,20]]}
+  ]
+}`
+
+// newStateSimulation creates a simulation from the stateScenario.
+func newStateSimulation(t *testing.T) *Simulation {
+	sc, err := ReadScenario(strings.NewReader(stateScenario))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewSimulationFromScenario(sc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// TestSaveLoadContinue checks a simulation that is saved, loaded and run
+// on ends up the same as one that is run without stopping.
+func TestSaveLoadContinue(t *testing.T) {
+	uninterrupted := newStateSimulation(t)
+	uninterrupted.RunSteps(300)
+
+	saved := newStateSimulation(t)
+	saved.RunSteps(100)
+	var buf bytes.Buffer
+	if err := saved.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadSimulation(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.GetTimeStep() != saved.GetTimeStep() {
+		t.Fatalf("time step %v, want %v", loaded.GetTimeStep(), saved.GetTimeStep())
+	}
+	if loaded.GetParameters() != saved.GetParameters() {
+		t.Fatalf("parameters %+v, want %+v", loaded.GetParameters(), saved.GetParameters())
+	}
+	loaded.RunSteps(200)
+
+	var want, got bytes.Buffer
+	if err := uninterrupted.Save(&want); err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.Save(&got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(want.Bytes(), got.Bytes()) {
+		t.Fatal("loaded simulation differs from the uninterrupted simulation")
+	}
+}
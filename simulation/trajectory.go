@@ -0,0 +1,203 @@
+package simulation
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// CSVFormat exports trajectories as CSV with a row for each point.
+const CSVFormat = "csv"
+
+// JSONLinesFormat exports trajectories as a JSON object on each line.
+const JSONLinesFormat = "jsonl"
+
+// FCDFormat exports trajectories as floating car data XML in the form
+// written by SUMO's fcd-output.
+const FCDFormat = "fcd"
+
+// TrajectoryPoint stores where an agent was at the end of a tick.
+type TrajectoryPoint struct {
+	// Tick is the tick the point was recorded at.
+	Tick int `json:"tick"`
+	// Time is the number of seconds simulated by the end of the tick.
+	Time float64 `json:"time"`
+	// ID is the id of the agent.
+	ID int `json:"id"`
+	// Type is the type of agent.
+	Type string `json:"type"`
+	// Position is the position of the agent.
+	Position Vector `json:"position"`
+	// Lane is the lane the agent is in.
+	Lane int `json:"lane"`
+	// LanePosition is the position of the agent in the centre
+	// of its lane.
+	LanePosition Vector `json:"lanePosition"`
+	// Speed is the speed of the agent.
+	Speed float64 `json:"speed"`
+	// Waypoint is the position the agent is travelling towards.
+	Waypoint Vector `json:"waypoint"`
+}
+
+// recordTrajectories adds a point for each agent at the given tick, which
+// ends the given number of seconds into the simulation.
+func recordTrajectories(points []TrajectoryPoint, tick int, time float64, agents []Agent) []TrajectoryPoint {
+	for _, agent := range agents {
+		points = append(points, TrajectoryPoint{
+			Tick:         tick,
+			Time:         time,
+			ID:           agent.GetID(),
+			Type:         agent.GetType(),
+			Position:     agent.GetPosition(),
+			Lane:         agent.GetLane(),
+			LanePosition: agent.GetLanePosition(),
+			Speed:        agent.GetSpeed(),
+			Waypoint:     agent.GetCurrentWaypoint()})
+	}
+	return points
+}
+
+// angle returns the heading of the agent in degrees, clockwise from the
+// positive y axis as used by FCD output.
+func (p TrajectoryPoint) angle() float64 {
+	dx := p.Waypoint.x - p.Position.x
+	dy := p.Waypoint.y - p.Position.y
+	degrees := math.Atan2(dx, dy) * 180 / math.Pi
+	if degrees < 0 {
+		degrees += 360
+	}
+	return degrees
+}
+
+// SetRecordTrajectories turns the recording of every agent's trajectory
+// on or off. Recording is off unless it is turned on, as the points take
+// up memory for every agent at every tick.
+func (s *Simulation) SetRecordTrajectories(record bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.recordTrajectories = record
+}
+
+// GetRecordTrajectories returns true if the simulation is recording the
+// trajectories of its agents.
+func (s *Simulation) GetRecordTrajectories() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.recordTrajectories
+}
+
+// ExportTrajectories writes the trajectories recorded so far to w in the
+// given format, either CSVFormat, JSONLinesFormat or FCDFormat. The points
+// are copied before they are written, so the simulation can keep running
+// while a slow writer is sent them.
+func (s *Simulation) ExportTrajectories(w io.Writer, format string) error {
+	s.mu.Lock()
+	points := append([]TrajectoryPoint{}, s.trajectories...)
+	s.mu.Unlock()
+
+	var err error
+	switch format {
+	case CSVFormat:
+		err = exportTrajectoriesCSV(w, points)
+	case JSONLinesFormat:
+		err = exportTrajectoriesJSONLines(w, points)
+	case FCDFormat:
+		err = exportTrajectoriesFCD(w, points)
+	default:
+		return fmt.Errorf("unknown trajectory format: %v", format)
+	}
+
+	if err != nil {
+		return fmt.Errorf("unable to export trajectories: %v", err)
+	}
+	return nil
+}
+
+// exportTrajectoriesCSV writes a row for each point.
+func exportTrajectoriesCSV(w io.Writer, points []TrajectoryPoint) error {
+	out := csv.NewWriter(w)
+	format := func(f float64) string {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+
+	out.Write([]string{"tick", "time", "id", "type", "x", "y", "lane", "laneX", "laneY", "speed", "waypointX", "waypointY"})
+	for _, p := range points {
+		out.Write([]string{
+			strconv.Itoa(p.Tick),
+			format(p.Time),
+			strconv.Itoa(p.ID),
+			p.Type,
+			format(p.Position.x),
+			format(p.Position.y),
+			strconv.Itoa(p.Lane),
+			format(p.LanePosition.x),
+			format(p.LanePosition.y),
+			format(p.Speed),
+			format(p.Waypoint.x),
+			format(p.Waypoint.y)})
+	}
+
+	out.Flush()
+	return out.Error()
+}
+
+// exportTrajectoriesJSONLines writes a json object for each point.
+func exportTrajectoriesJSONLines(w io.Writer, points []TrajectoryPoint) error {
+	out := bufio.NewWriter(w)
+	encoder := json.NewEncoder(out)
+	for _, p := range points {
+		if err := encoder.Encode(p); err != nil {
+			return err
+		}
+	}
+	return out.Flush()
+}
+
+// exportTrajectoriesFCD writes the points grouped into a timestep for
+// each tick. The points must be in tick order.
+func exportTrajectoriesFCD(w io.Writer, points []TrajectoryPoint) error {
+	out := bufio.NewWriter(w)
+
+	fmt.Fprintln(out, `<?xml version="1.0" encoding="UTF-8"?>`)
+	fmt.Fprintln(out, `<fcd-export>`)
+	for i, p := range points {
+		if i == 0 || points[i-1].Tick != p.Tick {
+			if i > 0 {
+				fmt.Fprintln(out, `    </timestep>`)
+			}
+			fmt.Fprintf(out, "    <timestep time=\"%.2f\">\n", p.Time)
+		}
+		// Pedestrians are written as people without a lane
+		if p.Type == "pedestrian" {
+			fmt.Fprintf(out,
+				"        <person id=\"%v\" x=\"%.2f\" y=\"%.2f\" angle=\"%.2f\" type=\"%v\" speed=\"%.2f\"/>\n",
+				p.ID, p.Position.x, p.Position.y, p.angle(), escapeXML(p.Type), p.Speed)
+			continue
+		}
+		fmt.Fprintf(out,
+			"        <vehicle id=\"%v\" x=\"%.2f\" y=\"%.2f\" angle=\"%.2f\" type=\"%v\" speed=\"%.2f\" lane=\"%v\"/>\n",
+			p.ID, p.LanePosition.x, p.LanePosition.y, p.angle(), escapeXML(p.Type), p.Speed, p.Lane)
+	}
+	if len(points) > 0 {
+		fmt.Fprintln(out, `    </timestep>`)
+	}
+	fmt.Fprintln(out, `</fcd-export>`)
+
+	return out.Flush()
+}
+
+// escapeXML returns the text with the characters that can not be used in
+// an XML attribute escaped.
+func escapeXML(text string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(text))
+	return b.String()
+}
@@ -1,6 +1,9 @@
 package simulation
 
-import "math"
+import (
+	"encoding/json"
+	"math"
+)
 
 // Vector stores the x and y values.
 type Vector struct {
@@ -48,3 +51,46 @@ func (v *Vector) DistanceTo(target Vector) float64 {
 	dy := v.y - target.y
 	return math.Sqrt((dx * dx) + (dy * dy))
 }
+
+// DirectionTo calculates the unit vector pointing towards a target.
+// If the target is at the same position a zero vector is returned.
+func (v *Vector) DirectionTo(target Vector) Vector {
+	distance := v.DistanceTo(target)
+	if distance == 0 {
+		return Vector{}
+	}
+	return Vector{x: (target.x - v.x) / distance, y: (target.y - v.y) / distance}
+}
+
+// DistanceToSegment calculates the shortest distance from the vector to
+// the line segment between start and end.
+func (v *Vector) DistanceToSegment(start, end Vector) float64 {
+	dx := end.x - start.x
+	dy := end.y - start.y
+	lengthSquared := dx*dx + dy*dy
+	if lengthSquared == 0 {
+		return v.DistanceTo(start)
+	}
+
+	// Project the vector onto the segment and clamp it to the ends
+	t := ((v.x-start.x)*dx + (v.y-start.y)*dy) / lengthSquared
+	t = math.Max(0, math.Min(1, t))
+	closest := Vector{x: start.x + t*dx, y: start.y + t*dy}
+	return v.DistanceTo(closest)
+}
+
+// MarshalJSON encodes the vector as a [x, y] array.
+func (v Vector) MarshalJSON() ([]byte, error) {
+	return json.Marshal([2]float64{v.x, v.y})
+}
+
+// UnmarshalJSON decodes a vector from a [x, y] array.
+func (v *Vector) UnmarshalJSON(data []byte) error {
+	var values [2]float64
+	if err := json.Unmarshal(data, &values); err != nil {
+		return err
+	}
+	v.x = values[0]
+	v.y = values[1]
+	return nil
+}
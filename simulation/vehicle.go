@@ -2,9 +2,9 @@ package simulation
 
 import (
 	"encoding/json"
+	"fmt"
 	"math"
 	"math/rand"
-	"time"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -22,9 +22,27 @@ type Vehicle struct {
 	// route stores a list of waypoints the vehicle must visit to
 	// reach its final destination.
 	route []Vector
+	// plannedRoute stores the full route the vehicle was given,
+	// unlike route waypoints are not removed once visited.
+	plannedRoute []Vector
 	// currentWaypoint stores the position of the
 	// vehicles current destination.
 	currentWaypoint Vector
+	// lastWaypoint stores the position the vehicle started
+	// travelling towards its current waypoint from.
+	lastWaypoint Vector
+	// lane is the lane the vehicle is in, 0 being the
+	// kerbside lane.
+	lane int
+	// lanes is the number of lanes on the link the vehicle
+	// is travelling along.
+	lanes int
+	// twoWay is true if traffic travels the other way along the
+	// link the vehicle is travelling along.
+	twoWay bool
+	// laneChangeWait is the number of ticks until the vehicle
+	// can change lane again.
+	laneChangeWait int
 	// acceleration stores the rate at which the
 	// vehicle can increase its speed.
 	acceleration float64
@@ -34,11 +52,59 @@ type Vehicle struct {
 	// frequency is how often the vehicle spawns in the
 	// simulation.
 	frequency int
+	// model is the car-following model that decides the
+	// vehicle's speed.
+	model CarFollowingModel
+	// junctionWait is the number of ticks the vehicle has been
+	// stopped at the stop line of the junction ahead.
+	junctionWait int
+	// length is the length of the vehicle, its position
+	// being the centre of its front bumper.
+	length float64
+	// width is the width of the vehicle.
+	width float64
 
 	// Logger is used to give a context based log to the stdout
 	Logger *log.Entry
 }
 
+// vehicleParameters are the parameters a vehicle is created with when
+// added by type name.
+type vehicleParameters struct {
+	StartSpeed      float64            `json:"startSpeed" description:"The speed the vehicle starts at, in metres per second."`
+	MaxSpeed        float64            `json:"maxSpeed" description:"The highest speed the vehicle can reach, in metres per second."`
+	Acceleration    float64            `json:"acceleration" description:"The rate the speed can increase, in metres per second squared."`
+	Deceleration    float64            `json:"deceleration" description:"The rate the speed can decrease, in metres per second squared."`
+	Lane            int                `json:"lane" description:"The lane the vehicle starts in, 0 being the kerbside lane."`
+	Model           string             `json:"model" description:"The car-following model used, either rules, idm or gipps. Defaults to rules."`
+	ModelParameters map[string]float64 `json:"modelParameters" description:"The values the car-following model is set up with."`
+	Length          float64            `json:"length" description:"The length of the vehicle. Defaults to 4.5."`
+	Width           float64            `json:"width" description:"The width of the vehicle. Defaults to 1.8."`
+}
+
+func init() {
+	mustRegisterAgentType(
+		"vehicle",
+		"A vehicle driving along the roads, following a car-following model.",
+		func() interface{} { return &vehicleParameters{} },
+		func(spec AgentSpec, parameters interface{}) (Agent, error) {
+			p := parameters.(*vehicleParameters)
+			if p.Length < 0 || p.Width < 0 {
+				return nil, fmt.Errorf("vehicle size can not be negative: %v, %v", p.Length, p.Width)
+			}
+			if p.Acceleration <= 0 {
+				return nil, fmt.Errorf("vehicle acceleration must be more than 0: %v", p.Acceleration)
+			}
+			model, err := NewCarFollowingModel(p.Model, p.ModelParameters)
+			if err != nil {
+				return nil, err
+			}
+			v := NewVehicle(-1, spec.Start, p.StartSpeed, p.MaxSpeed, p.Acceleration, p.Deceleration, spec.Route, spec.Frequency)
+			return v.SetModel(model).SetSize(p.Length, p.Width).SetLane(p.Lane), nil
+		},
+		AgentCodec{Encode: saveVehicle, Decode: loadVehicle, Describe: describeVehicle})
+}
+
 // NewVehicle creates a new vehicle and intilises its values
 // using the paramaters provided.
 func NewVehicle(
@@ -51,7 +117,6 @@ func NewVehicle(
 	route []Vector,
 	freq int) Vehicle {
 
-	rand.Seed(time.Now().Unix())
 	// Init values
 	v := Vehicle{}
 
@@ -68,26 +133,35 @@ func NewVehicle(
 	v.acceleration = acceleration
 	v.deceleration = deceleration
 	v.route = route
+	v.plannedRoute = route
 	v.frequency = freq
+	v.model = Rules{}
+	v.lanes = 1
+	v.length = defaultVehicleLength
+	v.width = defaultVehicleWidth
 	// Get the first waypoint
 	v.getNextWaypoint()
+	v.lastWaypoint = startPostiion
 
 	return v
 }
 
 // Act simulates a vehicles behaviour for one tick in the simulation.
 // If true is returned the vehicle has reached its final destination.
-func (v Vehicle) Act(agents []Agent, env Environment) (Agent, bool) {
+func (v Vehicle) Act(agents []Agent, env Environment, rng *rand.Rand) (Agent, bool) {
 	// Check if waypoint reached
-	if v.updateWaypoint() {
+	if v.updateWaypoint(env.GetParameters().Margin) {
 		return v, true
 	}
 
+	// Move into a better lane
+	v.changeLane(agents, env)
+
 	// Update speed based on surroundings
-	v.updateSpeed(agents, env)
+	v.updateSpeed(agents, env, rng)
 
 	// Update the position of the vehicle
-	v.updatePosition()
+	v.updatePosition(env.GetTimeStep())
 	return v, false
 }
 
@@ -116,6 +190,11 @@ func (v Vehicle) GetRoute() []Vector {
 	return v.route
 }
 
+// GetPlannedRoute returns the full route the vehicle was given.
+func (v Vehicle) GetPlannedRoute() []Vector {
+	return v.plannedRoute
+}
+
 // GetType returns the name of the type of agent, in this case "vehicle"
 func (v Vehicle) GetType() string {
 	return "vehicle"
@@ -126,9 +205,15 @@ func (v Vehicle) GetInfo() string {
 	type vehicleInfo struct {
 		ID              int         `json:"id"`
 		Position        []float64   `json:"position"`
+		Lane            int         `json:"lane"`
+		LanePosition    []float64   `json:"lanePosition"`
 		Speed           float64     `json:"speed"`
 		CurrentWaypoint []float64   `json:"currentWaypoint"`
 		Route           [][]float64 `json:"route"`
+		PlannedRoute    [][]float64 `json:"plannedRoute"`
+		Model           string      `json:"model"`
+		Length          float64     `json:"length"`
+		Width           float64     `json:"width"`
 		Type            string      `json:"type"`
 	}
 
@@ -139,19 +224,30 @@ func (v Vehicle) GetInfo() string {
 	}
 
 	p := v.GetPosition()
+	lp := v.GetLanePosition()
 	cwp := v.GetCurrentWaypoint()
 	// Convert []Vector to [][]float64
 	var r [][]float64
 	for _, wp := range v.GetRoute() {
 		r = append(r, wp.ConvertToSlice())
 	}
+	var pr [][]float64
+	for _, wp := range v.GetPlannedRoute() {
+		pr = append(pr, wp.ConvertToSlice())
+	}
 
 	vInfo := vehicleInfo{
 		ID:              v.GetID(),
 		Position:        p.ConvertToSlice(),
+		Lane:            v.GetLane(),
+		LanePosition:    lp.ConvertToSlice(),
 		Speed:           v.GetSpeed(),
 		CurrentWaypoint: cwp.ConvertToSlice(),
 		Route:           r,
+		PlannedRoute:    pr,
+		Model:           v.getModel().GetName(),
+		Length:          v.length,
+		Width:           v.width,
 		Type:            v.GetType()}
 
 	// Convert the infomation into a json string
@@ -168,6 +264,48 @@ func (v Vehicle) GetFrequency() int {
 	return v.frequency
 }
 
+// GetModel returns the car-following model used by the vehicle.
+func (v Vehicle) GetModel() CarFollowingModel {
+	return v.getModel()
+}
+
+// SetModel changes the car-following model used by the vehicle.
+func (v Vehicle) SetModel(model CarFollowingModel) Vehicle {
+	v.model = model
+	return v
+}
+
+// GetLength returns the length of the vehicle.
+func (v Vehicle) GetLength() float64 {
+	return v.length
+}
+
+// GetWidth returns the width of the vehicle.
+func (v Vehicle) GetWidth() float64 {
+	return v.width
+}
+
+// SetSize changes the length and width of the vehicle. A length or width
+// of 0 keeps the current value.
+func (v Vehicle) SetSize(length, width float64) Vehicle {
+	if length > 0 {
+		v.length = length
+	}
+	if width > 0 {
+		v.width = width
+	}
+	return v
+}
+
+// getModel returns the vehicle's car-following model, vehicles
+// without one follow the Rules.
+func (v *Vehicle) getModel() CarFollowingModel {
+	if v.model == nil {
+		return Rules{}
+	}
+	return v.model
+}
+
 // SetID changes the value of the vehicle's id.
 func (v Vehicle) SetID(newID int) Agent {
 	v.id = newID
@@ -185,82 +323,72 @@ func (v Vehicle) SetFrequency(freq int) Agent {
 	return v
 }
 
-// updateVelocity calculates the vehicles next velocity based upon
-// the vehicle's surroundings.
-func (v *Vehicle) updateSpeed(agents []Agent, env Environment) {
-	// 1. Slow down for lights
-	// 2. Slow down to touch waypoint
-	// 3. Slow down due to other agents
-	// 4. Accelerate if space
-	// 5. Random Decelerate
+// updateSpeed uses the vehicle's car-following model to calculate the
+// vehicle's next speed based upon the vehicle's surroundings.
+func (v *Vehicle) updateSpeed(agents []Agent, env Environment, rng *rand.Rand) {
+	v.setSpeed(v.getSurroundings(agents, env), env.GetParameters().MinimumGap, rng)
+}
+
+// setSpeed uses the vehicle's car-following model to choose the
+// vehicle's next speed given its surroundings, leaving at least the
+// minimum gap to the vehicle infront.
+func (v *Vehicle) setSpeed(surroundings Surroundings, minimumGap float64, rng *rand.Rand) {
+	v.speed = v.getModel().NextSpeed(surroundings, rng)
+
+	// Never drive into the back of the vehicle infront
+	if surroundings.Gap < math.MaxFloat64 {
+		v.speed = math.Min(v.speed, math.Max(surroundings.Gap-minimumGap, 0)/surroundings.step())
+	}
+	v.Logger.Debugf("%v, v: %v", v.getModel().GetName(), v.speed)
+}
+
+// getSurroundings finds what the vehicle can see ahead of it.
+func (v *Vehicle) getSurroundings(agents []Agent, env Environment) Surroundings {
+	traffic := env.trafficFor(agents)
+	surroundings := Surroundings{
+		TimeStep:                env.GetTimeStep(),
+		Speed:                   v.speed,
+		MaxSpeed:                v.maxSpeed,
+		Acceleration:            v.acceleration,
+		Deceleration:            v.deceleration,
+		LightDistance:           math.MaxFloat64,
+		WaypointDistance:        v.position.DistanceTo(v.currentWaypoint),
+		DecelerationProbability: env.GetParameters().DecelerationProbability}
 
-	// Slow down for lights
-	light, found := env.GetLightAt(v.currentWaypoint)
 	// Check if there is a light infront and
 	// if it indicates stop
+	light, found := env.GetLightAt(v.currentWaypoint)
 	if found && light.GetStop() {
-		// Get the distance to the traffic light
-		distanceToLight := v.position.DistanceTo(light.GetPosition())
-
-		// If the vehicle is going to pass the light stop
-		if distanceToLight <= v.speed+v.acceleration {
-			// Stop the vehicle
-			v.speed = 0
-
-			v.Logger.Debugf("Light, v: %v", v.speed)
-			return
-		}
+		surroundings.LightDistance = v.position.DistanceTo(light.GetPosition())
 	}
 
-	// Slow down to touch waypoint
-	distanceToWaypoint := v.position.DistanceTo(v.currentWaypoint)
-	if distanceToWaypoint <= v.speed {
-		v.speed = distanceToWaypoint
+	// Give way to pedestrians on a crosswalk ahead
+	surroundings.LightDistance = math.Min(surroundings.LightDistance,
+		env.crossingDistance(v.position, v.currentWaypoint))
 
-		v.Logger.Debugf("Waypoint, v: %v", v.speed)
-		return
+	// Give way at a junction without signals
+	if distance, wait := v.giveWay(traffic, env); wait {
+		surroundings.LightDistance = math.Min(surroundings.LightDistance, distance)
 	}
 
 	// Get the agent infront
-	c, gap := v.getVehicleInfront(agents)
+	c, gap := v.getVehicleInfront(traffic)
+	surroundings.Gap = gap
 	if c != nil {
+		surroundings.LeaderSpeed = c.GetSpeed()
 		v.Logger.Debugf("A: %v, Gap: %v", c.GetID(), gap)
 	}
 
-	// Slowing down due to other cars:
-	//	Each vehicle (speed v) with gap ≤ v−d reduces its speed to gap: v → gap.
-	//	if gap ≤ v-d then v = gap
-	if gap <= v.speed {
-		v.speed = gap
-		// decelerate to create a gap between the vehicles
-		v.decelerate()
-
-		v.Logger.Debugf("Gap, v: %v", v.speed)
-		return
-	}
-
-	// Acceleration of free vehicles:
-	// 	Each vehicle of speed v < vmax with gap ≥ v+1 accelerates to v+1.
-	// 	if v < vmax & gap ≥ v + a then v = v + a
-	if v.speed < v.maxSpeed && gap >= v.speed+v.acceleration {
-		v.accelerate()
-		return
-	}
-
-	// Randomization:
-	//	Each vehicle reduces its speed by deceleration with probability
-	//	1/2: v → max[ v − 1, 0 ]
-	if rand.Float64() >= decelerationProbability {
-		v.decelerate()
-		return
-	}
-
-	v.Logger.Debugf("Const, v: %v", v.speed)
+	return surroundings
 }
 
 // updatePosition uses the vehicle's current speed to calculate
-// the vehicles new velocity and position.
-func (v *Vehicle) updatePosition() {
+// the vehicles new velocity and position after the given number
+// of seconds.
+func (v *Vehicle) updatePosition(timeStep float64) {
+	// The distance travelled this tick
+	distance := v.speed * timeStep
+
 	// Convert the vehicle's current speed into its x and y velocitys
 
 	// Go East
@@ -275,8 +403,8 @@ func (v *Vehicle) updatePosition() {
 			dx := v.currentWaypoint.x - v.position.x
 			angle := math.Atan(dy / dx)
 
-			v.position.y -= v.speed * math.Sin(angle)
-			v.position.x += v.speed * math.Cos(angle)
+			v.position.y -= distance * math.Sin(angle)
+			v.position.x += distance * math.Cos(angle)
 		} else {
 
 			// SE
@@ -287,8 +415,8 @@ func (v *Vehicle) updatePosition() {
 			dx := v.currentWaypoint.x - v.position.x
 			angle := math.Atan(dy / dx)
 
-			v.position.y += v.speed * math.Sin(angle)
-			v.position.x += v.speed * math.Cos(angle)
+			v.position.y += distance * math.Sin(angle)
+			v.position.x += distance * math.Cos(angle)
 		}
 	} else {
 
@@ -302,8 +430,8 @@ func (v *Vehicle) updatePosition() {
 			dx := v.position.x - v.currentWaypoint.x
 			angle := math.Atan(dy / dx)
 
-			v.position.y -= v.speed * math.Sin(angle)
-			v.position.x -= v.speed * math.Cos(angle)
+			v.position.y -= distance * math.Sin(angle)
+			v.position.x -= distance * math.Cos(angle)
 		} else {
 			// SW
 			// a = Atan(ty-py/px-tx)
@@ -313,15 +441,16 @@ func (v *Vehicle) updatePosition() {
 			dx := v.position.x - v.currentWaypoint.x
 			angle := math.Atan(dy / dx)
 
-			v.position.y += v.speed * math.Sin(angle)
-			v.position.x -= v.speed * math.Cos(angle)
+			v.position.y += distance * math.Sin(angle)
+			v.position.x -= distance * math.Cos(angle)
 		}
 	}
 }
 
-// updateWaypoint checks to if the vehicle has reached its current destination,
-// if the vehicle's final destination is reached true is returned.
-func (v *Vehicle) updateWaypoint() bool {
+// updateWaypoint checks to if the vehicle is within the margin of its
+// current destination, if the vehicle's final destination is reached true
+// is returned.
+func (v *Vehicle) updateWaypoint(margin float64) bool {
 	// Check if the vehicle has reached its current waypoint
 	if v.position.InRange(v.currentWaypoint, margin) {
 		// Check if the vehicle has reached its final destination
@@ -344,6 +473,8 @@ func (v *Vehicle) getNextWaypoint() bool {
 	}
 
 	// Update the currentWaypoint to the next waypoint on route
+	v.junctionWait = 0
+	v.lastWaypoint = v.currentWaypoint
 	v.currentWaypoint = v.route[0]
 	// Remove the currentWaypoint from the route.
 	v.route = v.route[1:]
@@ -352,60 +483,58 @@ func (v *Vehicle) getNextWaypoint() bool {
 	return true
 }
 
-// getVehicleInfront finds the agent which is the closest vehicle infront.
-// If nil is returned there are no agents infront of the vehicle.
-func (v *Vehicle) getVehicleInfront(agents []Agent) (closest Agent, distance float64) {
+// onSameLink returns true if the agent is a vehicle travelling along the
+// same link as the vehicle. Vehicles on other links heading to the same
+// waypoint are left to the junction to sort out. Vehicles added part way
+// along a link start from where they were added, so a vehicle heading to
+// the waypoint from the same direction is on the same link.
+func (v *Vehicle) onSameLink(agent Agent) bool {
+	other, ok := asVehicle(agent)
+	if !ok || !other.currentWaypoint.Equals(v.currentWaypoint) {
+		return false
+	}
+	if other.lastWaypoint.Equals(v.lastWaypoint) {
+		return true
+	}
+	direction := v.lastWaypoint.DirectionTo(v.currentWaypoint)
+	return direction.DistanceTo(other.lastWaypoint.DirectionTo(other.currentWaypoint)) < sameDirection
+}
+
+// getVehicleInfront finds the agent which is the closest vehicle infront
+// on the same link and the gap between the vehicle's front and the agent's
+// rear bumper. If nil is returned there are no agents infront of the
+// vehicle.
+func (v *Vehicle) getVehicleInfront(traffic *trafficIndex) (closest Agent, distance float64) {
 	closest = nil
 	distance = math.MaxFloat64
 	vehicleToWaypoint := v.position.DistanceTo(v.currentWaypoint)
 
-	// Loop over all the agents in the simulation
-	for _, a := range agents {
-		// Check the agent is not its self
-		if a.GetID() != v.GetID() {
-			// Check if the agent is travelling in the same direction
-			if v.currentWaypoint.Equals(a.GetCurrentWaypoint()) {
-				// Calculate the distance between the current agent
-				// and its current waypoint
-				aPosition := a.GetPosition()
-				agentToWaypoint := aPosition.DistanceTo(a.GetCurrentWaypoint())
-				// Check if the current agent is closer to the waypoint then
-				// the vehicle. If this is so the current agent must be infront
-				// the vehicle.
-				if agentToWaypoint < vehicleToWaypoint {
-					// Check if the current agent is the closer to the vehicle
-					if v.position.DistanceTo(aPosition) <= distance {
-						// Update the closest agent
-						distance = v.position.DistanceTo(aPosition)
-						closest = a
-					}
-				}
-			}
+	// Look along the queue of agents heading to the same waypoint that
+	// are closer to it than the vehicle, and so infront of it, starting
+	// with the nearest
+	traffic.eachAhead(v.currentWaypoint, vehicleToWaypoint, func(a Agent, agentToWaypoint float64) bool {
+		// The rest of the queue is further away than the closest agent
+		if vehicleToWaypoint-agentToWaypoint > distance {
+			return false
 		}
+		// Check the agent is not its self and is in the same lane
+		// of the same link
+		if a.GetID() == v.id || a.GetLane() != v.lane || !v.onSameLink(a) {
+			return true
+		}
+		// Check if the current agent is the closer to the vehicle,
+		// taking the agent with the highest id when as close
+		aPosition := a.GetPosition()
+		d := v.position.DistanceTo(aPosition)
+		if d < distance || (d == distance && a.GetID() > closest.GetID()) {
+			// Update the closest agent
+			distance = d
+			closest = a
+		}
+		return true
+	})
+	if closest != nil {
+		distance -= agentLength(closest)
 	}
 	return
 }
-
-// accelerate increases the vehicles speed. When a vehicle reaches its maximum
-// speed the vehicle can no longer accelerate.
-func (v *Vehicle) accelerate() {
-	v.speed += v.acceleration
-	// Check if exceeded max speed
-	if v.speed > v.maxSpeed {
-		v.speed = v.maxSpeed
-	}
-
-	v.Logger.Debugf("Acc. v: %v", v.speed)
-}
-
-// decelerate decreases the vehicles speed. A vehicle can only decelerate to
-// a speed of 0.
-func (v *Vehicle) decelerate() {
-	v.speed -= v.deceleration
-
-	if v.speed < 0 {
-		v.speed = 0
-	}
-
-	v.Logger.Debugf("Dec. v: %v", v.speed)
-}
@@ -20,6 +20,9 @@ type message struct {
 	// Goals stores the x,y coordinates of each agent's current
 	// waypoint
 	Goals []vector2 `json:"goals"`
+	// Lanes stores the lane each agent is in, 0 being the
+	// kerbside lane
+	Lanes []int `json:"lanes"`
 	// LightPostions store the positions of all the traffic lights in
 	// the simulation
 	LightPostions []vector2 `json:"lightPositions"`
@@ -175,7 +178,7 @@ func (u *UnityServer) SendMessage(msg string) {
 }
 
 // SendSimulation creates a json string and sends it to the unity application.
-func (u *UnityServer) SendSimulation(agents, waypoints, goals, lightPositions [][]float64, lightStates []bool, tick int, camPos, camDir []float64) {
+func (u *UnityServer) SendSimulation(agents, waypoints, goals [][]float64, lanes []int, lightPositions [][]float64, lightStates []bool, tick int, camPos, camDir []float64) {
 	// Convert agents [][]float64 into []vector2
 	var agentVec []vector2
 	for i := 0; i < len(agents); i++ {
@@ -203,6 +206,7 @@ func (u *UnityServer) SendSimulation(agents, waypoints, goals, lightPositions []
 		Agents:          agentVec,
 		Waypoints:       waypointVec,
 		Goals:           goalsVec,
+		Lanes:           lanes,
 		Tick:            tick,
 		CameraPosition:  camPos,
 		CameraDirection: camDir,
@@ -314,8 +318,8 @@ func (u *UnityServer) Connected() bool {
 
 // GetImageFilepath sends the simulation to the unity application then
 // waits for a response. The filepath to the image gererated is returned.
-func (u *UnityServer) GetImageFilepath(agents, waypoints, goals, lightPositions [][]float64, lightStates []bool, tick int, camPos, camDir []float64) string {
-	u.SendSimulation(agents, waypoints, goals, lightPositions, lightStates, tick, camPos, camDir)
+func (u *UnityServer) GetImageFilepath(agents, waypoints, goals [][]float64, lanes []int, lightPositions [][]float64, lightStates []bool, tick int, camPos, camDir []float64) string {
+	u.SendSimulation(agents, waypoints, goals, lanes, lightPositions, lightStates, tick, camPos, camDir)
 	filepath := <-u.currentFilePath
 	u.Logger.Debugf("Filepath got - GetImage: %v", filepath)
 	return filepath